@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"strings"
+
+	"github.com/apparentlymart/go-pilfer/pilfer"
+	flag "github.com/ogier/pflag"
+)
+
+var buildGOOS = flag.String("goos", "", "target operating system for selecting source files (default $GOOS)")
+var buildGOARCH = flag.String("goarch", "", "target architecture for selecting source files (default $GOARCH)")
+var buildTags = flag.String("tags", "", "comma- or space-separated build tags to consider satisfied when loading sources")
+var buildCgo = flag.String("cgo", "", "whether to include cgo files when loading sources: true or false")
+var compare = flag.String("compare", "", "comma-separated GOOS/GOARCH[+TAG...] configurations to also load, warning about types whose shape differs")
+
+// buildSettings selects the source files that are loaded for each package,
+// as with the corresponding options to "go build". Empty fields take their
+// defaults from go/build.
+type buildSettings struct {
+	GOOS   string   `json:"goos,omitempty"`
+	GOARCH string   `json:"goarch,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Cgo    *bool    `json:"cgo,omitempty"`
+}
+
+// flagBuildSettings returns the build settings chosen by command line flags.
+func flagBuildSettings() (buildSettings, error) {
+	s := buildSettings{
+		GOOS:   *buildGOOS,
+		GOARCH: *buildGOARCH,
+		Tags:   splitTags(*buildTags),
+	}
+	switch *buildCgo {
+	case "":
+	case "true", "1":
+		s.Cgo = new(bool)
+		*s.Cgo = true
+	case "false", "0":
+		s.Cgo = new(bool)
+	default:
+		return s, fmt.Errorf("invalid value %q for --cgo: must be true or false", *buildCgo)
+	}
+	return s, nil
+}
+
+// Context returns a build context for the receiving settings.
+//
+// As with the go command, cgo is disabled by default when selecting files
+// for a platform other than the default one.
+func (s buildSettings) Context() *build.Context {
+	ctxt := build.Default
+	if s.GOOS != "" {
+		ctxt.GOOS = s.GOOS
+	}
+	if s.GOARCH != "" {
+		ctxt.GOARCH = s.GOARCH
+	}
+	ctxt.BuildTags = s.Tags
+	switch {
+	case s.Cgo != nil:
+		ctxt.CgoEnabled = *s.Cgo
+	case ctxt.GOOS != build.Default.GOOS || ctxt.GOARCH != build.Default.GOARCH:
+		ctxt.CgoEnabled = false
+	}
+	return &ctxt
+}
+
+// String returns a short description of the settings in the same syntax
+// accepted by the --compare option.
+func (s buildSettings) String() string {
+	ctxt := s.Context()
+	ret := ctxt.GOOS + "/" + ctxt.GOARCH
+	for _, tag := range s.Tags {
+		ret += "+" + tag
+	}
+	return ret
+}
+
+// Key returns a string that is equal for any two settings that would
+// produce equivalent build contexts.
+func (s buildSettings) Key() string {
+	return fmt.Sprintf("%s cgo=%t", s, s.Context().CgoEnabled)
+}
+
+// compareBuildSettings returns the build settings given in the --compare
+// option, each of which extends the given base settings.
+func compareBuildSettings(base buildSettings) ([]buildSettings, error) {
+	if *compare == "" {
+		return nil, nil
+	}
+
+	var ret []buildSettings
+	for _, item := range strings.Split(*compare, ",") {
+		parts := strings.Split(item, "+")
+		platform := strings.Split(parts[0], "/")
+		if len(platform) != 2 || platform[0] == "" || platform[1] == "" {
+			return nil, fmt.Errorf("invalid --compare configuration %q: must be GOOS/GOARCH, optionally followed by +TAG", item)
+		}
+		s := buildSettings{
+			GOOS:   platform[0],
+			GOARCH: platform[1],
+			Tags:   append(append([]string(nil), base.Tags...), parts[1:]...),
+		}
+		ret = append(ret, s)
+	}
+	return ret, nil
+}
+
+// warnShapeDifferences loads the given roots under each of the --compare
+// configurations and prints a warning for each copied type whose shape
+// differs from its shape in the given program.
+func warnShapeDifferences(roots []pilfer.Root, prog *pilfer.Program, base buildSettings, opts pilfer.Options) error {
+	others, err := compareBuildSettings(base)
+	if err != nil || len(others) == 0 {
+		return err
+	}
+
+	settings := append([]buildSettings{base}, others...)
+	progs := []*pilfer.Program{prog}
+	for _, s := range others {
+		other, err := pilfer.Load(s.Context(), pilfer.RootPackages(roots)...)
+		if err != nil {
+			return fmt.Errorf("failed to load sources for %s: %s", s, err)
+		}
+		progs = append(progs, other)
+	}
+
+	diffs, err := pilfer.CompareShapes(roots, opts, progs...)
+	if err != nil {
+		return err
+	}
+	for _, diff := range diffs {
+		fmt.Fprintf(os.Stderr, "warning: %s has a different shape in different build configurations\n", diff.Type)
+		for i, shape := range diff.Shapes {
+			if shape == "" {
+				shape = "(not copied)"
+			}
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", settings[i], shape)
+		}
+	}
+	return nil
+}
+
+func splitTags(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
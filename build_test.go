@@ -0,0 +1,62 @@
+package main
+
+import (
+	"go/build"
+	"reflect"
+	"testing"
+)
+
+func TestBuildSettingsContext(t *testing.T) {
+	other := "windows"
+	if build.Default.GOOS == other {
+		other = "linux"
+	}
+
+	ctxt := buildSettings{GOOS: other, Tags: []string{"debug"}}.Context()
+	if ctxt.GOOS != other || ctxt.GOARCH != build.Default.GOARCH {
+		t.Errorf("wrong platform %s/%s", ctxt.GOOS, ctxt.GOARCH)
+	}
+	if !reflect.DeepEqual(ctxt.BuildTags, []string{"debug"}) {
+		t.Errorf("wrong build tags %q", ctxt.BuildTags)
+	}
+	// As with the go command, cgo is off when cross-compiling unless it
+	// is asked for.
+	if ctxt.CgoEnabled {
+		t.Errorf("cgo enabled for another platform")
+	}
+	cgo := true
+	if ctxt := (buildSettings{GOOS: other, Cgo: &cgo}).Context(); !ctxt.CgoEnabled {
+		t.Errorf("cgo disabled despite being requested")
+	}
+
+	if got := (buildSettings{}).Context(); got.GOOS != build.Default.GOOS || got.CgoEnabled != build.Default.CgoEnabled {
+		t.Errorf("default settings don't match build.Default")
+	}
+}
+
+func TestCompareBuildSettings(t *testing.T) {
+	defer func(old string) { *compare = old }(*compare)
+
+	*compare = "linux/amd64,windows/386+debug+trace"
+	got, err := compareBuildSettings(buildSettings{Tags: []string{"base"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []buildSettings{
+		{GOOS: "linux", GOARCH: "amd64", Tags: []string{"base"}},
+		{GOOS: "windows", GOARCH: "386", Tags: []string{"base", "debug", "trace"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong settings\ngot:  %#v\nwant: %#v", got, want)
+	}
+	if got, want := want[1].String(), "windows/386+base+debug+trace"; got != want {
+		t.Errorf("wrong description %q; want %q", got, want)
+	}
+
+	for _, invalid := range []string{"linux", "linux/", "/amd64+debug"} {
+		*compare = invalid
+		if _, err := compareBuildSettings(buildSettings{}); err == nil {
+			t.Errorf("no error for %q", invalid)
+		}
+	}
+}
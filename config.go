@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apparentlymart/go-pilfer/pilfer"
+)
+
+// config is the content of a batch configuration file, which describes a
+// number of extraction jobs for the "run" subcommand.
+//
+// Configuration files are JSON only. HCL is not supported, since nothing
+// in them needs more than JSON can express and it would be another
+// dependency to vendor.
+type config struct {
+	// Profiles maps names to sets of job properties that jobs can share by
+	// naming them in their own "profile" property.
+	Profiles map[string]json.RawMessage `json:"profiles,omitempty"`
+
+	Jobs []json.RawMessage `json:"jobs"`
+
+	jobs []*job
+}
+
+// job is a single extraction described in a batch configuration file. Each
+// job produces one output file, or a directory of them for layouts other
+// than "single".
+type job struct {
+	// Roots are SOURCE arguments as would be given on the command line,
+	// like "example.com/foo:Config". Directories and files are relative
+	// to the directory containing the configuration file.
+	Roots []string `json:"roots"`
+
+	// Output is the path of the file to generate, relative to the directory
+	// containing the configuration file. For layouts that generate more
+	// than one file it is the directory to generate them in.
+	Output string `json:"output"`
+
+	// Lock is the path of a name lock file to read and update, as for the
+	// --lock option, relative to the directory containing the
+	// configuration file.
+	Lock string `json:"lock,omitempty"`
+
+	// UpdateConsumers lists package patterns whose uses of renamed copies
+	// are updated, as for the --update-consumers option. Patterns that are
+	// directories are relative to the directory containing the
+	// configuration file. It requires Lock.
+	UpdateConsumers []string `json:"updateConsumers,omitempty"`
+
+	// Format is "go", "jsonschema", "proto" or "typescript", as for the
+	// --format option.
+	Format string `json:"format,omitempty"`
+
+	// Doc is "markdown" or "html" to generate reference documentation, as
+	// for the --doc option.
+	Doc string `json:"doc,omitempty"`
+
+	// NumberLock is the path of a field number lock file to read and
+	// update, as for the --number-lock option, relative to the directory
+	// containing the configuration file.
+	NumberLock string `json:"numberLock,omitempty"`
+
+	// Layout is "single", "files" or "packages", as for the --layout
+	// option.
+	Layout string `json:"layout,omitempty"`
+
+	// Package is the package name for the generated file. If it is not set
+	// then it is inferred from the output directory as usual.
+	Package string `json:"package,omitempty"`
+
+	// Merge preserves hand-written code in the output file, as for the
+	// --merge option.
+	Merge bool `json:"merge,omitempty"`
+
+	// The build settings select which source files are loaded, and can
+	// be set with the "goos", "goarch", "tags" and "cgo" properties.
+	buildSettings
+
+	// Aliases is "follow" or "keep", as for the --aliases option.
+	Aliases string `json:"aliases,omitempty"`
+
+	// Monomorphize lists generic types or instantiations to copy as
+	// non-generic types, as for the --monomorphize option.
+	Monomorphize []string `json:"monomorphize,omitempty"`
+
+	// Flatten lists struct types to flatten into structs that embed them,
+	// as for the --flatten option.
+	Flatten []string `json:"flatten,omitempty"`
+
+	// Reuse maps source types to existing types in the destination
+	// package, as for the --reuse option. An empty name means the
+	// existing type has the same name as the source type.
+	Reuse map[string]string `json:"reuse,omitempty"`
+
+	// Methods lists the methods to generate for the copied types, as for
+	// the --methods option.
+	Methods []string `json:"methods,omitempty"`
+
+	// Keep lists source types to refer to in their original packages
+	// rather than copying, as for the --keep option.
+	Keep []string `json:"keep,omitempty"`
+
+	// Rename maps source types and constants to the names to give their
+	// copies, as for the --rename option.
+	Rename map[string]string `json:"rename,omitempty"`
+
+	// StructTags lists the struct tag keys to keep on the fields of the
+	// copied structs, as for the --struct-tags option. An empty list
+	// removes every tag.
+	StructTags []string `json:"structTags,omitempty"`
+
+	// Profile names an entry in the configuration's profiles whose
+	// properties the job starts from. Properties set by the job itself
+	// replace those of the profile, except that maps are merged.
+	Profile string `json:"profile,omitempty"`
+
+	roots   []pilfer.Root
+	format  *outputFormat
+	options pilfer.Options
+}
+
+// loadConfig reads and validates the batch configuration file at the given
+// path, resolving output paths relative to the file's directory.
+func loadConfig(path string) (*config, error) {
+	if filepath.Ext(path) == ".hcl" {
+		return nil, fmt.Errorf("configuration file %s is HCL, which is not supported; use JSON instead", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cfg config
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %s", path, err)
+	}
+	if len(cfg.Jobs) == 0 {
+		return nil, fmt.Errorf("configuration file %s does not declare any jobs", path)
+	}
+	for i, raw := range cfg.Jobs {
+		j, err := decodeJob(raw, cfg.Profiles)
+		if err != nil {
+			return nil, fmt.Errorf("invalid job %d in %s: %s", i+1, path, err)
+		}
+		cfg.jobs = append(cfg.jobs, j)
+	}
+
+	baseDir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := range cfg.jobs {
+		if j.Output == "" {
+			return nil, fmt.Errorf("job %d in %s has no output path", i+1, path)
+		}
+		if len(j.Roots) == 0 {
+			return nil, fmt.Errorf("job %d in %s has no roots", i+1, path)
+		}
+		for _, arg := range j.Roots {
+			srcPkg, wantType := parseSourceArg(arg)
+			if srcPkg == "" || wantType == "" {
+				return nil, fmt.Errorf("job %d in %s has invalid root %q: must be package path and type name separated by colon", i+1, path, arg)
+			}
+			if pilfer.IsLocalSource(srcPkg) {
+				srcPkg = resolveLocalSource(baseDir, srcPkg)
+			}
+			j.roots = append(j.roots, pilfer.Root{
+				Package: srcPkg,
+				Type:    wantType,
+			})
+		}
+		j.options.Aliases, err = parseAliasMode(j.Aliases)
+		if err != nil {
+			return nil, fmt.Errorf("job %d in %s: %s", i+1, path, err)
+		}
+		j.options.Layout, err = parseLayout(j.Layout)
+		if err != nil {
+			return nil, fmt.Errorf("job %d in %s: %s", i+1, path, err)
+		}
+		j.options.Monomorphize = j.Monomorphize
+		j.options.Flatten = j.Flatten
+		for src := range j.Reuse {
+			if _, err := parseReuse([]string{src}); err != nil {
+				return nil, fmt.Errorf("job %d in %s: %s", i+1, path, err)
+			}
+		}
+		j.options.Reuse = j.Reuse
+		if err := parseMethods(j.Methods, &j.options); err != nil {
+			return nil, fmt.Errorf("job %d in %s: %s", i+1, path, err)
+		}
+		j.options.Keep, err = parseKeep(j.Keep)
+		if err != nil {
+			return nil, fmt.Errorf("job %d in %s: %s", i+1, path, err)
+		}
+		for src := range j.Rename {
+			if _, err := parseRename([]string{src + "="}); err != nil {
+				return nil, fmt.Errorf("job %d in %s: %s", i+1, path, err)
+			}
+		}
+		j.options.Rename = j.Rename
+		j.options.StructTags = j.StructTags
+		if !filepath.IsAbs(j.Output) {
+			j.Output = filepath.Join(baseDir, j.Output)
+		}
+		if j.Lock != "" && !filepath.IsAbs(j.Lock) {
+			j.Lock = filepath.Join(baseDir, j.Lock)
+		}
+		if j.NumberLock != "" && !filepath.IsAbs(j.NumberLock) {
+			j.NumberLock = filepath.Join(baseDir, j.NumberLock)
+		}
+		if len(j.UpdateConsumers) > 0 && j.Lock == "" {
+			return nil, fmt.Errorf("job %d in %s: updateConsumers requires lock, to find the names that have changed", i+1, path)
+		}
+		for k, pattern := range j.UpdateConsumers {
+			if dir := strings.TrimSuffix(pattern, "/..."); build.IsLocalImport(dir) {
+				j.UpdateConsumers[k] = filepath.Join(baseDir, dir) + pattern[len(dir):]
+			}
+		}
+		j.format, err = parseFormat(j.Format, j.Doc)
+		if err == nil {
+			err = checkFormatOptions(j.format, j.options, j.Merge, j.UpdateConsumers, j.NumberLock)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("job %d in %s: %s", i+1, path, err)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// decodeJob decodes a job from a configuration file, starting from the
+// properties of the profile it names, if any.
+func decodeJob(raw json.RawMessage, profiles map[string]json.RawMessage) (*job, error) {
+	var named struct {
+		Profile string `json:"profile"`
+	}
+	if err := json.Unmarshal(raw, &named); err != nil {
+		return nil, err
+	}
+
+	j := &job{}
+	if named.Profile != "" {
+		profile, ok := profiles[named.Profile]
+		if !ok {
+			return nil, fmt.Errorf("there is no profile named %q", named.Profile)
+		}
+		if err := decodeStrict(profile, j); err != nil {
+			return nil, fmt.Errorf("invalid profile %q: %s", named.Profile, err)
+		}
+		if j.Profile != "" {
+			return nil, fmt.Errorf("invalid profile %q: profiles can't use other profiles", named.Profile)
+		}
+	}
+	if err := decodeStrict(raw, j); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// decodeStrict decodes the given JSON into the given value, rejecting any
+// properties that the value has no field for.
+func decodeStrict(raw json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// resolveLocalSource makes the given directory or list of files, as
+// accepted by pilfer.Load, relative to the given base directory.
+func resolveLocalSource(baseDir, src string) string {
+	parts := strings.Split(src, ",")
+	for i, part := range parts {
+		if !filepath.IsAbs(part) {
+			parts[i] = filepath.Join(baseDir, part)
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"strings"
+
+	"github.com/apparentlymart/go-pilfer/pilfer"
+)
+
+// updateConsumers renames the uses of previously generated declarations in
+// the packages matching the given patterns to match the names assigned for
+// the given roots, before the output in the given directory is replaced.
+//
+// The previous names come from the name lock in the given options, so if
+// there is none yet then there is nothing to do. The result describes any
+// problems that must be fixed by hand.
+func updateConsumers(prog *pilfer.Program, roots []pilfer.Root, ctxt *build.Context, outDir string, patterns []string, opts pilfer.Options) ([]string, error) {
+	if len(patterns) == 0 || opts.Lock == nil {
+		return nil, nil
+	}
+	if opts.Layout == pilfer.PackagePerPackage {
+		return nil, fmt.Errorf("consumers cannot be updated with the packages layout")
+	}
+
+	lock, err := prog.NameLock(roots, opts)
+	if err != nil {
+		return nil, err
+	}
+	renames, removed := pilfer.NameChanges(opts.Lock, lock)
+	pkgPath, err := pilfer.DirImportPath(ctxt, outDir)
+	if err != nil {
+		return nil, err
+	}
+	return pilfer.UpdateConsumers(ctxt, pkgPath, patterns, renames, removed)
+}
+
+// splitPatterns splits a comma- or space-separated list of package
+// patterns.
+func splitPatterns(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/apparentlymart/go-pilfer/pilfer"
+	flag "github.com/ogier/pflag"
+)
+
+// discover implements the "discover" subcommand, which finds the types of
+// the values that the given main packages encode, reports the calls that
+// encode them, and then copies them as if they had been given as roots.
+func discover(args []string) {
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	settings, err := flagBuildSettings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	prog, err := pilfer.Load(settings.Context(), args...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	discoveries, err := prog.Discover(args...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	// The report goes to stderr, leaving stdout for the output when it is
+	// written there.
+	var roots []pilfer.Root
+	seen := map[pilfer.Root]bool{}
+	for _, d := range discoveries {
+		pos := d.Position
+		pos.Filename = relPath(pos.Filename)
+		fmt.Fprintf(os.Stderr, "%s: %s\n", pos, d.Callee)
+		for _, root := range d.Roots {
+			fmt.Fprintf(os.Stderr, "  %s\n", root)
+			if !seen[root] {
+				roots = append(roots, root)
+				seen[root] = true
+			}
+		}
+		for _, desc := range d.Unnamed {
+			fmt.Fprintf(os.Stderr, "  %s (not a named type)\n", desc)
+		}
+		if len(d.Roots) == 0 && len(d.Unnamed) == 0 {
+			fmt.Fprintf(os.Stderr, "  (no values found)\n")
+		}
+	}
+	if len(roots) == 0 {
+		fmt.Fprintln(os.Stderr, "found no values of named types being encoded")
+		os.Exit(1)
+	}
+
+	generate(roots, prog)
+}
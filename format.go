@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/apparentlymart/go-pilfer/pilfer"
+	flag "github.com/ogier/pflag"
+)
+
+var format = flag.String("format", "go", "output format: go for Go source code, jsonschema for a JSON Schema describing the JSON encoding of the types, proto for a protobuf schema, or typescript for TypeScript declarations")
+var doc = flag.String("doc", "", "write reference documentation for the types, in markdown or html, instead of code")
+var numberLockPath = flag.String("number-lock", "", "with --format=proto, field number lock file to read and update, keeping field numbers stable across regenerations")
+
+// outputFormat is a format other than Go source code that pilfer can
+// generate from the types it would copy.
+type outputFormat struct {
+	// ext is the suffix of the default output filename.
+	ext string
+
+	// generate writes the output, returning warnings about anything it
+	// couldn't represent.
+	generate func(prog *pilfer.Program, roots []pilfer.Root, w io.Writer, opts pilfer.Options) ([]string, error)
+
+	// numbered is set for formats that assign numbers to fields, which
+	// can be kept stable with a field number lock.
+	numbered bool
+}
+
+var outputFormats = map[string]*outputFormat{
+	"jsonschema": {
+		ext: ".schema.json",
+		generate: func(prog *pilfer.Program, roots []pilfer.Root, w io.Writer, opts pilfer.Options) ([]string, error) {
+			return nil, prog.JSONSchema(roots, w, opts)
+		},
+	},
+	"proto": {
+		ext:      ".proto",
+		generate: (*pilfer.Program).Proto,
+		numbered: true,
+	},
+	"typescript": {
+		ext: ".d.ts",
+		generate: func(prog *pilfer.Program, roots []pilfer.Root, w io.Writer, opts pilfer.Options) ([]string, error) {
+			return nil, prog.TypeScript(roots, w, opts)
+		},
+	},
+}
+
+// docFormats are the formats of reference documentation, which are
+// selected separately from the other output formats.
+var docFormats = map[string]*outputFormat{
+	"markdown": {
+		ext: ".md",
+		generate: func(prog *pilfer.Program, roots []pilfer.Root, w io.Writer, opts pilfer.Options) ([]string, error) {
+			return nil, prog.Doc(roots, pilfer.Markdown, w, opts)
+		},
+	},
+	"html": {
+		ext: ".html",
+		generate: func(prog *pilfer.Program, roots []pilfer.Root, w io.Writer, opts pilfer.Options) ([]string, error) {
+			return nil, prog.Doc(roots, pilfer.HTML, w, opts)
+		},
+	},
+}
+
+// parseFormat returns the output format with the given name, or if a
+// documentation format is given, that instead. The result is nil for Go
+// source code.
+func parseFormat(s, doc string) (*outputFormat, error) {
+	if doc != "" {
+		if s != "" && s != "go" {
+			return nil, fmt.Errorf("documentation can't be generated in the %s format", s)
+		}
+		if f, ok := docFormats[doc]; ok {
+			return f, nil
+		}
+		return nil, fmt.Errorf("invalid documentation format %q: must be markdown or html", doc)
+	}
+	if s == "" || s == "go" {
+		return nil, nil
+	}
+	if f, ok := outputFormats[s]; ok {
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid format %q: must be go, jsonschema, proto or typescript", s)
+}
+
+// checkFormatOptions returns an error if the given options can't be used
+// with the given output format, since the options that concern the
+// destination package apply only to Go source code.
+func checkFormatOptions(f *outputFormat, opts pilfer.Options, merge bool, consumers []string, numberLock string) error {
+	switch {
+	case numberLock != "" && (f == nil || !f.numbered):
+		return fmt.Errorf("a field number lock is available only for the proto format")
+	case f == nil:
+		return nil
+	case opts.Layout != pilfer.SingleFile:
+		return fmt.Errorf("only the single layout is available for formats other than go")
+	case merge:
+		return fmt.Errorf("merging is available only for the go format")
+	case len(consumers) > 0:
+		return fmt.Errorf("updating consumers is available only for the go format")
+	case opts.DeepCopy || opts.Equal || opts.Enums || opts.Validate:
+		return fmt.Errorf("generating methods is available only for the go format")
+	case len(opts.Keep) > 0:
+		return fmt.Errorf("keeping types is available only for the go format")
+	case opts.StructTags != nil:
+		return fmt.Errorf("filtering struct tags is available only for the go format")
+	}
+	return nil
+}
+
+// generateFormat generates the output for the given roots in the given
+// format other than Go source code, along with any warnings about it.
+func generateFormat(f *outputFormat, prog *pilfer.Program, roots []pilfer.Root, opts pilfer.Options) ([]byte, []string, error) {
+	var buf bytes.Buffer
+	warnings, err := f.generate(prog, roots, &buf, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), warnings, nil
+}
+
+// readFieldNumberLock reads the field number lock at the given path,
+// returning nil if the path is empty or there is no file there yet.
+func readFieldNumberLock(path string) (*pilfer.FieldNumberLock, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lock, err := pilfer.ReadFieldNumberLock(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return lock, nil
+}
+
+// fieldNumberLockSource returns the content of an updated field number
+// lock recording the numbers assigned for the given roots and options.
+func fieldNumberLockSource(prog *pilfer.Program, roots []pilfer.Root, opts pilfer.Options) ([]byte, error) {
+	lock, err := prog.FieldNumberLock(roots, opts)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := lock.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pilferFormat generates and writes the output in the given format other
+// than Go source code, to the given file or to stdout if it is empty.
+func pilferFormat(f *outputFormat, prog *pilfer.Program, roots []pilfer.Root, outAbs string, opts pilfer.Options) {
+	// The output doesn't belong to a Go package, so existing declarations
+	// near it don't constrain the names it uses.
+	opts.Destination = nil
+	src, warnings, err := generateFormat(f, prog, roots, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if outAbs == "" {
+		os.Stdout.Write(src)
+	} else {
+		err = writeOutputFile(outAbs, src, *force)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write output file: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	updateNameLock(prog, roots, opts)
+
+	if *numberLockPath != "" {
+		src, err := fieldNumberLockSource(prog, roots, opts)
+		if err == nil {
+			err = writeOutputFile(*numberLockPath, src, true)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write field number lock: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"go/build"
+	"path/filepath"
+
+	"github.com/apparentlymart/go-pilfer/pilfer"
+)
+
+// outputFile is a generated file ready to be written, for layouts that
+// produce more than one.
+type outputFile struct {
+	path      string
+	src       []byte
+	conflicts []string
+}
+
+// generateFiles generates the files for a layout other than SingleFile,
+// to be written under the given output directory.
+//
+// The options are updated with the destination package and import path
+// used to generate the files.
+func generateFiles(prog *pilfer.Program, roots []pilfer.Root, ctxt *build.Context, outDir string, opts *pilfer.Options) ([]outputFile, error) {
+	var err error
+	switch opts.Layout {
+	case pilfer.PackagePerPackage:
+		opts.ImportPath, err = pilfer.DirImportPath(ctxt, outDir)
+		if err != nil {
+			return nil, err
+		}
+	case pilfer.FilePerPackage:
+		// The files being replaced must not count as existing declarations
+		// in the destination package, but we can only know which files
+		// they are by generating them once without it.
+		files, err := prog.PilferFiles(roots, *opts)
+		if err != nil {
+			return nil, err
+		}
+		paths := make([]string, len(files))
+		for i, file := range files {
+			paths[i] = filepath.Join(outDir, filepath.FromSlash(file.Path))
+		}
+		opts.Destination, err = pilfer.LoadDestination(ctxt, outDir, paths...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	files, err := prog.PilferFiles(roots, *opts)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]outputFile, len(files))
+	for i, file := range files {
+		ret[i] = outputFile{
+			path: filepath.Join(outDir, filepath.FromSlash(file.Path)),
+			src:  file.Source,
+		}
+	}
+	return ret, nil
+}
+
+// mergeFiles merges each of the given generated files with the existing
+// content of the file it replaces, as with --merge for a single output
+// file, recording any conflicts.
+func mergeFiles(files []outputFile, ctxt *build.Context, opts pilfer.Options) error {
+	for i, file := range files {
+		dest := opts.Destination
+		if opts.Layout == pilfer.PackagePerPackage {
+			var err error
+			dest, err = pilfer.LoadDestination(ctxt, filepath.Dir(file.path), file.path)
+			if err != nil {
+				return err
+			}
+		}
+		var err error
+		files[i].src, files[i].conflicts, err = mergeOutput(file.path, file.src, dest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,84 +1,539 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"go/parser"
 	"go/token"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"text/tabwriter"
+	"unicode"
 
 	"github.com/apparentlymart/go-pilfer/pilfer"
 	flag "github.com/ogier/pflag"
 )
 
-var outPath = flag.StringP("output", "o", "", "output filename")
+var outPath = flag.StringP("output", "o", "", "output filename, or - for stdout")
+var force = flag.Bool("force", false, "overwrite the output file even if it was not generated by pilfer")
+var merge = flag.Bool("merge", false, "replace only the generated declarations in the output file, preserving hand-written code")
+var lockPath = flag.String("lock", "", "name lock file to read and update, keeping the names assigned to copies stable across regenerations")
+var consumers = flag.String("update-consumers", "", "comma- or space-separated package patterns, like ./..., whose uses of renamed copies to update according to the name lock")
 var outPkg = flag.String("package", "", "package name for generated file")
+var list = flag.Bool("list", false, "list the declarations that would be copied instead of generating code")
+var listFormat = flag.String("list-format", "text", "format for --list output: text or json")
+var graph = flag.Bool("graph", false, "print the type dependency graph in DOT format instead of generating code")
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] SOURCE\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] SOURCE...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s explain SOURCE... PKGPATH.TYPE\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s run [--config=FILE] [--check]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s discover [options] PACKAGE...\n", os.Args[0])
+		os.Stderr.WriteString("\nEach SOURCE is PACKAGE:TYPE, where PACKAGE is an import path, a directory\n")
+		os.Stderr.WriteString("starting with ./, ../ or /, or a comma-separated list of .go files.\n\n")
 		flag.PrintDefaults()
 		os.Stderr.WriteString("\n")
 	}
 	flag.Parse()
 	args := flag.Args()
 
-	if len(args) != 1 {
+	if len(args) > 0 && args[0] == "explain" {
+		explain(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "run" {
+		run(args[1:])
+		return
+	}
+	if len(args) > 0 && args[0] == "discover" {
+		discover(args[1:])
+		return
+	}
+
+	if len(args) == 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	srcPkg, wantType := parseSourceArg(args[0])
-	if srcPkg == "" || wantType == "" {
-		fmt.Fprintln(os.Stderr, "SOURCE argument must be package path and type name separated by colon\n")
+	generate(parseSourceArgs(args), nil)
+}
+
+// generate copies the given roots according to the command line options,
+// using the given program if it isn't nil and otherwise loading the packages
+// of the roots.
+func generate(roots []pilfer.Root, prog *pilfer.Program) {
+	settings, err := flagBuildSettings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	opts, err := flagOptions()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	opts.Lock, err = readNameLock(*lockPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read name lock: %s\n", err)
+		os.Exit(1)
+	}
+	if *consumers != "" && *lockPath == "" {
+		fmt.Fprintln(os.Stderr, "--update-consumers requires --lock, to find the names that have changed")
+		os.Exit(1)
+	}
+	outFormat, err := parseFormat(*format, *doc)
+	if err == nil {
+		err = checkFormatOptions(outFormat, opts, *merge, splitPatterns(*consumers), *numberLockPath)
+	}
+	if err == nil {
+		opts.FieldNumbers, err = readFieldNumberLock(*numberLockPath)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
 
+	// Layouts that produce more than one file write them all under the
+	// output directory, which is the working directory by default.
+	multiFile := opts.Layout != pilfer.SingleFile
 	if *outPath == "" {
-		outV := fmt.Sprintf("%s.go", strings.ToLower(wantType))
+		outV := fmt.Sprintf("%s.go", strings.ToLower(roots[0].Type))
+		if outFormat != nil {
+			outV = strings.ToLower(roots[0].Type) + outFormat.ext
+		}
+		if multiFile {
+			outV = "."
+		}
 		outPath = &outV
 	}
 
-	outAbs, err := filepath.Abs(*outPath)
+	toStdout := *outPath == "-"
+	if toStdout && multiFile {
+		fmt.Fprintf(os.Stderr, "cannot write to stdout with the %s layout\n", *layout)
+		os.Exit(1)
+	}
+	if toStdout && *consumers != "" {
+		fmt.Fprintln(os.Stderr, "cannot update consumers when writing to stdout, since the existing output is not replaced")
+		os.Exit(1)
+	}
+
+	var outAbs string
+	var outDir string
+	if multiFile {
+		var err error
+		outDir, err = filepath.Abs(*outPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error with output directory: %s\n", err)
+			os.Exit(1)
+		}
+	} else if toStdout {
+		wd, err := os.Getwd()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error with working directory: %s\n", err)
+			os.Exit(1)
+		}
+		outDir = wd
+	} else {
+		var err error
+		outAbs, err = filepath.Abs(*outPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error with output file: %s\n", err)
+			os.Exit(1)
+		}
+		outDir = filepath.Dir(outAbs)
+	}
+
+	// Only Go output is declared alongside the destination package, so the
+	// other modes don't need one unless types are to be reused from it.
+	if outFormat == nil && (!*list && !*graph || len(opts.Reuse) > 0) {
+		opts.Destination, err = pilfer.LoadDestination(settings.Context(), outDir, outAbs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
+	if prog == nil {
+		prog = loadProgram(roots, settings)
+	}
+	// Every format's header records how it was generated.
+	opts.Command = commandLine()
+	opts.Directive = goGenerateDirective()
+
+	if *list {
+		printList(prog, roots, opts)
+		return
+	}
+
+	if *graph {
+		err := prog.Graph(roots, os.Stdout, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if outFormat != nil {
+		pilferFormat(outFormat, prog, roots, outAbs, opts)
+		return
+	}
+
+	// If we don't have a user-supplied package name then we'll use the one
+	// "go generate" tells us about, if any, or otherwise try to guess one
+	// based on existing files in the output directory.
+	// The package per package layout takes its package names from the
+	// source packages instead.
+	if *outPkg == "" && opts.Layout != pilfer.PackagePerPackage {
+		name := goGeneratePackage(outDir)
+		if name == "" {
+			var err error
+			name, err = inferPackageName(outDir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error inferring package name: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		outPkg = &name
+	}
+
+	err = warnShapeDifferences(roots, prog, settings, opts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error with output file: %s\n", err)
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	opts.PackageName = *outPkg
+
+	if multiFile {
+		pilferFiles(prog, roots, settings, outDir, opts)
+		return
+	}
+
+	// We generate into memory first so that a failure while loading or
+	// processing the source program leaves any existing output untouched.
+	var buf bytes.Buffer
+	err = prog.Pilfer(roots, &buf, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
 
-	// If we don't have a user-supplied package name then we'll try to guess
-	// one based on existing files in the output directory.
-	if *outPkg == "" {
-		outDir := filepath.Dir(outAbs)
-		fset := token.NewFileSet()
-		pkgs, err := parser.ParseDir(fset, outDir, nil, parser.PackageClauseOnly)
+	// Consumers must be updated while the old declarations still exist,
+	// and before merging so that renames in hand-written code are kept.
+	conflicts, err := updateConsumers(prog, roots, settings.Context(), outDir, splitPatterns(*consumers), opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to update consumers: %s\n", err)
+		os.Exit(1)
+	}
+
+	src := buf.Bytes()
+	var mergeConflicts []string
+	if *merge {
+		src, mergeConflicts, err = mergeOutput(outAbs, src, opts.Destination)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error inferring package name: %s\n", err)
+			fmt.Fprintf(os.Stderr, "%s\n", err)
 			os.Exit(1)
 		}
-		if len(pkgs) != 1 {
-			fmt.Fprintf(os.Stderr, "failed to infer a single package name for output directory %s\n", outDir)
+		for _, conflict := range mergeConflicts {
+			conflicts = append(conflicts, fmt.Sprintf("%s: %s", *outPath, conflict))
+		}
+	}
+
+	if toStdout {
+		os.Stdout.Write(src)
+	} else {
+		// Merging preserves everything that isn't generated, so there's
+		// no need to protect a hand-written file from being overwritten.
+		err = writeOutputFile(outAbs, src, *force || *merge)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write output file: %s\n", err)
 			os.Exit(1)
 		}
-		for _, pkg := range pkgs {
-			name := pkg.Name
-			outPkg = &name
-			break
+	}
+	// The lock only records names that are in the written output, and
+	// the output isn't finished while it has merge conflicts.
+	if len(mergeConflicts) == 0 {
+		updateNameLock(prog, roots, opts)
+	}
+
+	// Conflicts are reported after writing, so that they can be resolved
+	// by editing the merged file and the consumer packages.
+	for _, conflict := range conflicts {
+		fmt.Fprintln(os.Stderr, conflict)
+	}
+	if len(conflicts) > 0 {
+		os.Exit(1)
+	}
+}
+
+// pilferFiles generates and writes the output files for a layout other
+// than SingleFile, under the given output directory.
+func pilferFiles(prog *pilfer.Program, roots []pilfer.Root, settings buildSettings, outDir string, opts pilfer.Options) {
+	ctxt := settings.Context()
+	files, err := generateFiles(prog, roots, ctxt, outDir, &opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	problems, err := updateConsumers(prog, roots, ctxt, outDir, splitPatterns(*consumers), opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to update consumers: %s\n", err)
+		os.Exit(1)
+	}
+	if *merge {
+		err = mergeFiles(files, ctxt, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
 		}
 	}
 
-	outF, err := os.Create(outAbs)
-	defer outF.Close()
+	failed := false
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+		failed = true
+	}
+	finished := true
+	for _, file := range files {
+		err := os.MkdirAll(filepath.Dir(file.path), 0755)
+		if err == nil {
+			err = writeOutputFile(file.path, file.src, *force || *merge)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write output file: %s\n", err)
+			failed = true
+			finished = false
+			continue
+		}
+		for _, conflict := range file.conflicts {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", relPath(file.path), conflict)
+			failed = true
+			finished = false
+		}
+	}
+	// As for a single file, the lock waits until all of the output is
+	// written and free of merge conflicts.
+	if finished {
+		updateNameLock(prog, roots, opts)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// updateNameLock writes the names assigned for the given roots and options
+// to the name lock file, if one was requested.
+func updateNameLock(prog *pilfer.Program, roots []pilfer.Root, opts pilfer.Options) {
+	if *lockPath == "" {
+		return
+	}
+	src, err := nameLockSource(prog, roots, opts)
+	if err == nil {
+		err = writeOutputFile(*lockPath, src, true)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to create output file: %s\n", err)
+		fmt.Fprintf(os.Stderr, "failed to write name lock: %s\n", err)
 		os.Exit(1)
 	}
+}
 
-	err = pilfer.Pilfer(srcPkg, wantType, outF, *outPkg)
+// explain implements the "explain" subcommand, which prints the chain of
+// references that causes a particular type to be copied.
+func explain(args []string) {
+	if len(args) < 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	roots := parseSourceArgs(args[:len(args)-1])
+	settings, err := flagBuildSettings()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
+
+	opts, err := flagOptions()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	err = loadProgram(roots, settings).Explain(roots, args[len(args)-1], os.Stdout, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+// printList implements the --list option, which describes what would be
+// copied without generating or writing anything.
+func printList(prog *pilfer.Program, roots []pilfer.Root, opts pilfer.Options) {
+	if *listFormat != "text" && *listFormat != "json" {
+		fmt.Fprintf(os.Stderr, "unsupported list format %q; must be text or json\n", *listFormat)
+		os.Exit(1)
+	}
+
+	entries, err := prog.List(roots, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	if *listFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entries); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write list: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.Kind, entry.NewName, entry.Original, entry.Position, entry.Resolution())
+	}
+	if err := tw.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write list: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// loadProgram loads the source packages for the given roots using the given
+// build settings, exiting with an error message if that isn't possible.
+func loadProgram(roots []pilfer.Root, settings buildSettings) *pilfer.Program {
+	prog, err := pilfer.Load(settings.Context(), pilfer.RootPackages(roots)...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	return prog
+}
+
+// inferPackageName guesses the package name for a file in the given
+// directory based on the package clauses of the files already there.
+//
+// External test packages are ignored, and if the directory contains no Go
+// files at all then a name is derived from the name of the directory itself.
+func inferPackageName(dir string) (string, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, nil, parser.PackageClauseOnly)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	var names []string
+	for name := range pkgs {
+		if strings.HasSuffix(name, "_test") {
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		// A directory containing only external tests still tells us
+		// what the package under test is called.
+		for name := range pkgs {
+			names = append(names, strings.TrimSuffix(name, "_test"))
+			break
+		}
+	}
+
+	switch len(names) {
+	case 0:
+		return dirPackageName(dir), nil
+	case 1:
+		return names[0], nil
+	default:
+		sort.Strings(names)
+		return "", fmt.Errorf("output directory %s contains multiple packages: %s", dir, strings.Join(names, ", "))
+	}
+}
+
+// dirPackageName derives a valid package name from the name of the given
+// directory, following the usual convention that the two should match.
+func dirPackageName(dir string) string {
+	base := strings.ToLower(filepath.Base(dir))
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, base)
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "pkg" + name
+	}
+	if token.Lookup(name).IsKeyword() {
+		name = name + "_"
+	}
+	return name
+}
+
+// goGeneratePackage returns the name of the package containing the
+// go:generate directive that is running pilfer, if the given output
+// directory is the directory of that package, or otherwise an empty string.
+// A directive in an external test package gives the name of the package
+// under test, since the output isn't a test file.
+func goGeneratePackage(outDir string) string {
+	name := os.Getenv("GOPACKAGE")
+	if name == "" {
+		return ""
+	}
+	// "go generate" runs commands in the directory of the directive.
+	wd, err := os.Getwd()
+	if err != nil || filepath.Clean(wd) != filepath.Clean(outDir) {
+		return ""
+	}
+	return strings.TrimSuffix(name, "_test")
+}
+
+// goGenerateDirective returns the location of the go:generate directive
+// that is running pilfer, or an empty string if it isn't running under
+// "go generate".
+func goGenerateDirective() string {
+	file := os.Getenv("GOFILE")
+	if file == "" {
+		return ""
+	}
+	if line := os.Getenv("GOLINE"); line != "" {
+		return fmt.Sprintf("%s:%s", file, line)
+	}
+	return file
+}
+
+// commandLine returns a shell-like rendering of the command line that
+// pilfer was run with, for recording in the generated file.
+func commandLine() string {
+	parts := make([]string, len(os.Args))
+	parts[0] = filepath.Base(os.Args[0])
+	for i, arg := range os.Args[1:] {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`*?[]{}()<>|&;#~") {
+			arg = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+		parts[i+1] = arg
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseSourceArgs parses each of the given SOURCE arguments, exiting with
+// an error message if any of them are invalid.
+func parseSourceArgs(args []string) []pilfer.Root {
+	roots := make([]pilfer.Root, len(args))
+	for i, arg := range args {
+		srcPkg, wantType := parseSourceArg(arg)
+		if srcPkg == "" || wantType == "" {
+			fmt.Fprintln(os.Stderr, "SOURCE argument must be package path and type name separated by colon")
+			os.Exit(1)
+		}
+		roots[i] = pilfer.Root{
+			Package: srcPkg,
+			Type:    wantType,
+		}
+	}
+	return roots
 }
 
 func parseSourceArg(arg string) (string, string) {
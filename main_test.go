@@ -0,0 +1,155 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInferPackageName(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+		err   bool
+	}{
+		{
+			"empty-dir",
+			nil,
+			"empty_dir",
+			false,
+		},
+		{
+			"one",
+			map[string]string{"a.go": "package widgets\n"},
+			"widgets",
+			false,
+		},
+		{
+			"external-tests",
+			map[string]string{
+				"a.go":      "package widgets\n",
+				"a_test.go": "package widgets_test\n",
+			},
+			"widgets",
+			false,
+		},
+		{
+			"only-external-tests",
+			map[string]string{"a_test.go": "package widgets_test\n"},
+			"widgets",
+			false,
+		},
+		{
+			"several",
+			map[string]string{
+				"a.go": "package widgets\n",
+				"b.go": "package gadgets\n",
+			},
+			"",
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "pilfer")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			dir = filepath.Join(dir, test.name)
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for name, src := range test.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := inferPackageName(dir)
+			if test.err {
+				if err == nil {
+					t.Fatalf("no error; want one (got %q)", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("wrong package name %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDirPackageName(t *testing.T) {
+	tests := map[string]string{
+		"/src/widgets":    "widgets",
+		"/src/My-Widgets": "my_widgets",
+		"/src/2d":         "pkg2d",
+		"/src/type":       "type_",
+	}
+	for dir, want := range tests {
+		if got := dirPackageName(dir); got != want {
+			t.Errorf("dirPackageName(%q) = %q; want %q", dir, got, want)
+		}
+	}
+}
+
+func TestGoGeneratePackage(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setenv(t, "GOPACKAGE", "")()
+
+	if got := goGeneratePackage(wd); got != "" {
+		t.Errorf("got %q without GOPACKAGE; want none", got)
+	}
+
+	os.Setenv("GOPACKAGE", "widgets_test")
+	if got := goGeneratePackage(wd); got != "widgets" {
+		t.Errorf("got %q for the directive's directory; want %q", got, "widgets")
+	}
+	// Output elsewhere isn't in the package that the directive is in.
+	if got := goGeneratePackage(filepath.Join(wd, "other")); got != "" {
+		t.Errorf("got %q for another directory; want none", got)
+	}
+}
+
+func TestGoGenerateDirective(t *testing.T) {
+	defer setenv(t, "GOFILE", "")()
+	defer setenv(t, "GOLINE", "")()
+
+	if got := goGenerateDirective(); got != "" {
+		t.Errorf("got %q without GOFILE; want none", got)
+	}
+	os.Setenv("GOFILE", "config.go")
+	if got, want := goGenerateDirective(), "config.go"; got != want {
+		t.Errorf("got %q without GOLINE; want %q", got, want)
+	}
+	os.Setenv("GOLINE", "12")
+	if got, want := goGenerateDirective(), "config.go:12"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+// setenv sets the given environment variable, returning a function that
+// restores its original value.
+func setenv(t *testing.T, name, value string) func() {
+	old, wasSet := os.LookupEnv(name)
+	if err := os.Setenv(name, value); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		if wasSet {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apparentlymart/go-pilfer/pilfer"
+	flag "github.com/ogier/pflag"
+)
+
+var aliases = flag.String("aliases", "follow", "how to handle type aliases: follow to copy their targets instead, or keep to reproduce them")
+var flatten = flag.String("flatten", "", "comma-separated struct types whose fields to copy into structs that embed them, or \"all\"")
+var reuse = flag.String("reuse", "", "comma-separated source types to resolve to existing types in the destination package, each as PKG.TYPE or PKG.TYPE=EXISTING")
+var layout = flag.String("layout", "single", "how to divide the output: single for one file, files for a file per source package, or packages for a package per source package, in the output directory")
+var monomorphize = flag.String("monomorphize", "", "comma-separated generic types or instantiations to copy as non-generic types, or \"all\"")
+var keep = flag.String("keep", "", "comma-separated source types to refer to in their original packages instead of copying, each as PKG.TYPE")
+var rename = flag.String("rename", "", "comma-separated source types and constants to give new names, each as PKG.NAME=NEWNAME")
+var structTags = flag.String("struct-tags", "", "comma-separated struct tag keys to keep on the fields of copied structs, removing any others")
+var methods = flag.String("methods", "", "comma-separated methods to generate for the copied types: deepcopy for DeepCopy, equal for Equal, enum for String, IsValid, Parse and Values, validate for Validate")
+
+// flagOptions returns the pilfer options chosen by command line flags that
+// affect which declarations are selected and how they are copied.
+func flagOptions() (pilfer.Options, error) {
+	var opts pilfer.Options
+	var err error
+	opts.Aliases, err = parseAliasMode(*aliases)
+	if err != nil {
+		return opts, err
+	}
+	opts.Layout, err = parseLayout(*layout)
+	if err != nil {
+		return opts, err
+	}
+	opts.Monomorphize = splitTypeList(*monomorphize)
+	opts.Flatten = splitTypeList(*flatten)
+	opts.Reuse, err = parseReuse(splitTypeList(*reuse))
+	if err != nil {
+		return opts, err
+	}
+	opts.Keep, err = parseKeep(splitTypeList(*keep))
+	if err != nil {
+		return opts, err
+	}
+	opts.Rename, err = parseRename(splitTypeList(*rename))
+	if err != nil {
+		return opts, err
+	}
+	if names := splitTypeList(*structTags); len(names) > 0 {
+		opts.StructTags = names
+	}
+	err = parseMethods(splitTypeList(*methods), &opts)
+	return opts, err
+}
+
+// parseMethods enables the generation of the given methods in the given
+// options.
+func parseMethods(names []string, opts *pilfer.Options) error {
+	for _, name := range names {
+		switch name {
+		case "deepcopy":
+			opts.DeepCopy = true
+		case "equal":
+			opts.Equal = true
+		case "enum":
+			opts.Enums = true
+		case "validate":
+			opts.Validate = true
+		default:
+			return fmt.Errorf("invalid method %q: must be deepcopy, equal, enum or validate", name)
+		}
+	}
+	return nil
+}
+
+// parseReuse parses a list of source types to reuse, each optionally
+// followed by an equals sign and the name of the existing type to use in
+// its place.
+func parseReuse(items []string) (map[string]string, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	ret := make(map[string]string, len(items))
+	for _, item := range items {
+		src, dst := item, ""
+		if eq := strings.Index(item, "="); eq != -1 {
+			src, dst = strings.TrimSpace(item[:eq]), strings.TrimSpace(item[eq+1:])
+		}
+		if !isQualifiedName(src) {
+			return nil, fmt.Errorf("invalid type to reuse %q: must be package path and type name separated by a period", src)
+		}
+		ret[src] = dst
+	}
+	return ret, nil
+}
+
+// parseKeep checks a list of source types to keep in their original
+// packages.
+func parseKeep(items []string) ([]string, error) {
+	for _, item := range items {
+		if !isQualifiedName(item) {
+			return nil, fmt.Errorf("invalid type to keep %q: must be package path and type name separated by a period", item)
+		}
+	}
+	return items, nil
+}
+
+// parseRename parses a list of source types and constants to rename, each
+// followed by an equals sign and the new name.
+func parseRename(items []string) (map[string]string, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	ret := make(map[string]string, len(items))
+	for _, item := range items {
+		eq := strings.Index(item, "=")
+		if eq == -1 {
+			return nil, fmt.Errorf("invalid rename %q: must be the source name and new name separated by an equals sign", item)
+		}
+		src, dst := strings.TrimSpace(item[:eq]), strings.TrimSpace(item[eq+1:])
+		if !isQualifiedName(src) {
+			return nil, fmt.Errorf("invalid name to rename %q: must be package path and name separated by a period", src)
+		}
+		ret[src] = dst
+	}
+	return ret, nil
+}
+
+// isQualifiedName returns true if the given string has the form of a
+// package path and a name separated by a period.
+func isQualifiedName(s string) bool {
+	dot := strings.LastIndex(s, ".")
+	return dot >= 1 && dot > strings.LastIndex(s, "/")
+}
+
+// splitTypeList splits a comma-separated list of type names, ignoring
+// commas that separate type arguments within brackets.
+func splitTypeList(s string) []string {
+	var ret []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				ret = append(ret, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	ret = append(ret, s[start:])
+
+	names := ret[:0]
+	for _, name := range ret {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func parseAliasMode(s string) (pilfer.AliasMode, error) {
+	switch s {
+	case "", "follow":
+		return pilfer.FollowAliases, nil
+	case "keep":
+		return pilfer.KeepAliases, nil
+	default:
+		return pilfer.FollowAliases, fmt.Errorf("invalid alias mode %q: must be follow or keep", s)
+	}
+}
+
+func parseLayout(s string) (pilfer.Layout, error) {
+	switch s {
+	case "", "single":
+		return pilfer.SingleFile, nil
+	case "files":
+		return pilfer.FilePerPackage, nil
+	case "packages":
+		return pilfer.PackagePerPackage, nil
+	default:
+		return pilfer.SingleFile, fmt.Errorf("invalid layout %q: must be single, files or packages", s)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/apparentlymart/go-pilfer/pilfer"
+)
+
+// writeOutputFile atomically replaces the file at the given path with the
+// given content, by writing to a temporary file in the same directory and
+// then renaming it over the target.
+//
+// If the target already exists and does not appear to have been generated
+// by pilfer then it is left untouched and an error is returned, unless
+// force is set.
+func writeOutputFile(path string, src []byte, force bool) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		if !force {
+			existing, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if !pilfer.IsGenerated(existing) {
+				return fmt.Errorf("%s was not generated by pilfer; use --force to overwrite it", path)
+			}
+		}
+		mode = info.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	dir, base := filepath.Split(path)
+	tmp, err := ioutil.TempFile(dir, "."+base+".")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	_, err = tmp.Write(src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, mode)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+// mergeOutput merges the given generated source with the existing content
+// of the file at the given path, if any, so that hand-written code in that
+// file is preserved. It returns the merged source along with descriptions
+// of any conflicts between the hand-written and generated code.
+func mergeOutput(path string, generated []byte, dest *pilfer.Destination) ([]byte, []string, error) {
+	var existing []byte
+	if path != "" {
+		var err error
+		existing, err = ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+	}
+	return pilfer.Merge(existing, generated, dest)
+}
+
+// readNameLock reads the name lock at the given path, returning nil if the
+// path is empty or there is no file there yet.
+func readNameLock(path string) (*pilfer.NameLock, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lock, err := pilfer.ReadNameLock(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return lock, nil
+}
+
+// nameLockSource returns the content of an updated name lock recording the
+// names assigned for the given roots and options.
+func nameLockSource(prog *pilfer.Program, roots []pilfer.Root, opts pilfer.Options) ([]byte, error) {
+	lock, err := prog.NameLock(roots, opts)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := lock.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
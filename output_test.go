@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apparentlymart/go-pilfer/pilfer"
+)
+
+func TestWriteOutputFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pilfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	generated := []byte(pilfer.GeneratedHeader + "\n\npackage gen\n\ntype A struct{}\n")
+	handWritten := []byte("package gen\n\ntype A struct{ Mine bool }\n")
+	path := filepath.Join(dir, "a.go")
+
+	// A new file is always written.
+	if err := writeOutputFile(path, generated, false); err != nil {
+		t.Fatalf("writing new file: %s", err)
+	}
+	checkFile(t, path, generated)
+
+	// A file that pilfer generated earlier is replaced.
+	regenerated := append(generated, "\ntype B struct{}\n"...)
+	if err := writeOutputFile(path, regenerated, false); err != nil {
+		t.Fatalf("replacing generated file: %s", err)
+	}
+	checkFile(t, path, regenerated)
+
+	// A hand-written file is refused and left untouched.
+	if err := ioutil.WriteFile(path, handWritten, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeOutputFile(path, generated, false); err == nil {
+		t.Errorf("hand-written file was overwritten without --force")
+	}
+	checkFile(t, path, handWritten)
+
+	// --force overwrites it anyway, keeping its permissions.
+	if err := writeOutputFile(path, generated, true); err != nil {
+		t.Fatalf("overwriting with force: %s", err)
+	}
+	checkFile(t, path, generated)
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if got := info.Mode().Perm(); got != 0600 {
+		t.Errorf("overwritten file has mode %v; want %v", got, os.FileMode(0600))
+	}
+
+	// No temporary files are left behind.
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Errorf("directory has %d files; want only a.go", len(infos))
+	}
+}
+
+func checkFile(t *testing.T, path string, want []byte) {
+	t.Helper()
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("wrong content in %s\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
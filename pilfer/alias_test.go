@@ -0,0 +1,31 @@
+package pilfer
+
+import (
+	"testing"
+)
+
+func TestAliases(t *testing.T) {
+	prog := loadTestdata(t, "example.com/alias")
+
+	// The Config root is itself an alias, and is listed first so that the
+	// target of the Mode alias is reached before the alias is.
+	roots := []Root{
+		{Package: "example.com/alias", Type: "Config"},
+		{Package: "example.com/alias", Type: "Options"},
+	}
+
+	// Following replaces each alias with its target, except for Strings,
+	// whose target has no name of its own. Fast is declared with an alias
+	// of Mode and so is copied with Mode's other constants.
+	checkGolden(t, "alias_follow", pilferTestdata(t, prog, roots, Options{}))
+
+	// Keeping reproduces every alias, including the one whose target was
+	// already copied.
+	got := pilferTestdata(t, prog, roots, Options{Aliases: KeepAliases})
+	checkGolden(t, "alias_keep", got)
+	checkContains(t, "alias_keep", got,
+		"type Config = Config_1",
+		"type Mode_1 = Mode",
+		"Mode  Mode_1  `json:\"mode\"`",
+	)
+}
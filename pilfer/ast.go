@@ -2,6 +2,7 @@ package pilfer
 
 import (
 	"go/ast"
+	"reflect"
 )
 
 type astVisitor func(node ast.Node)
@@ -17,3 +18,56 @@ func (v astVisitor) Visit(node ast.Node) ast.Visitor {
 func (v astVisitor) VisitAll(node ast.Node) {
 	ast.Walk(v, node)
 }
+
+// cloneAST returns a deep copy of the given syntax tree, except that
+// identifiers are shared with the original tree.
+//
+// Sharing identifiers means that the copy can still be resolved using the
+// type checker's Defs and Uses maps, while the copy's structure can be
+// rewritten without disturbing the original.
+func cloneAST(node ast.Node) ast.Node {
+	return cloneValue(reflect.ValueOf(node)).Interface().(ast.Node)
+}
+
+var identType = reflect.TypeOf((*ast.Ident)(nil))
+var objectType = reflect.TypeOf((*ast.Object)(nil))
+var scopeType = reflect.TypeOf((*ast.Scope)(nil))
+
+func cloneValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || v.Type() == identType || v.Type() == objectType || v.Type() == scopeType {
+			return v
+		}
+		ret := reflect.New(v.Type().Elem())
+		ret.Elem().Set(cloneValue(v.Elem()))
+		return ret
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		ret := reflect.New(v.Type()).Elem()
+		ret.Set(cloneValue(v.Elem()))
+		return ret
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		ret := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			ret.Index(i).Set(cloneValue(v.Index(i)))
+		}
+		return ret
+	case reflect.Struct:
+		ret := reflect.New(v.Type()).Elem()
+		ret.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if ret.Field(i).CanSet() {
+				ret.Field(i).Set(cloneValue(v.Field(i)))
+			}
+		}
+		return ret
+	default:
+		return v
+	}
+}
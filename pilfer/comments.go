@@ -0,0 +1,113 @@
+package pilfer
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// commentIndex finds the doc comments of declarations in the source
+// packages.
+//
+// The loader discards comments, since parsing them would also carry them
+// into the generated code, so each source file that a comment is wanted
+// from is parsed again, with comments, the first time it is needed.
+type commentIndex struct {
+	fset *token.FileSet
+
+	// files maps each file that has been parsed to the comments of its
+	// declarations and fields, by the offset of the identifier that names
+	// them.
+	files map[string]map[int]string
+}
+
+func newCommentIndex(fset *token.FileSet) *commentIndex {
+	return &commentIndex{
+		fset:  fset,
+		files: make(map[string]map[int]string),
+	}
+}
+
+// Comment returns the text of the doc comment for the declaration, field or
+// constant named by the identifier at the given position, or of its line
+// comment if it has no doc comment. The result is empty if it has neither.
+func (c *commentIndex) Comment(pos token.Pos) string {
+	position := c.fset.Position(pos)
+	if position.Filename == "" {
+		return ""
+	}
+	comments, parsed := c.files[position.Filename]
+	if !parsed {
+		comments = parseComments(position.Filename)
+		c.files[position.Filename] = comments
+	}
+	return comments[position.Offset]
+}
+
+// parseComments parses the given file and returns the comments of its type,
+// constant and variable declarations and struct fields, by the offset of
+// each name. The result is empty if the file can't be parsed.
+func parseComments(filename string) map[int]string {
+	ret := make(map[int]string)
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		return ret
+	}
+
+	record := func(node ast.Node, groups ...*ast.CommentGroup) {
+		for _, group := range groups {
+			if text := strings.TrimSpace(group.Text()); text != "" {
+				ret[fset.Position(node.Pos()).Offset] = text
+				return
+			}
+		}
+	}
+
+	ast.Inspect(file, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.GenDecl:
+			// The comment on a declaration with a single, ungrouped spec
+			// belongs to the spec.
+			var declDoc *ast.CommentGroup
+			if !node.Lparen.IsValid() {
+				declDoc = node.Doc
+			}
+			for _, spec := range node.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					record(spec.Name, spec.Doc, declDoc, spec.Comment)
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						record(name, spec.Doc, declDoc, spec.Comment)
+					}
+				}
+			}
+		case *ast.Field:
+			for _, name := range node.Names {
+				record(name, node.Doc, node.Comment)
+			}
+			if len(node.Names) == 0 {
+				// The object of an embedded field is positioned at the name
+				// of its type, after any package qualifier.
+				typ := node.Type
+				if star, isStar := typ.(*ast.StarExpr); isStar {
+					typ = star.X
+				}
+				if index, isIndex := typ.(*ast.IndexExpr); isIndex {
+					typ = index.X
+				}
+				if index, isIndex := typ.(*ast.IndexListExpr); isIndex {
+					typ = index.X
+				}
+				if sel, isSel := typ.(*ast.SelectorExpr); isSel {
+					typ = sel.Sel
+				}
+				record(typ, node.Doc, node.Comment)
+			}
+		}
+		return true
+	})
+	return ret
+}
@@ -14,6 +14,23 @@ type takeConstant struct {
 	Const   *types.Const
 	Value   constant.Value
 	NewName string // Assigned only when inserted into a constantTable
+
+	// Conflict is the qualified name of the constant or type whose new name
+	// forced this one to be renamed, or empty if the original name was
+	// available.
+	Conflict string
+
+	// Locked is set if this constant was renamed only because the name
+	// lock records a different name for it.
+	Locked bool
+
+	// Renamed is set if this constant was given the name chosen for it by
+	// Options.Rename.
+	Renamed bool
+}
+
+func (cn *takeConstant) QualifiedName() string {
+	return fmt.Sprintf("%s.%s", cn.Const.Pkg().Path(), cn.Const.Name())
 }
 
 type constantTable struct {
@@ -35,21 +52,34 @@ func (t constantTable) Has(cn *takeConstant) bool {
 	return has
 }
 
-func (t constantTable) NewNameTaken(newName string) bool {
-	_, has := t.newNames[newName]
+// NewNameTaken returns true if the new name with the given key, as returned
+// by nameKey, is already in use by a type or constant, or if the name lock
+// reserves it for a declaration other than the one with the given
+// qualified name.
+func (t constantTable) NewNameTaken(key, qualifiedName string) bool {
+	_, has := t.newNames[key]
 	if has {
 		return true
 	}
-	return t.types.NewNameTaken(newName)
+	return t.types.NewNameTaken(key, qualifiedName)
 }
 
 func (t constantTable) Add(cn *takeConstant) {
-	newName := cn.Name.Name
-	if t.NewNameTaken(newName) {
+	pkgPath := cn.Type.OutputPkgPath()
+	qualifiedName := cn.QualifiedName()
+	newName, renamed := t.types.chosenName(qualifiedName, cn.Name.Name)
+	if key := t.types.nameKey(pkgPath, newName); t.NewNameTaken(key, qualifiedName) {
+		if conflict := t.newNames[key]; conflict != nil {
+			cn.Conflict = conflict.QualifiedName()
+		} else if conflict := t.types.TypeByNewName(key); conflict != nil {
+			cn.Conflict = conflict.QualifiedName()
+		} else {
+			cn.Conflict = t.types.conflictName(key, newName)
+		}
 		num := 1
 		for {
 			newName = fmt.Sprintf("%s_%d", cn.Name.Name, num)
-			if t.NewNameTaken(newName) {
+			if !t.NewNameTaken(t.types.nameKey(pkgPath, newName), qualifiedName) {
 				break
 			}
 			num++
@@ -57,16 +87,20 @@ func (t constantTable) Add(cn *takeConstant) {
 	}
 
 	cn.NewName = newName
+	cn.Renamed = renamed && cn.Conflict == ""
+	cn.Locked = !cn.Renamed && cn.Conflict == "" && newName != cn.Name.Name
 	t.consts[cn.Const] = cn
-	t.newNames[newName] = cn
+	t.newNames[t.types.nameKey(pkgPath, newName)] = cn
 }
 
 func (t constantTable) ConstantByObj(obj *types.Const) *takeConstant {
 	return t.consts[obj]
 }
 
-func (t constantTable) ConstantByNewName(newName string) *takeConstant {
-	return t.newNames[newName]
+// ConstantByNewName returns the constant with the given new name key, as
+// returned by nameKey, or nil if there is none.
+func (t constantTable) ConstantByNewName(key string) *takeConstant {
+	return t.newNames[key]
 }
 
 func (t constantTable) NewNames() []string {
@@ -87,9 +121,10 @@ func (t constantTable) NewNamesByTypeName() map[string][]string {
 		return ret
 	}
 
-	for newName, cn := range t.newNames {
+	for key, cn := range t.newNames {
 		ty := cn.Type
-		ret[ty.NewName] = append(ret[ty.NewName], newName)
+		tyKey := t.types.nameKey(ty.OutputPkgPath(), ty.NewName)
+		ret[tyKey] = append(ret[tyKey], key)
 	}
 
 	for n := range ret {
@@ -0,0 +1,249 @@
+package pilfer
+
+import (
+	"fmt"
+	"go/build"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/buildutil"
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/refactor/rename"
+)
+
+// NameChanges compares a name lock from before the output was regenerated
+// with one from after, returning a map from the old names of renamed
+// declarations to their new names, along with the sorted old names of the
+// declarations that are no longer copied at all.
+func NameChanges(old, new *NameLock) (map[string]string, []string) {
+	renames := make(map[string]string)
+	var removed []string
+	if old == nil {
+		return renames, nil
+	}
+	for qualifiedName, oldName := range old.Names {
+		newName, has := new.Name(qualifiedName)
+		switch {
+		case !has:
+			removed = append(removed, oldName)
+		case newName != oldName:
+			renames[oldName] = newName
+		}
+	}
+	sort.Strings(removed)
+	return renames, removed
+}
+
+// UpdateConsumers updates the code that uses the declarations generated
+// previously into the package with the given import path, so that it will
+// still work after they are replaced by declarations whose names have
+// changed as given by NameChanges. It must therefore be called before the
+// previously generated declarations are replaced.
+//
+// Each rename is applied, using the same machinery as the gorename tool, to
+// the generated package itself and to any of the packages matching the
+// given consumer patterns that import it. Patterns are import paths, which
+// may end in "/..." to match all of the packages beneath a directory, or
+// directories starting with ./ or ../. Only GOPATH is searched for consumer
+// packages, and not Go modules.
+//
+// The result describes the uses of removed declarations in the consumer
+// packages, along with any renames that couldn't be applied because they
+// would conflict with other declarations. These must be fixed by hand.
+func UpdateConsumers(ctxt *build.Context, pkgPath string, consumers []string, renames map[string]string, removed []string) ([]string, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	if len(renames) == 0 && len(removed) == 0 {
+		return nil, nil
+	}
+
+	pkgs, err := expandConsumers(ctxt, consumers)
+	if err != nil {
+		return nil, err
+	}
+	delete(pkgs, pkgPath)
+	dirs := []string{}
+	for _, path := range append(sortedPackages(pkgs), pkgPath) {
+		bp, err := ctxt.Import(path, "", build.FindOnly)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find consumer package %s: %s", path, err)
+		}
+		dirs = append(dirs, bp.Dir)
+	}
+	scoped := withOnlyPackages(ctxt, dirs)
+
+	problems, err := removedUses(scoped, pkgPath, pkgs, removed)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, step := range renameOrder(renames) {
+		from := fmt.Sprintf("%s.%s", strconv.Quote(pkgPath), step[0])
+		err := rename.Main(scoped, "", from, step[1])
+		if err == rename.ConflictError {
+			problems = append(problems, fmt.Sprintf("cannot rename %s to %s because of the conflicts reported above", step[0], step[1]))
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to rename %s to %s: %s", step[0], step[1], err)
+		}
+	}
+	return problems, nil
+}
+
+// expandConsumers returns the import paths of the packages matching the
+// given patterns, as accepted by UpdateConsumers.
+func expandConsumers(ctxt *build.Context, patterns []string) (map[string]bool, error) {
+	expanded := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		dir := strings.TrimSuffix(pattern, "/...")
+		if !build.IsLocalImport(dir) && !filepath.IsAbs(dir) {
+			expanded[i] = pattern
+			continue
+		}
+		importPath, err := DirImportPath(ctxt, dir)
+		if err != nil {
+			return nil, err
+		}
+		expanded[i] = importPath + pattern[len(dir):]
+	}
+	return buildutil.ExpandPatterns(ctxt, expanded), nil
+}
+
+func sortedPackages(pkgs map[string]bool) []string {
+	ret := make([]string, 0, len(pkgs))
+	for path := range pkgs {
+		ret = append(ret, path)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// withOnlyPackages returns a copy of the given build context in which the
+// source directories seem to contain only the packages in the given
+// directories, so that a search of the whole workspace for the packages
+// that import some package finds only those.
+//
+// The packages' dependencies can still be imported as usual, since only the
+// subdirectories of other directories are hidden, not their files.
+func withOnlyPackages(ctxt *build.Context, dirs []string) *build.Context {
+	readDir := ctxt.ReadDir
+	if readDir == nil {
+		readDir = ioutil.ReadDir
+	}
+	visible := func(dir string) bool {
+		for _, pkgDir := range dirs {
+			if pkgDir == dir || strings.HasPrefix(pkgDir, dir+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	ret := *ctxt
+	ret.ReadDir = func(dir string) ([]os.FileInfo, error) {
+		infos, err := readDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Clean(dir)
+		filtered := infos[:0]
+		for _, info := range infos {
+			if !info.IsDir() || visible(filepath.Join(dir, info.Name())) {
+				filtered = append(filtered, info)
+			}
+		}
+		return filtered, nil
+	}
+	return &ret
+}
+
+// removedUses describes the uses of the given removed declarations of the
+// package with the given import path within the given consumer packages.
+func removedUses(ctxt *build.Context, pkgPath string, consumers map[string]bool, removed []string) ([]string, error) {
+	if len(removed) == 0 || len(consumers) == 0 {
+		return nil, nil
+	}
+	isRemoved := make(map[string]bool, len(removed))
+	for _, name := range removed {
+		isRemoved[name] = true
+	}
+
+	cfg := loader.Config{
+		Build:       ctxt,
+		AllowErrors: true,
+		TypeChecker: types.Config{
+			Error: func(error) {},
+		},
+	}
+	for _, path := range sortedPackages(consumers) {
+		cfg.ImportWithTests(path)
+	}
+	prog, err := cfg.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load consumer packages: %s", err)
+	}
+
+	var ret []string
+	for _, info := range prog.InitialPackages() {
+		for ident, obj := range info.Uses {
+			if obj.Pkg() == nil || obj.Pkg().Path() != pkgPath || obj.Parent() != obj.Pkg().Scope() || !isRemoved[obj.Name()] {
+				continue
+			}
+			ret = append(ret, fmt.Sprintf("%s: %s is no longer generated", prog.Fset.Position(ident.Pos()), obj.Name()))
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// renameOrder returns the given renames as pairs of old and new names, in
+// an order where no declaration is renamed to a name that another still
+// has. Cycles, such as two declarations swapping names, are broken by
+// first renaming one of them to a temporary name.
+func renameOrder(renames map[string]string) [][2]string {
+	pending := make(map[string]string, len(renames))
+	for from, to := range renames {
+		pending[from] = to
+	}
+
+	var ret [][2]string
+	for len(pending) > 0 {
+		froms := make([]string, 0, len(pending))
+		for from := range pending {
+			froms = append(froms, from)
+		}
+		sort.Strings(froms)
+
+		progress := false
+		for _, from := range froms {
+			to := pending[from]
+			if _, blocked := pending[to]; blocked {
+				continue
+			}
+			ret = append(ret, [2]string{from, to})
+			delete(pending, from)
+			progress = true
+		}
+		if progress {
+			continue
+		}
+
+		// Everything left is part of a cycle.
+		from := froms[0]
+		tmp := from + "_pilfer"
+		for n := 1; pending[tmp] != "" || renames[tmp] != ""; n++ {
+			tmp = fmt.Sprintf("%s_pilfer%d", from, n)
+		}
+		ret = append(ret, [2]string{from, tmp})
+		pending[tmp] = pending[from]
+		delete(pending, from)
+	}
+	return ret
+}
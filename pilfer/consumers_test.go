@@ -0,0 +1,138 @@
+package pilfer
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNameChanges(t *testing.T) {
+	old := &NameLock{
+		Names: map[string]string{
+			"example.com/a.Kind":   "Kind_1",
+			"example.com/a.Status": "Status",
+			"example.com/b.Kind":   "Kind",
+			"example.com/b.Old":    "Old",
+		},
+	}
+	new := &NameLock{
+		Names: map[string]string{
+			"example.com/a.Kind":   "Kind",
+			"example.com/a.Status": "Status",
+			"example.com/a.New":    "New",
+		},
+	}
+
+	renames, removed := NameChanges(old, new)
+	if want := map[string]string{"Kind_1": "Kind"}; !reflect.DeepEqual(renames, want) {
+		t.Errorf("wrong renames %q; want %q", renames, want)
+	}
+	if want := []string{"Kind", "Old"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("wrong removed names %q; want %q", removed, want)
+	}
+
+	// Without an old lock, nothing is known to have changed.
+	if renames, removed := NameChanges(nil, new); len(renames) != 0 || len(removed) != 0 {
+		t.Errorf("changes without an old lock: %q, %q", renames, removed)
+	}
+}
+
+func TestRenameOrder(t *testing.T) {
+	tests := []struct {
+		renames map[string]string
+		want    [][2]string
+	}{
+		{
+			// Kind must be free before Kind_1 can take its name.
+			map[string]string{"Kind": "Kind_2", "Kind_1": "Kind"},
+			[][2]string{{"Kind", "Kind_2"}, {"Kind_1", "Kind"}},
+		},
+		{
+			// A swap goes through a temporary name.
+			map[string]string{"A": "B", "B": "A"},
+			[][2]string{{"A", "A_pilfer"}, {"B", "A"}, {"A_pilfer", "B"}},
+		},
+	}
+	for _, test := range tests {
+		if got := renameOrder(test.renames); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("wrong order for %q\ngot:  %q\nwant: %q", test.renames, got, test.want)
+		}
+	}
+}
+
+func TestUpdateConsumers(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "pilfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+
+	files := map[string]string{
+		// The previously generated package, and a package that uses it.
+		"example.com/out/gen.go": `package out
+
+type Kind_1 int
+
+const KindLocal_1 Kind_1 = 0
+
+type Old struct{}
+`,
+		"example.com/app/app.go": `package app
+
+import "example.com/out"
+
+var Default out.Kind_1 = out.KindLocal_1
+
+var Legacy out.Old
+`,
+		// A package that isn't a consumer is left alone, even though it
+		// uses the generated package.
+		"example.com/other/other.go": `package other
+
+import "example.com/out"
+
+var Default out.Kind_1
+`,
+	}
+	for name, src := range files {
+		path := filepath.Join(gopath, "src", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctxt := build.Default
+	ctxt.GOPATH = gopath
+	renames := map[string]string{"Kind_1": "Kind", "KindLocal_1": "KindLocal"}
+	problems, err := UpdateConsumers(&ctxt, "example.com/out", []string{"example.com/app/..."}, renames, []string{"Old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || !strings.HasSuffix(problems[0], "app.go:7:16: Old is no longer generated") {
+		t.Errorf("wrong problems %q", problems)
+	}
+
+	read := func(name string) string {
+		src, err := ioutil.ReadFile(filepath.Join(gopath, "src", filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(src)
+	}
+	if got := read("example.com/app/app.go"); !strings.Contains(got, "var Default out.Kind = out.KindLocal") {
+		t.Errorf("consumer was not updated:\n%s", got)
+	}
+	if got := read("example.com/out/gen.go"); !strings.Contains(got, "type Kind int") {
+		t.Errorf("generated package was not updated:\n%s", got)
+	}
+	if got := read("example.com/other/other.go"); got != files["example.com/other/other.go"] {
+		t.Errorf("package that isn't a consumer was changed:\n%s", got)
+	}
+}
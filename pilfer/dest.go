@@ -0,0 +1,319 @@
+package pilfer
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/tools/go/loader"
+)
+
+// Destination describes the existing declarations in the package that the
+// generated file will belong to, so that the generated declarations can
+// avoid their names and can refer to them instead of copying source types.
+type Destination struct {
+	pkg   *types.Package
+	decls map[string]types.Object
+}
+
+// LoadDestination parses and type-checks the package in the given
+// directory, to find the declarations that already exist there.
+//
+// The files at outputFiles, which are presumably files generated
+// previously by Pilfer, are ignored because they will be replaced. If one
+// instead contains hand-written code merged with generated declarations, as
+// produced by Merge, then only the generated declarations are ignored.
+// Type errors in the package are ignored, since the other files may depend
+// on the ignored declarations.
+//
+// If the directory doesn't exist or contains no Go files then the result
+// is an empty destination.
+func LoadDestination(ctxt *build.Context, dir string, outputFiles ...string) (*Destination, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	for _, outputFile := range outputFiles {
+		if outputFile == "" {
+			continue
+		}
+		if src, err := ioutil.ReadFile(outputFile); err != nil || IsGenerated(src) {
+			ctxt = withoutFile(ctxt, outputFile)
+		}
+	}
+	dest := &Destination{
+		decls: make(map[string]types.Object),
+	}
+
+	bp, err := ctxt.ImportDir(dir, 0)
+	if err != nil {
+		if _, noGo := err.(*build.NoGoError); noGo || !dirExists(ctxt, dir) {
+			return dest, nil
+		}
+		return nil, fmt.Errorf("failed to load destination package from %s: %s", dir, err)
+	}
+
+	var filenames []string
+	for _, name := range bp.GoFiles {
+		filenames = append(filenames, filepath.Join(dir, name))
+	}
+	for _, name := range bp.CgoFiles {
+		filenames = append(filenames, filepath.Join(dir, name))
+	}
+
+	cfg := loader.Config{
+		Build:       ctxt,
+		ParserMode:  parser.ParseComments,
+		AllowErrors: true,
+		TypeChecker: types.Config{
+			Error: func(error) {},
+		},
+		TypeCheckFuncBodies: func(string) bool {
+			return false
+		},
+	}
+	if inModule(dir) {
+		cfg.FindPackage = newModuleResolver(ctxt).FindPackage
+	}
+	cfg.CreateFromFilenames(localPackagePath(ctxt, dir), filenames...)
+	prog, err := cfg.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load destination package from %s: %s", dir, err)
+	}
+	info := prog.Created[0]
+	dest.pkg = info.Pkg
+
+	for _, file := range info.Files {
+		begin, end, merged := mergeRegionPos(file)
+		for _, decl := range file.Decls {
+			if merged && decl.Pos() > begin && decl.End() < end {
+				continue
+			}
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						dest.add(info, spec.Name)
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							dest.add(info, name)
+						}
+					}
+				}
+			case *ast.FuncDecl:
+				if decl.Recv == nil {
+					dest.add(info, decl.Name)
+				}
+			}
+		}
+	}
+
+	return dest, nil
+}
+
+func (d *Destination) add(info *loader.PackageInfo, ident *ast.Ident) {
+	if ident.Name == "_" || ident.Name == "init" {
+		return
+	}
+	if obj := info.Defs[ident]; obj != nil {
+		d.decls[ident.Name] = obj
+	}
+}
+
+// withoutFile returns a copy of the given build context in which the file
+// at the given path does not appear to exist.
+func withoutFile(ctxt *build.Context, path string) *build.Context {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ctxt
+	}
+	readDir := ctxt.ReadDir
+	if readDir == nil {
+		readDir = ioutil.ReadDir
+	}
+
+	ret := *ctxt
+	ret.ReadDir = func(dir string) ([]os.FileInfo, error) {
+		infos, err := readDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		dirAbs, _ := filepath.Abs(dir)
+		filtered := infos[:0]
+		for _, info := range infos {
+			if filepath.Join(dirAbs, info.Name()) != abs {
+				filtered = append(filtered, info)
+			}
+		}
+		return filtered, nil
+	}
+	return &ret
+}
+
+func dirExists(ctxt *build.Context, dir string) bool {
+	if ctxt.IsDir != nil {
+		return ctxt.IsDir(dir)
+	}
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
+
+// Declared returns the existing declaration with the given name in the
+// destination package, or nil if there is none. It is safe to call on a
+// nil Destination, which has no declarations.
+func (d *Destination) Declared(name string) types.Object {
+	if d == nil {
+		return nil
+	}
+	return d.decls[name]
+}
+
+// qualifiedName returns the package path and name of the given declaration
+// in the destination package, for use in messages.
+func (d *Destination) qualifiedName(name string) string {
+	if d.pkg == nil {
+		return name
+	}
+	return d.pkg.Path() + "." + name
+}
+
+// reuseName returns the name of the destination type that the given source
+// type should resolve to according to the given options, and whether it was
+// selected for reuse at all.
+func reuseName(tn *types.TypeName, opts Options) (string, bool) {
+	if tn.Pkg() == nil {
+		return "", false
+	}
+	destName, reuse := opts.Reuse[tn.Pkg().Path()+"."+tn.Name()]
+	if destName == "" {
+		destName = tn.Name()
+	}
+	return destName, reuse
+}
+
+// checkReusedType returns an error if the given type, which was selected
+// for reuse, can't resolve to the chosen destination type because there is
+// no such type or the two types aren't structurally compatible.
+func checkReusedType(ty *takeType, opts Options) error {
+	existing, isTypeName := opts.Destination.Declared(ty.Reused).(*types.TypeName)
+	if !isTypeName {
+		return fmt.Errorf("cannot reuse %s: the destination package has no type named %s", ty.QualifiedName(), ty.Reused)
+	}
+	if err := compatibleTypes(ty.Type.Underlying(), existing.Type().Underlying(), opts, ""); err != nil {
+		return fmt.Errorf("cannot reuse %s as %s: %s", ty.QualifiedName(), ty.Reused, err)
+	}
+	return nil
+}
+
+// compatibleTypes returns an error if a value of the destination type
+// could not hold the same data as the source type, with the same encoding.
+//
+// Named types are compatible only if they are the same type, or if the
+// source type is itself reused as the destination type. Types in the
+// destination that couldn't be resolved, presumably because they refer to
+// declarations that haven't been generated yet, are assumed compatible.
+func compatibleTypes(src, dst types.Type, opts Options, path string) error {
+	src, dst = types.Unalias(src), types.Unalias(dst)
+	if basic, isBasic := dst.(*types.Basic); isBasic && basic.Kind() == types.Invalid {
+		return nil
+	}
+	where := ""
+	if path != "" {
+		where = " at " + path
+	}
+	mismatch := func() error {
+		return fmt.Errorf("%s is not compatible with %s%s", src, dst, where)
+	}
+
+	switch s := src.(type) {
+	case *types.Named:
+		d, isNamed := dst.(*types.Named)
+		if !isNamed {
+			return mismatch()
+		}
+		if s.Obj().Pkg() == nil || d.Obj().Pkg() == nil {
+			if !types.Identical(s, d) {
+				return mismatch()
+			}
+			return nil
+		}
+		if s.Obj().Pkg().Path() == d.Obj().Pkg().Path() && s.Obj().Name() == d.Obj().Name() {
+			return nil
+		}
+		destName, reuse := reuseName(s.Obj(), opts)
+		if !reuse || d.Obj().Pkg() != opts.Destination.pkg || d.Obj().Name() != destName {
+			return fmt.Errorf("%s%s must also be reused as %s", s, where, d)
+		}
+		return nil
+	case *types.Basic:
+		d, isBasic := dst.(*types.Basic)
+		if !isBasic || s.Kind() != d.Kind() {
+			return mismatch()
+		}
+		return nil
+	case *types.Pointer:
+		d, isPtr := dst.(*types.Pointer)
+		if !isPtr {
+			return mismatch()
+		}
+		return compatibleTypes(s.Elem(), d.Elem(), opts, path)
+	case *types.Slice:
+		d, isSlice := dst.(*types.Slice)
+		if !isSlice {
+			return mismatch()
+		}
+		return compatibleTypes(s.Elem(), d.Elem(), opts, path+"[]")
+	case *types.Array:
+		d, isArray := dst.(*types.Array)
+		if !isArray || s.Len() != d.Len() {
+			return mismatch()
+		}
+		return compatibleTypes(s.Elem(), d.Elem(), opts, path+"[]")
+	case *types.Map:
+		d, isMap := dst.(*types.Map)
+		if !isMap {
+			return mismatch()
+		}
+		if err := compatibleTypes(s.Key(), d.Key(), opts, path+"[key]"); err != nil {
+			return err
+		}
+		return compatibleTypes(s.Elem(), d.Elem(), opts, path+"[value]")
+	case *types.Chan:
+		d, isChan := dst.(*types.Chan)
+		if !isChan || s.Dir() != d.Dir() {
+			return mismatch()
+		}
+		return compatibleTypes(s.Elem(), d.Elem(), opts, path+"<-")
+	case *types.Struct:
+		d, isStruct := dst.(*types.Struct)
+		if !isStruct || s.NumFields() != d.NumFields() {
+			return mismatch()
+		}
+		for i := 0; i < s.NumFields(); i++ {
+			sf, df := s.Field(i), d.Field(i)
+			fieldPath := joinFieldPath(path, sf.Name())
+			if sf.Name() != df.Name() || sf.Embedded() != df.Embedded() {
+				return fmt.Errorf("field %s does not match field %s", fieldPath, joinFieldPath(path, df.Name()))
+			}
+			if s.Tag(i) != d.Tag(i) {
+				return fmt.Errorf("field %s has tag %q rather than %q", fieldPath, d.Tag(i), s.Tag(i))
+			}
+			if err := compatibleTypes(sf.Type(), df.Type(), opts, fieldPath); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		// Interfaces, function types and the like have no encoded data of
+		// their own, so we only require that they are of the same kind.
+		if fmt.Sprintf("%T", src) != fmt.Sprintf("%T", dst) {
+			return mismatch()
+		}
+		return nil
+	}
+}
@@ -0,0 +1,103 @@
+package pilfer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDestination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pilfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A destination that doesn't exist yet, or has no Go files, has no
+	// declarations to avoid.
+	for _, empty := range []string{filepath.Join(dir, "missing"), dir} {
+		dest, err := LoadDestination(nil, empty)
+		if err != nil {
+			t.Fatalf("loading %s: %s", empty, err)
+		}
+		if dest.Declared("Config") != nil {
+			t.Errorf("%s has declarations", empty)
+		}
+	}
+
+	files := map[string]string{
+		"existing.go": `package out
+
+type Kind int
+
+const KindRemote = "remote"
+
+type Remote struct {
+	URL string ` + "`json:\"url\"`" + `
+}
+
+func Status() int { return 0 }
+
+func (r Remote) String() string { return r.URL }
+`,
+		// The output file that was generated before is to be replaced.
+		"config.go": GeneratedHeader + `
+
+package out
+
+type Config struct{}
+
+type Kind_1 int
+`,
+		// Only the generated part of a merged file is to be replaced.
+		"merged.go": `package out
+
+type Handwritten struct{}
+
+` + MergeBeginMarker + `
+
+type Generated struct{}
+
+` + MergeEndMarker + `
+`,
+	}
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dest, err := LoadDestination(nil, dir, filepath.Join(dir, "config.go"), filepath.Join(dir, "merged.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Kind", "KindRemote", "Remote", "Status", "Handwritten"} {
+		if dest.Declared(name) == nil {
+			t.Errorf("%s is not declared", name)
+		}
+	}
+	for _, name := range []string{"Config", "Kind_1", "Generated", "String"} {
+		if dest.Declared(name) != nil {
+			t.Errorf("%s is declared", name)
+		}
+	}
+
+	// The copies avoid every existing name, and b.Config resolves to the
+	// existing Remote type instead of being copied.
+	prog := loadTestdata(t, "example.com/lock/a")
+	roots := []Root{{Package: "example.com/lock/a", Type: "Config"}}
+	opts := Options{
+		PackageName: "out",
+		Destination: dest,
+		Reuse:       map[string]string{"example.com/lock/b.Config": "Remote"},
+	}
+	checkGolden(t, "dest", pilferTestdata(t, prog, roots, opts))
+
+	// A type can only be reused as an existing type with the same shape.
+	opts.Reuse = map[string]string{"example.com/lock/a.Status": "Remote"}
+	err = prog.Pilfer(roots, ioutil.Discard, opts)
+	if err == nil || !strings.Contains(err.Error(), "cannot reuse example.com/lock/a.Status as Remote") {
+		t.Errorf("wrong error for incompatible reuse: %v", err)
+	}
+}
@@ -0,0 +1,390 @@
+package pilfer
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/loader"
+)
+
+// Discovery describes a call in a program that encodes a value, such as a
+// call to json.Marshal, along with the types that the value can have.
+type Discovery struct {
+	// Position is the location of the call.
+	Position token.Position
+
+	// Callee is the encoding function or method that is called, like
+	// "encoding/json.Marshal". An interface method call that can reach
+	// more than one of them lists each, separated by " or ".
+	Callee string
+
+	// Roots are the named types of the values that can be encoded by the
+	// call. The elements of pointers, slices, arrays and maps are used in
+	// place of the unnamed types containing them.
+	Roots []Root
+
+	// Unnamed describes the types of values that can be encoded by the
+	// call but which can't be roots, such as anonymous structs.
+	Unnamed []string
+}
+
+// encoders maps the functions and methods whose calls Discover finds to the
+// index of the argument that is encoded, where the receiver of a method is
+// its first argument.
+var encoders = map[string]int{
+	"encoding/json.Marshal":                        0,
+	"encoding/json.MarshalIndent":                  0,
+	"(*encoding/json.Encoder).Encode":              1,
+	"encoding/xml.Marshal":                         0,
+	"encoding/xml.MarshalIndent":                   0,
+	"(*encoding/xml.Encoder).Encode":               1,
+	"(*encoding/xml.Encoder).EncodeElement":        1,
+	"(*encoding/gob.Encoder).Encode":               1,
+	"gopkg.in/yaml.v2.Marshal":                     0,
+	"gopkg.in/yaml.v3.Marshal":                     0,
+	"(*gopkg.in/yaml.v3.Encoder).Encode":           1,
+	"(*github.com/BurntSushi/toml.Encoder).Encode": 1,
+}
+
+// Discover finds the calls in the given main packages, and the packages
+// they depend on, that encode values with one of the encoding packages, and
+// the types of the values that each call can encode. Each source must have
+// been given to Load.
+//
+// The types are found from the static types of the encoded values. Values
+// of interface and type parameter types are followed back through the
+// parameters of the functions they are passed to, but not through variables
+// or fields, so the types of values stored in them before being encoded
+// aren't found. An interface method call is assumed to reach every encoder
+// in the program that implements the interface. Only the packages outside
+// of the standard library are analyzed, so values that pass through it
+// before being encoded, such as by being stored in a container/list, aren't
+// found either.
+//
+// The discovered roots refer to the package of a source by the source
+// itself, and to other packages by their import paths, which can then be
+// given to the other methods of the program without loading it again.
+func (p *Program) Discover(srcs ...string) ([]*Discovery, error) {
+	for _, src := range srcs {
+		info := p.prog.Package(p.pkgPaths[src])
+		if info == nil {
+			return nil, fmt.Errorf("package %s was not loaded", src)
+		}
+		if info.Pkg.Name() != "main" {
+			return nil, fmt.Errorf("package %s is not a main package", src)
+		}
+	}
+
+	discoveries := p.discoverTypes()
+
+	sort.SliceStable(discoveries, func(i, j int) bool {
+		pi, pj := discoveries[i].Position, discoveries[j].Position
+		if pi.Filename != pj.Filename {
+			return pi.Filename < pj.Filename
+		}
+		return pi.Offset < pj.Offset
+	})
+	return discoveries, nil
+}
+
+// newDiscovery returns the discovery of a call at the given position to the
+// given encoders, which can encode values of the given types.
+func (p *Program) newDiscovery(pos token.Position, callee string, ts []types.Type) *Discovery {
+	d := &Discovery{
+		Position: pos,
+		Callee:   callee,
+	}
+	seen := map[Root]bool{}
+	seenUnnamed := map[string]bool{}
+	for _, t := range sortedTypes(ts) {
+		named := discoveredNamed(t)
+		if named == nil || named.Obj().Pkg() == nil {
+			if desc := t.String(); !seenUnnamed[desc] {
+				d.Unnamed = append(d.Unnamed, desc)
+				seenUnnamed[desc] = true
+			}
+			continue
+		}
+		root := Root{
+			Package: p.rootPackage(named.Obj().Pkg().Path()),
+			Type:    named.Obj().Name(),
+		}
+		if !seen[root] {
+			d.Roots = append(d.Roots, root)
+			seen[root] = true
+		}
+	}
+	return d
+}
+
+// isStandardPackage returns true if the package with the given path was
+// loaded from the standard library.
+func isStandardPackage(prog *loader.Program, pkgPath string) bool {
+	dir := packageDir(prog, pkgPath)
+	return dir != "" && strings.HasPrefix(dir, filepath.Join(build.Default.GOROOT, "src")+string(filepath.Separator))
+}
+
+// discoveredNamed returns the named type whose values are encoded when
+// encoding a value of the given type, looking through unnamed pointer,
+// slice, array and map types to their elements, or nil if there isn't one.
+func discoveredNamed(t types.Type) *types.Named {
+	for {
+		switch tt := types.Unalias(t).(type) {
+		case *types.Named:
+			return tt
+		case *types.Pointer:
+			t = tt.Elem()
+		case *types.Slice:
+			t = tt.Elem()
+		case *types.Array:
+			t = tt.Elem()
+		case *types.Map:
+			t = tt.Elem()
+		default:
+			return nil
+		}
+	}
+}
+
+// rootPackage returns the package of a discovered root in the given
+// package, which is the source that the package was loaded for, if any, or
+// otherwise its import path, which is then recorded as a source itself.
+func (p *Program) rootPackage(pkgPath string) string {
+	if _, ok := p.pkgPaths[pkgPath]; ok {
+		return pkgPath
+	}
+	var srcs []string
+	for src, path := range p.pkgPaths {
+		if path == pkgPath {
+			srcs = append(srcs, src)
+		}
+	}
+	if len(srcs) > 0 {
+		sort.Strings(srcs)
+		return srcs[0]
+	}
+	p.pkgPaths[pkgPath] = pkgPath
+	return pkgPath
+}
+
+// sortedTypes returns the given types sorted by their string forms, so that
+// what's discovered doesn't depend on the order of a map.
+func sortedTypes(ts []types.Type) []types.Type {
+	sort.Slice(ts, func(i, j int) bool {
+		return ts[i].String() < ts[j].String()
+	})
+	return ts
+}
+
+// discoverTypes finds the calls for Discover, and the types of the values
+// that they encode, in every loaded package outside of the standard library.
+func (p *Program) discoverTypes() []*Discovery {
+	a := &typeAnalysis{
+		prog:   p.prog,
+		calls:  map[*types.Func][]typeCall{},
+		params: map[*types.Var]typeParam{},
+	}
+	var encoderCalls []typeCall
+	for _, info := range p.prog.AllPackages {
+		if isStandardPackage(p.prog, info.Pkg.Path()) {
+			continue
+		}
+		for _, file := range info.Files {
+			ast.Inspect(file, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.FuncDecl:
+					if fn, ok := info.Defs[n.Name].(*types.Func); ok {
+						params := fn.Type().(*types.Signature).Params()
+						for i := 0; i < params.Len(); i++ {
+							a.params[params.At(i)] = typeParam{fn, i}
+						}
+					}
+				case *ast.CallExpr:
+					if fn := calledFunc(info, n); fn != nil {
+						call := typeCall{info, n, fn}
+						a.calls[fn] = append(a.calls[fn], call)
+						if _, isEncoder := encoders[fn.FullName()]; isEncoder || isInterfaceMethod(fn) {
+							encoderCalls = append(encoderCalls, call)
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+
+	var discoveries []*Discovery
+	for _, call := range encoderCalls {
+		callee, index := a.callee(call.fn)
+		if callee == "" || index >= len(call.expr.Args) {
+			continue
+		}
+		ts := a.valueTypes(call.info, call.expr.Args[index], map[*types.Var]bool{})
+		discoveries = append(discoveries, p.newDiscovery(p.prog.Fset.Position(call.expr.Lparen), callee, ts))
+	}
+	return discoveries
+}
+
+// typeAnalysis holds what discoverTypes knows about a program.
+type typeAnalysis struct {
+	prog *loader.Program
+
+	// calls are the static calls of each function, along with the dynamic
+	// calls of each interface method.
+	calls map[*types.Func][]typeCall
+
+	// params maps the parameters of the declared functions to the
+	// functions they belong to.
+	params map[*types.Var]typeParam
+}
+
+// typeCall is a call of the given function in the given package.
+type typeCall struct {
+	info *loader.PackageInfo
+	expr *ast.CallExpr
+	fn   *types.Func
+}
+
+// typeParam identifies a parameter of a function by its index.
+type typeParam struct {
+	fn    *types.Func
+	index int
+}
+
+// calledFunc returns the function or method that the given call calls, or
+// the interface method if it calls one, or nil if it isn't known, such as
+// for calls of function values.
+func calledFunc(info *loader.PackageInfo, call *ast.CallExpr) *types.Func {
+	fun := ast.Unparen(call.Fun)
+	switch index := fun.(type) {
+	case *ast.IndexExpr:
+		fun = index.X
+	case *ast.IndexListExpr:
+		fun = index.X
+	}
+
+	var obj types.Object
+	switch fun := fun.(type) {
+	case *ast.Ident:
+		obj = info.Uses[fun]
+	case *ast.SelectorExpr:
+		if sel := info.Selections[fun]; sel != nil {
+			if sel.Kind() != types.MethodVal {
+				return nil
+			}
+			obj = sel.Obj()
+		} else {
+			obj = info.Uses[fun.Sel]
+		}
+	}
+	if fn, ok := obj.(*types.Func); ok {
+		return fn.Origin()
+	}
+	return nil
+}
+
+// isInterfaceMethod returns true if the given function is a method of an
+// interface.
+func isInterfaceMethod(fn *types.Func) bool {
+	recv := fn.Type().(*types.Signature).Recv()
+	return recv != nil && types.IsInterface(recv.Type())
+}
+
+// callee returns the name of the encoder that a call of the given function
+// calls, along with the index of its argument that is encoded.
+// The names of every encoder in the program that implements the interface
+// of an interface method are joined with " or ". It returns an empty
+// string if the call doesn't call an encoder.
+func (a *typeAnalysis) callee(fn *types.Func) (string, int) {
+	if index, ok := encoders[fn.FullName()]; ok {
+		if fn.Type().(*types.Signature).Recv() != nil {
+			index--
+		}
+		return fn.FullName(), index
+	}
+
+	iface, _ := fn.Type().(*types.Signature).Recv().Type().Underlying().(*types.Interface)
+	if iface == nil {
+		return "", 0
+	}
+	var names []string
+	index := 0
+	for name, i := range encoders {
+		if !strings.HasSuffix(name, ")."+fn.Name()) {
+			continue
+		}
+		recv := strings.TrimPrefix(name[:strings.LastIndex(name, ".")], "(")
+		recv = strings.TrimSuffix(recv, ")")
+		ptr := strings.HasPrefix(recv, "*")
+		recv = strings.TrimPrefix(recv, "*")
+		dot := strings.LastIndex(recv, ".")
+		info := a.prog.Package(recv[:dot])
+		if info == nil {
+			// Programs that don't load the encoder can't call it.
+			continue
+		}
+		obj, _ := info.Pkg.Scope().Lookup(recv[dot+1:]).(*types.TypeName)
+		if obj == nil {
+			continue
+		}
+		t := obj.Type()
+		if ptr {
+			t = types.NewPointer(t)
+		}
+		if types.Implements(t, iface) {
+			names = append(names, name)
+			index = i - 1
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, " or "), index
+}
+
+// valueTypes returns the types that the value of the given expression can
+// have. Parameters of interface and type parameter types are followed back
+// to the arguments of the calls of their functions, and other values of
+// those types are left out. The parameters in seen have already been
+// followed.
+func (a *typeAnalysis) valueTypes(info *loader.PackageInfo, expr ast.Expr, seen map[*types.Var]bool) []types.Type {
+	t := info.TypeOf(expr)
+	if t == nil || types.Identical(t, types.Typ[types.UntypedNil]) {
+		return nil
+	}
+	if !types.IsInterface(t) {
+		return []types.Type{t}
+	}
+
+	id, isIdent := ast.Unparen(expr).(*ast.Ident)
+	if !isIdent {
+		return nil
+	}
+	v, _ := info.Uses[id].(*types.Var)
+	param, isParam := a.params[v]
+	if !isParam || seen[v] {
+		return nil
+	}
+	seen[v] = true
+
+	var ts []types.Type
+	for _, call := range a.calls[param.fn] {
+		if call.expr.Ellipsis.IsValid() || param.index >= len(call.expr.Args) {
+			continue
+		}
+		// The parameters of calls of generic functions have the types of
+		// their type arguments.
+		if sig, ok := call.info.TypeOf(call.expr.Fun).(*types.Signature); ok && param.index < sig.Params().Len() {
+			if t := sig.Params().At(param.index).Type(); !types.IsInterface(t) {
+				ts = append(ts, t)
+				continue
+			}
+		}
+		ts = append(ts, a.valueTypes(call.info, call.expr.Args[param.index], seen)...)
+	}
+	return ts
+}
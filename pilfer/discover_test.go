@@ -0,0 +1,32 @@
+package pilfer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiscover(t *testing.T) {
+	prog := loadTestdata(t, "example.com/discover")
+	discoveries, err := prog.Discover("example.com/discover")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type found struct {
+		Callee  string
+		Roots   []Root
+		Unnamed []string
+	}
+	var got []found
+	for _, d := range discoveries {
+		got = append(got, found{d.Callee, d.Roots, d.Unnamed})
+	}
+	want := []found{
+		{"encoding/json.Marshal", []Root{{Package: "example.com/enums", Type: "Config"}}, nil},
+		{"(*encoding/json.Encoder).Encode", []Root{{Package: "example.com/discover", Type: "local"}}, nil},
+		{"encoding/json.Marshal", nil, []string{"struct{A int}"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong discoveries\ngot:  %#v\nwant: %#v", got, want)
+	}
+}
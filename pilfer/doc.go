@@ -29,7 +29,8 @@
 // implement interfaces like json.Marshaler, gob.Decoder, etc will not have
 // these custom behaviors preserved, which will probably cause marshalling or
 // unmarshalling to fail. The user must manually copy or re-implement such
-// methods.
+// methods. To keep them in the same file as the generated declarations, use
+// Merge, which replaces only the declarations that Pilfer generated.
 //
 // This program will import interface types along with all other named types,
 // but note that this may not actually prove useful because any named types
@@ -38,8 +39,72 @@
 // gob encoding and decoding because any stored interface types can never
 // match.
 //
+// Type aliases are, by default, replaced by the types they refer to, so that
+// the alias itself does not appear in the output. Alternatively, aliases can
+// be reproduced as aliases of the copied target types.
+//
+// Generic types are copied along with their type parameters and
+// constraints, and instantiations of them refer to the copies. Selected
+// instantiations can instead be "monomorphized", producing a distinct
+// non-generic type for each set of type arguments, such as PageResource for
+// Page[Resource].
+//
+// Embedded struct types can optionally be flattened, copying their fields
+// directly into the embedding struct instead. Shadowed fields are renamed
+// or tagged as needed so that the result encodes as JSON in the same way as
+// the original.
+//
+// Generated declarations also avoid the names already declared in the
+// destination package, and selected source types can be resolved to
+// existing, structurally compatible types there instead of being copied.
+// Others, such as time.Time, can be kept in their original packages, and
+// copies can be given names of their own or have struct tags removed.
+//
+// Which declaration keeps its original name and which gets a numeric suffix
+// depends on the order in which they are found, which can change along with
+// the source packages. A name lock records the names assigned on one run so
+// that later runs can keep them, allocating new names only for declarations
+// that weren't copied before.
+//
+// When names do change, or declarations are no longer copied, comparing
+// the name lock from before regenerating with the names assigned afterwards
+// gives the renames to apply to the code that uses the generated
+// declarations. UpdateConsumers applies them with the same machinery as the
+// gorename tool, and reports the uses of removed declarations.
+//
+// By default all of the generated declarations go in a single file, so types
+// with the same name in different source packages must be renamed. They can
+// instead be divided into a file per source package, or into a package per
+// source package in a directory structure mirroring the source package
+// paths, where names need only be unique within each package and references
+// between packages become imports. In the latter case, unexported types that
+// are referred to from another package are exported.
+//
 // For each type, any constants of that type defined in the type's own package
 // are also copied, on the assumption that they are serving as enumeration
 // values for the type. Since these constants are of the new type, they are
 // not compatible with the constants of the same name in the source package.
+//
+// Since the copies have none of the methods of the source types, Pilfer can
+// optionally generate DeepCopy and Equal methods for them, which follow
+// pointers, slices and maps into the other copied types and keep track of
+// the pointers they have visited where the types are recursive. Types with
+// constants can also be given String and IsValid methods and Parse and
+// Values functions, like those the stringer tool would generate, which
+// treat the constants as bit flags if their values are single bits. A
+// Validate method can check that every such value within a copied struct,
+// such as one just decoded from JSON, is one of the constants of its type.
+//
+// Instead of Go source code, JSONSchema produces a JSON Schema describing
+// the JSON encoding of the same types, under the same names, with the copied
+// constants as the allowed values of their types. Proto similarly produces
+// a first draft of a protobuf schema, with a field number lock keeping the
+// numbers of fields stable as the source types change, and TypeScript
+// produces declarations of the same types for code that reads their JSON
+// encoding. Doc describes the same types for people instead, as reference
+// documentation in Markdown or HTML.
+//
+// Where it isn't known which types a program encodes, Discover can find them
+// from the static types of the values that reach each call to an encoder
+// like json.Marshal, reporting them so that they can be used as roots.
 package pilfer
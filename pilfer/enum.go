@@ -0,0 +1,304 @@
+package pilfer
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// enumValue is a distinct value of a copied type with constants, along
+// with the constants that have it, in the order they are declared.
+type enumValue struct {
+	consts []*takeConstant
+}
+
+// first returns the constant that names the value, which is the first one
+// declared.
+func (v *enumValue) first() *takeConstant {
+	return v.consts[0]
+}
+
+// enumValues groups the given constants by value, in the order in which
+// the first constant with each value is declared.
+func enumValues(consts []*takeConstant) []*enumValue {
+	sorted := append([]*takeConstant(nil), consts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Const.Pos() < sorted[j].Const.Pos()
+	})
+
+	var ret []*enumValue
+Consts:
+	for _, cn := range sorted {
+		for _, v := range ret {
+			if constant.Compare(v.first().Value, token.EQL, cn.Value) {
+				v.consts = append(v.consts, cn)
+				continue Consts
+			}
+		}
+		ret = append(ret, &enumValue{
+			consts: []*takeConstant{cn},
+		})
+	}
+	return ret
+}
+
+// flagValues returns the values that are single bits if the given values
+// of a type with the given underlying type are bit flags, or nil if they
+// aren't.
+//
+// Values are bit flags if there are at least three distinct single bits,
+// and every other value is either zero or a combination of those bits, as
+// when the constants are declared as 1 << iota, perhaps along with masks
+// combining them. Fewer single bits are indistinguishable from an ordinary
+// enumeration counting from one.
+func flagValues(basic *types.Basic, values []*enumValue) []*enumValue {
+	if basic.Info()&types.IsInteger == 0 {
+		return nil
+	}
+	var flags []*enumValue
+	var union uint64
+	for _, v := range values {
+		bits, ok := constant.Uint64Val(v.first().Value)
+		if !ok {
+			return nil
+		}
+		if bits != 0 && bits&(bits-1) == 0 {
+			flags = append(flags, v)
+			union |= bits
+		}
+	}
+	if len(flags) < 3 {
+		return nil
+	}
+	for _, v := range values {
+		if bits, _ := constant.Uint64Val(v.first().Value); bits&^union != 0 {
+			return nil
+		}
+	}
+	return flags
+}
+
+// writeEnum writes the String and IsValid methods, and the Parse and
+// Values functions, of the given copied type with the given constants.
+// The functions are named like ParseKind and KindValues.
+//
+// String returns the original name of the constant with the value,
+// preferring the first declared if several share it, and the Parse
+// function accepts any of their names. If the values are bit flags, as
+// decided by flagValues, String names each flag that is set instead, and
+// a value is valid if it has only their bits.
+func (w *methodWriter) writeEnum(ty *takeType, consts []*takeConstant) {
+	basic, isBasic := w.table.methodUnderlying(ty).(*types.Basic)
+	if !isBasic || len(consts) == 0 {
+		return
+	}
+	values := enumValues(consts)
+	if flags := flagValues(basic, values); flags != nil {
+		w.writeFlagString(ty, basic, values, flags)
+		w.writeFlagIsValid(ty, flags)
+		w.writeValues(ty, values)
+		w.writeFlagParse(ty, values)
+		return
+	}
+	w.writeEnumString(ty, basic, values)
+	w.writeEnumIsValid(ty, values)
+	w.writeValues(ty, values)
+	w.writeEnumParse(ty, basic, values)
+}
+
+// writeIsValid writes only the IsValid method of the given copied type
+// with the given constants.
+func (w *methodWriter) writeIsValid(ty *takeType, consts []*takeConstant) {
+	basic, isBasic := w.table.methodUnderlying(ty).(*types.Basic)
+	if !isBasic || len(consts) == 0 {
+		return
+	}
+	values := enumValues(consts)
+	if flags := flagValues(basic, values); flags != nil {
+		w.writeFlagIsValid(ty, flags)
+		return
+	}
+	w.writeEnumIsValid(ty, values)
+}
+
+// enumTypes returns the copied types with constants that writeEnum and
+// writeIsValid give IsValid methods.
+func enumTypes(table typeTable, consts constantTable) map[*takeType]bool {
+	ret := map[*takeType]bool{}
+	for _, key := range consts.NewNames() {
+		ty := consts.ConstantByNewName(key).Type
+		if ty.Reused != "" || ty.Instance == nil && ty.IsAlias() {
+			continue
+		}
+		if _, isBasic := table.methodUnderlying(ty).(*types.Basic); isBasic {
+			ret[ty] = true
+		}
+	}
+	return ret
+}
+
+func (w *methodWriter) writeEnumString(ty *takeType, basic *types.Basic, values []*enumValue) {
+	name := ty.NewName
+	fmt.Fprintf(w.buf, "\n// String returns the name of the constant of type %s with the\n", name)
+	fmt.Fprintf(w.buf, "// value of v, which is the first one declared if there are several, or\n// otherwise the value in the form %s(value).\n", name)
+	fmt.Fprintf(w.buf, "func (v %s) String() string {\nswitch v {\n", name)
+	for _, v := range values {
+		fmt.Fprintf(w.buf, "case %s:\nreturn %q\n", v.first().NewName, v.first().Const.Name())
+	}
+	fmt.Fprintf(w.buf, "}\nreturn %q + %s + \")\"\n}\n", name+"(", w.formatValue(basic, "v"))
+}
+
+func (w *methodWriter) writeEnumIsValid(ty *takeType, values []*enumValue) {
+	names := make([]string, len(values))
+	for i, v := range values {
+		names[i] = v.first().NewName
+	}
+	fmt.Fprintf(w.buf, "\n// IsValid reports whether v is the value of one of the constants of type\n// %s.\n", ty.NewName)
+	fmt.Fprintf(w.buf, "func (v %s) IsValid() bool {\nswitch v {\ncase %s:\nreturn true\n}\nreturn false\n}\n", ty.NewName, strings.Join(names, ", "))
+}
+
+func (w *methodWriter) writeEnumParse(ty *takeType, basic *types.Basic, values []*enumValue) {
+	name := ty.NewName
+	funcName := w.funcName(ty, "Parse", "")
+	fmt.Fprintf(w.buf, "\n// %s returns the value of the constant of type %s with\n// the given name, as returned by its String method.\n", funcName, name)
+	fmt.Fprintf(w.buf, "func %s(s string) (%s, error) {\nswitch s {\n", funcName, name)
+	for _, v := range values {
+		fmt.Fprintf(w.buf, "case %s:\nreturn %s, nil\n", constNames(v), v.first().NewName)
+	}
+	fmt.Fprintf(w.buf, "}\nreturn %s, %s.Errorf(\"invalid %s %%q\", s)\n}\n", zeroLiteral(basic), w.table.gen.stdImport("fmt"), name)
+}
+
+func (w *methodWriter) writeFlagString(ty *takeType, basic *types.Basic, values, flags []*enumValue) {
+	name := ty.NewName
+	zero := strconv.Quote("0")
+	for _, v := range values {
+		if constant.Sign(v.first().Value) == 0 {
+			zero = strconv.Quote(v.first().Const.Name())
+		}
+	}
+	mask := make([]string, len(flags))
+	for i, v := range flags {
+		mask[i] = v.first().NewName
+	}
+
+	fmt.Fprintf(w.buf, "\n// String returns the names of the flags of type %s that are set\n", name)
+	fmt.Fprintf(w.buf, "// in v, separated by |, followed by any other bits in the form\n// %s(0xbits). If no bits are set, it returns %s.\n", name, zero)
+	fmt.Fprintf(w.buf, "func (v %s) String() string {\nif v == 0 {\nreturn %s\n}\n", name, zero)
+	fmt.Fprintf(w.buf, "var names []string\n")
+	for _, v := range flags {
+		fmt.Fprintf(w.buf, "if v&%s != 0 {\nnames = append(names, %q)\n}\n", v.first().NewName, v.first().Const.Name())
+	}
+	fmt.Fprintf(w.buf, "if rest := v &^ (%s); rest != 0 {\n", strings.Join(mask, " | "))
+	fmt.Fprintf(w.buf, "names = append(names, %q+%s.FormatUint(uint64(rest), 16)+\")\")\n}\n", name+"(0x", w.table.gen.stdImport("strconv"))
+	fmt.Fprintf(w.buf, "return %s.Join(names, \"|\")\n}\n", w.table.gen.stdImport("strings"))
+}
+
+func (w *methodWriter) writeFlagIsValid(ty *takeType, flags []*enumValue) {
+	mask := make([]string, len(flags))
+	for i, v := range flags {
+		mask[i] = v.first().NewName
+	}
+	fmt.Fprintf(w.buf, "\n// IsValid reports whether v has only the bits of the flags of type\n// %s.\n", ty.NewName)
+	fmt.Fprintf(w.buf, "func (v %s) IsValid() bool {\nreturn v&^(%s) == 0\n}\n", ty.NewName, strings.Join(mask, " | "))
+}
+
+func (w *methodWriter) writeFlagParse(ty *takeType, values []*enumValue) {
+	name := ty.NewName
+	funcName := w.funcName(ty, "Parse", "")
+	hasZero := false
+	for _, v := range values {
+		hasZero = hasZero || constant.Sign(v.first().Value) == 0
+	}
+
+	fmt.Fprintf(w.buf, "\n// %s returns the value of type %s with the flags named\n", funcName, name)
+	fmt.Fprintf(w.buf, "// in s, separated by |, as returned by its String method. Constants\n// that combine several flags may be named too.\n")
+	fmt.Fprintf(w.buf, "func %s(s string) (%s, error) {\nvar v %s\n", funcName, name, name)
+	fmt.Fprintf(w.buf, "for _, name := range %s.Split(s, \"|\") {\nswitch %s.TrimSpace(name) {\n", w.table.gen.stdImport("strings"), w.table.gen.stdImport("strings"))
+	if !hasZero {
+		fmt.Fprintf(w.buf, "case \"0\":\n")
+	}
+	for _, v := range values {
+		fmt.Fprintf(w.buf, "case %s:\nv |= %s\n", constNames(v), v.first().NewName)
+	}
+	fmt.Fprintf(w.buf, "default:\nreturn 0, %s.Errorf(\"invalid %s flag %%q\", name)\n}\n}\nreturn v, nil\n}\n", w.table.gen.stdImport("fmt"), name)
+}
+
+func (w *methodWriter) writeValues(ty *takeType, values []*enumValue) {
+	funcName := w.funcName(ty, "", "Values")
+	names := make([]string, len(values))
+	for i, v := range values {
+		names[i] = v.first().NewName
+	}
+	fmt.Fprintf(w.buf, "\n// %s returns the distinct values of the constants of type\n// %s, in the order they are declared.\n", funcName, ty.NewName)
+	fmt.Fprintf(w.buf, "func %s() []%s {\nreturn []%s{%s}\n}\n", funcName, ty.NewName, ty.NewName, strings.Join(names, ", "))
+}
+
+// funcName returns the name for a function generated for the given type,
+// made of the type's name with the given prefix and suffix, which is
+// unexported if the type is. A numeric suffix is added if the name is
+// already taken.
+func (w *methodWriter) funcName(ty *takeType, prefix, suffix string) string {
+	base := ty.NewName + suffix
+	if prefix != "" {
+		base = prefix + exportedName(base)
+	}
+	if !ast.IsExported(ty.NewName) {
+		r, size := utf8.DecodeRuneInString(base)
+		base = string(unicode.ToLower(r)) + base[size:]
+	}
+
+	pkgPath := ty.OutputPkgPath()
+	name := base
+	for n := 1; w.consts.NewNameTaken(w.table.nameKey(pkgPath, name), "") || w.table.gen.funcs[w.table.nameKey(pkgPath, name)]; n++ {
+		name = fmt.Sprintf("%s_%d", base, n)
+	}
+	w.table.gen.funcs[w.table.nameKey(pkgPath, name)] = true
+	return name
+}
+
+// formatValue returns an expression that formats the value of the given
+// expression, whose type has the given underlying type, without calling
+// its String method.
+func (w *methodWriter) formatValue(basic *types.Basic, expr string) string {
+	info := basic.Info()
+	switch {
+	case info&types.IsUnsigned != 0:
+		return fmt.Sprintf("%s.FormatUint(uint64(%s), 10)", w.table.gen.stdImport("strconv"), expr)
+	case info&types.IsInteger != 0:
+		return fmt.Sprintf("%s.FormatInt(int64(%s), 10)", w.table.gen.stdImport("strconv"), expr)
+	case info&types.IsString != 0:
+		return fmt.Sprintf("%s.Quote(string(%s))", w.table.gen.stdImport("strconv"), expr)
+	default:
+		return fmt.Sprintf("%s.Sprint(%s(%s))", w.table.gen.stdImport("fmt"), basic.Name(), expr)
+	}
+}
+
+// constNames returns the quoted original names of the constants with the
+// given value, separated by commas.
+func constNames(v *enumValue) string {
+	names := make([]string, len(v.consts))
+	for i, cn := range v.consts {
+		names[i] = strconv.Quote(cn.Const.Name())
+	}
+	return strings.Join(names, ", ")
+}
+
+// zeroLiteral returns the literal for the zero value of the given type.
+func zeroLiteral(basic *types.Basic) string {
+	info := basic.Info()
+	switch {
+	case info&types.IsString != 0:
+		return `""`
+	case info&types.IsBoolean != 0:
+		return "false"
+	default:
+		return "0"
+	}
+}
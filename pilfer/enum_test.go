@@ -0,0 +1,31 @@
+package pilfer
+
+import (
+	"testing"
+)
+
+func TestEnums(t *testing.T) {
+	prog := loadTestdata(t, "example.com/enums")
+	roots := []Root{{Package: "example.com/enums", Type: "Config"}}
+
+	got := pilferTestdata(t, prog, roots, Options{Enums: true})
+	checkGolden(t, "enums", got)
+
+	checkContains(t, "enums", got,
+		// Crimson has the same value as Red, so String names Red, which is
+		// declared first, but Parse accepts either.
+		"case Red:\n\t\treturn \"Red\"",
+		"case \"Red\", \"Crimson\":\n\t\treturn Red, nil",
+		"return []Color{Red, Green, Blue}",
+		// Bit flags are named one at a time, and may be combined.
+		"if v&PermWrite != 0 {",
+		"return v&^(PermRead|PermWrite|PermExec) == 0",
+		"case \"PermAll\":\n\t\t\tv |= PermAll",
+		// Two single bits are an ordinary enumeration.
+		"case ModeFast, ModeSafe:\n\t\treturn true",
+	)
+	checkNotContains(t, "enums", got,
+		"case Crimson:",
+		"v&ModeFast != 0",
+	)
+}
@@ -0,0 +1,560 @@
+package pilfer
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/loader"
+)
+
+// flatField is a field of a struct type whose embedded structs have been
+// flattened into it, along with the package the field was declared in.
+type flatField struct {
+	// Field is a copy of the field's declaration with exactly one name, or
+	// none if the field is embedded. Its name and tag may differ from the
+	// original declaration.
+	Field *ast.Field
+	Info  *loader.PackageInfo
+
+	// Var is the field's type checker object, and Index is its index path
+	// within the original struct, as for reflect.Type.FieldByIndex.
+	Var   *types.Var
+	Index []int
+
+	// Prefix is the concatenated names of the embedded fields the field
+	// was promoted through, which is used to rename it if its name is
+	// shadowed.
+	Prefix string
+}
+
+// shouldFlatten returns true if the given options select the given named
+// type to be flattened wherever it is embedded.
+func shouldFlatten(tn *types.TypeName, opts Options) bool {
+	qualifiedName := tn.Pkg().Path() + "." + tn.Name()
+	for _, sel := range opts.Flatten {
+		if sel == "all" || sel == qualifiedName {
+			return true
+		}
+	}
+	return false
+}
+
+// flattenStruct returns the fields of the given type after flattening the
+// embedded structs selected by the given options, or nil if the type isn't
+// a struct type, doesn't embed any selected structs, or can't be flattened
+// without changing how it is encoded as JSON.
+//
+// Fields that are shadowed in Go by a field of the same name at a shallower
+// depth are renamed with the names of the embedded fields they were
+// promoted through, and fields that encoding/json would ignore because of
+// shadowing are tagged to be ignored explicitly, so that the flattened
+// struct encodes the same way as the original.
+func flattenStruct(ty *takeType, prog *loader.Program, opts Options) []*flatField {
+	if len(opts.Flatten) == 0 || ty.Instance != nil {
+		return nil
+	}
+	st, isStruct := ty.Spec.Type.(*ast.StructType)
+	if !isStruct {
+		return nil
+	}
+
+	info := prog.Package(ty.Name.Pkg().Path())
+	visiting := map[*types.TypeName]bool{ty.Name: true}
+	fields, flattened := flattenFields(st, info, prog, opts, nil, "", visiting)
+	if !flattened {
+		return nil
+	}
+
+	renameShadowedFields(fields)
+	if !preserveJSONFields(ty.Type, ty.Name.Pkg(), fields) {
+		return nil
+	}
+	return fields
+}
+
+func flattenFields(st *ast.StructType, info *loader.PackageInfo, prog *loader.Program, opts Options, index []int, prefix string, visiting map[*types.TypeName]bool) ([]*flatField, bool) {
+	var ret []*flatField
+	flattened := false
+	i := 0
+	for _, field := range st.Fields.List {
+		if len(field.Names) != 0 {
+			for _, name := range field.Names {
+				clone := cloneAST(field).(*ast.Field)
+				clone.Names = []*ast.Ident{name}
+				ret = append(ret, &flatField{
+					Field:  clone,
+					Info:   info,
+					Var:    info.Defs[name].(*types.Var),
+					Index:  appendIndex(index, i),
+					Prefix: prefix,
+				})
+				i++
+			}
+			continue
+		}
+
+		v, isVar := info.Defs[typeRefIdent(embeddedFieldType(field.Type))].(*types.Var)
+		if !isVar {
+			i++
+			continue
+		}
+		if target := flattenTarget(field, v, opts); target != nil && !visiting[target] {
+			decl := findTypeName(prog, target)
+			if innerSt, isStruct := decl.Spec.Type.(*ast.StructType); isStruct {
+				visiting[target] = true
+				inner, _ := flattenFields(
+					innerSt, prog.Package(target.Pkg().Path()), prog, opts,
+					appendIndex(index, i), prefix+v.Name(), visiting,
+				)
+				delete(visiting, target)
+				ret = append(ret, inner...)
+				flattened = true
+				i++
+				continue
+			}
+		}
+
+		ret = append(ret, &flatField{
+			Field:  cloneAST(field).(*ast.Field),
+			Info:   info,
+			Var:    v,
+			Index:  appendIndex(index, i),
+			Prefix: prefix,
+		})
+		i++
+	}
+	return ret, flattened
+}
+
+// flattenTarget returns the name of the struct type embedded by the given
+// field if it is selected for flattening, or nil otherwise.
+//
+// An embedded field with a name in its json tag is encoded as a nested
+// object rather than having its fields promoted, so it is never flattened.
+func flattenTarget(field *ast.Field, v *types.Var, opts Options) *types.TypeName {
+	if field.Tag != nil {
+		tag, err := strconv.Unquote(field.Tag.Value)
+		if err != nil {
+			return nil
+		}
+		if name, _ := splitJSONTag(reflect.StructTag(tag).Get("json")); name != "" {
+			return nil
+		}
+	}
+
+	t := types.Unalias(v.Type())
+	if ptr, isPtr := t.(*types.Pointer); isPtr {
+		t = types.Unalias(ptr.Elem())
+	}
+	named, isNamed := t.(*types.Named)
+	if !isNamed || named.TypeArgs().Len() != 0 || named.Obj().Pkg() == nil {
+		return nil
+	}
+	if _, isStruct := named.Underlying().(*types.Struct); !isStruct {
+		return nil
+	}
+	if !shouldFlatten(named.Obj(), opts) {
+		return nil
+	}
+	return named.Obj()
+}
+
+// embeddedFieldType returns the type name expression of an embedded field
+// with the given type expression, without any pointer or type arguments.
+func embeddedFieldType(expr ast.Expr) ast.Expr {
+	switch tn := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedFieldType(tn.X)
+	case *ast.IndexExpr:
+		return embeddedFieldType(tn.X)
+	case *ast.IndexListExpr:
+		return embeddedFieldType(tn.X)
+	case *ast.ParenExpr:
+		return embeddedFieldType(tn.X)
+	default:
+		return expr
+	}
+}
+
+func appendIndex(index []int, i int) []int {
+	ret := make([]int, len(index), len(index)+1)
+	copy(ret, index)
+	return append(ret, i)
+}
+
+// renameShadowedFields renames any field whose name is already used by a
+// field at a shallower depth (or earlier at the same depth) in the given
+// list, since Go wouldn't allow both in the same struct. A renamed field
+// is given an explicit json name so that its encoding is unchanged.
+func renameShadowedFields(fields []*flatField) {
+	order := make([]*flatField, len(fields))
+	copy(order, fields)
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(order[i].Index) < len(order[j].Index)
+	})
+
+	taken := make(map[string]bool)
+	for _, f := range order {
+		name := f.Var.Name()
+		if !taken[name] {
+			taken[name] = true
+			continue
+		}
+
+		newName := exportedName(f.Prefix) + name
+		for n := 1; taken[newName]; n++ {
+			newName = fmt.Sprintf("%s%s_%d", exportedName(f.Prefix), name, n)
+		}
+		taken[newName] = true
+
+		if jsonName, _ := splitJSONTag(fieldTag(f.Field).Get("json")); jsonName == "" {
+			setJSONName(f.Field, name)
+		}
+		// The new name keeps the position of the original so that the
+		// field is formatted in the same place.
+		f.Field.Names = []*ast.Ident{{
+			NamePos: f.Field.Pos(),
+			Name:    newName,
+		}}
+	}
+}
+
+// preserveJSONFields compares the fields that encoding/json would use for
+// the given original struct type with those it would use for the given
+// flattened fields, tagging flattened fields to be ignored where they would
+// otherwise be encoded in place of, or as well as, the original fields.
+//
+// It returns false if the flattened fields can't be made to encode in the
+// same way as the original type.
+func preserveJSONFields(orig types.Type, pkg *types.Package, fields []*flatField) bool {
+	want := make(map[string]string)
+	for _, f := range jsonFields(orig) {
+		want[f.name] = indexKey(f.index)
+	}
+
+	// Each time we hide a field, another field may become visible in its
+	// place, so we must keep checking until nothing changes.
+	for range fields {
+		vars := make([]*types.Var, len(fields))
+		tags := make([]string, len(fields))
+		for i, f := range fields {
+			name := f.Var.Name()
+			if len(f.Field.Names) != 0 {
+				name = f.Field.Names[0].Name
+			}
+			vars[i] = types.NewField(token.NoPos, pkg, name, f.Var.Type(), len(f.Field.Names) == 0)
+			tags[i] = string(fieldTag(f.Field))
+		}
+
+		got := jsonFields(types.NewStruct(vars, tags))
+		changed := false
+		for _, jf := range got {
+			f := fields[jf.index[0]]
+			origIndex := append(append([]int(nil), f.Index...), jf.index[1:]...)
+			if want[jf.name] == indexKey(origIndex) {
+				continue
+			}
+			if len(jf.index) != 1 {
+				// This is a field promoted through an embedded struct
+				// that we didn't flatten, which we can't hide.
+				return false
+			}
+			setJSONName(f.Field, "-")
+			changed = true
+		}
+		if !changed {
+			return len(got) == len(want)
+		}
+	}
+	return false
+}
+
+func indexKey(index []int) string {
+	parts := make([]string, len(index))
+	for i, idx := range index {
+		parts[i] = strconv.Itoa(idx)
+	}
+	return strings.Join(parts, ".")
+}
+
+// jsonField is a field that encoding/json would include when encoding a
+// particular struct type.
+type jsonField struct {
+	name   string
+	tagged bool
+	index  []int
+	typ    types.Type
+}
+
+// jsonFields returns the fields that encoding/json would include when
+// encoding the given struct type, following the same rules for promoting
+// the fields of embedded structs and for choosing between fields with the
+// same name.
+func jsonFields(t types.Type) []jsonField {
+	var current []jsonField
+	next := []jsonField{{typ: t}}
+
+	var count, nextCount map[types.Type]int
+	visited := make(map[types.Type]bool)
+
+	var fields []jsonField
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, make(map[types.Type]int)
+
+		for _, f := range current {
+			if visited[f.typ] {
+				continue
+			}
+			visited[f.typ] = true
+
+			st, isStruct := f.typ.Underlying().(*types.Struct)
+			if !isStruct {
+				continue
+			}
+			for i := 0; i < st.NumFields(); i++ {
+				sf := st.Field(i)
+				ft := types.Unalias(sf.Type())
+				if sf.Embedded() {
+					t := ft
+					if ptr, isPtr := t.(*types.Pointer); isPtr {
+						t = types.Unalias(ptr.Elem())
+					}
+					if _, isStruct := t.Underlying().(*types.Struct); !sf.Exported() && !isStruct {
+						continue
+					}
+				} else if !sf.Exported() {
+					continue
+				}
+
+				tag := reflect.StructTag(st.Tag(i)).Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, _ := splitJSONTag(tag)
+				index := appendIndex(f.index, i)
+
+				if ptr, isPtr := ft.(*types.Pointer); isPtr {
+					ft = types.Unalias(ptr.Elem())
+				}
+				_, isNamed := ft.(*types.Named)
+				_, isStruct := ft.Underlying().(*types.Struct)
+
+				if name != "" || !sf.Embedded() || !isStruct {
+					tagged := name != ""
+					if name == "" {
+						name = sf.Name()
+					}
+					fields = append(fields, jsonField{
+						name:   name,
+						tagged: tagged,
+						index:  index,
+						typ:    ft,
+					})
+					if count[f.typ] > 1 {
+						// If there were multiple instances, add a second,
+						// so that the annihilation code will see a
+						// duplicate.
+						fields = append(fields, fields[len(fields)-1])
+					}
+					continue
+				}
+
+				if !isNamed {
+					// encoding/json can't distinguish between anonymous
+					// struct types, and neither can we.
+					continue
+				}
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					next = append(next, jsonField{name: sf.Name(), index: index, typ: ft})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool {
+		x := fields
+		if x[i].name != x[j].name {
+			return x[i].name < x[j].name
+		}
+		if len(x[i].index) != len(x[j].index) {
+			return len(x[i].index) < len(x[j].index)
+		}
+		if x[i].tagged != x[j].tagged {
+			return x[i].tagged
+		}
+		return indexLess(x[i].index, x[j].index)
+	})
+
+	// Keep only the dominant field for each name, or no field at all if
+	// none dominates.
+	out := fields[:0]
+	for advance, i := 0, 0; i < len(fields); i += advance {
+		fi := fields[i]
+		for advance = 1; i+advance < len(fields); advance++ {
+			if fields[i+advance].name != fi.name {
+				break
+			}
+		}
+		if advance == 1 {
+			out = append(out, fi)
+			continue
+		}
+		if dominant, ok := dominantJSONField(fields[i : i+advance]); ok {
+			out = append(out, dominant)
+		}
+	}
+	return out
+}
+
+func dominantJSONField(fields []jsonField) (jsonField, bool) {
+	if len(fields) > 1 && len(fields[0].index) == len(fields[1].index) && fields[0].tagged == fields[1].tagged {
+		return jsonField{}, false
+	}
+	return fields[0], true
+}
+
+func indexLess(a, b []int) bool {
+	for i, x := range a {
+		if i >= len(b) {
+			return false
+		}
+		if x != b[i] {
+			return x < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// splitJSONTag splits a json struct tag into its name and its options.
+func splitJSONTag(tag string) (string, string) {
+	if idx := strings.Index(tag, ","); idx != -1 {
+		return tag[:idx], tag[idx:]
+	}
+	return tag, ""
+}
+
+func fieldTag(field *ast.Field) reflect.StructTag {
+	if field.Tag == nil {
+		return ""
+	}
+	tag, err := strconv.Unquote(field.Tag.Value)
+	if err != nil {
+		return ""
+	}
+	return reflect.StructTag(tag)
+}
+
+// setJSONName changes the name in the json tag of the given field, keeping
+// any other tags and any json options. The name "-" causes encoding/json to
+// ignore the field, in which case the options are dropped.
+func setJSONName(field *ast.Field, name string) {
+	tag := string(fieldTag(field))
+	_, options := splitJSONTag(fieldTag(field).Get("json"))
+	value := name + options
+	if name == "-" {
+		value = name
+	}
+
+	var parts []string
+	found := false
+	for _, kv := range parseStructTag(tag) {
+		if kv[0] == "json" {
+			kv[1] = value
+			found = true
+		}
+		parts = append(parts, kv[0]+":"+strconv.Quote(kv[1]))
+	}
+	if !found {
+		parts = append([]string{"json:" + strconv.Quote(value)}, parts...)
+	}
+
+	field.Tag = &ast.BasicLit{
+		Kind:  token.STRING,
+		Value: quoteTag(strings.Join(parts, " ")),
+	}
+}
+
+// filterStructTags removes the struct tags whose keys aren't in the given
+// list from the fields of every struct type in the given declaration,
+// removing tags that are left empty altogether.
+func filterStructTags(node ast.Node, keys []string) {
+	keep := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		keep[key] = true
+	}
+	ast.Inspect(node, func(n ast.Node) bool {
+		field, isField := n.(*ast.Field)
+		if !isField || field.Tag == nil {
+			return true
+		}
+		var parts []string
+		for _, kv := range parseStructTag(string(fieldTag(field))) {
+			if keep[kv[0]] {
+				parts = append(parts, kv[0]+":"+strconv.Quote(kv[1]))
+			}
+		}
+		field.Tag = nil
+		if len(parts) > 0 {
+			field.Tag = &ast.BasicLit{
+				Kind:  token.STRING,
+				Value: quoteTag(strings.Join(parts, " ")),
+			}
+		}
+		return true
+	})
+}
+
+// parseStructTag splits a struct tag into its key/value pairs, following
+// the conventional format understood by reflect.StructTag.Get.
+func parseStructTag(tag string) [][2]string {
+	var ret [][2]string
+	for tag != "" {
+		tag = strings.TrimLeft(tag, " ")
+		i := strings.Index(tag, ":")
+		if i <= 0 || i+1 >= len(tag) || tag[i+1] != '"' {
+			break
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+
+		j := 1
+		for j < len(tag) && tag[j] != '"' {
+			if tag[j] == '\\' {
+				j++
+			}
+			j++
+		}
+		if j >= len(tag) {
+			break
+		}
+		value, err := strconv.Unquote(tag[:j+1])
+		if err != nil {
+			break
+		}
+		ret = append(ret, [2]string{key, value})
+		tag = tag[j+1:]
+	}
+	return ret
+}
+
+// flattenedStructType builds a struct type expression from the given
+// flattened fields, rewriting each one's references to other types in the
+// context of the package where it was declared.
+func flattenedStructType(fields []*flatField, table typeTable) *ast.StructType {
+	st := &ast.StructType{
+		Fields: &ast.FieldList{},
+	}
+	for _, f := range fields {
+		rewriteTypeIdents(f.Field, f.Info, table)
+		st.Fields.List = append(st.Fields.List, f.Field)
+	}
+	return st
+}
@@ -0,0 +1,28 @@
+package pilfer
+
+import (
+	"testing"
+)
+
+func TestFlatten(t *testing.T) {
+	prog := loadTestdata(t, "example.com/flat")
+	roots := []Root{
+		{Package: "example.com/flat", Type: "Pod"},
+		{Package: "example.com/flat", Type: "Tied"},
+	}
+
+	tests := []struct {
+		name    string
+		flatten []string
+	}{
+		{"flatten_all", []string{"all"}},
+		{"flatten_selected", []string{"example.com/flat/meta.ObjectMeta", "example.com/flat.Base"}},
+		{"flatten_none", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := pilferTestdata(t, prog, roots, Options{Flatten: test.flatten})
+			checkGolden(t, test.name, got)
+		})
+	}
+}
@@ -0,0 +1,431 @@
+package pilfer
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/tools/go/loader"
+)
+
+// instanceKey returns a string that uniquely identifies the given
+// instantiation of a generic type, like "example.com/foo.Page[int]", with
+// no spaces between type arguments.
+func instanceKey(named *types.Named) string {
+	key := types.TypeString(named, func(pkg *types.Package) string {
+		return pkg.Path()
+	})
+	return strings.Replace(key, ", ", ",", -1)
+}
+
+// shouldMonomorphize returns true if the given options select the given
+// type, which must be an instantiation of a generic type, to be copied as a
+// new non-generic type.
+func shouldMonomorphize(named *types.Named, opts Options) bool {
+	origin := named.Origin().Obj()
+	if named.TypeArgs().Len() == 0 || hasTypeParams(named) || shouldKeep(origin, opts) {
+		return false
+	}
+
+	genericName := origin.Pkg().Path() + "." + origin.Name()
+	key := instanceKey(named)
+	for _, sel := range opts.Monomorphize {
+		// Type arguments may be written with or without spaces after
+		// their separating commas.
+		sel = strings.Replace(sel, " ", "", -1)
+		if sel == "all" || sel == genericName || sel == key {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTypeParams returns true if any of the type arguments of the given
+// named type involve type parameters, in which case it can't be
+// monomorphized.
+func hasTypeParams(named *types.Named) bool {
+	found := false
+	args := named.TypeArgs()
+	for i := 0; i < args.Len(); i++ {
+		walkTypeNames(args.At(i), "", func(t types.Type, path string) {
+			if _, isParam := t.(*types.TypeParam); isParam {
+				found = true
+			}
+		})
+		if _, isParam := args.At(i).(*types.TypeParam); isParam {
+			found = true
+		}
+	}
+	return found
+}
+
+// instanceName chooses a name for the monomorphized version of the given
+// instantiation, formed by appending the names of its type arguments to the
+// name of the generic type. For example, Page[Resource] becomes
+// PageResource.
+func instanceName(named *types.Named) string {
+	var buf strings.Builder
+	buf.WriteString(named.Obj().Name())
+	args := named.TypeArgs()
+	for i := 0; i < args.Len(); i++ {
+		buf.WriteString(typeArgName(args.At(i)))
+	}
+	return buf.String()
+}
+
+func typeArgName(t types.Type) string {
+	switch tt := t.(type) {
+	case *types.Named:
+		return exportedName(instanceName(tt))
+	case *types.Alias:
+		return exportedName(tt.Obj().Name())
+	case *types.Basic:
+		return exportedName(tt.Name())
+	case *types.Pointer:
+		return "Ptr" + typeArgName(tt.Elem())
+	case *types.Slice:
+		return "SliceOf" + typeArgName(tt.Elem())
+	case *types.Array:
+		return "ArrayOf" + typeArgName(tt.Elem())
+	case *types.Map:
+		return "MapOf" + typeArgName(tt.Key()) + typeArgName(tt.Elem())
+	default:
+		return "Type"
+	}
+}
+
+func exportedName(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[size:]
+}
+
+// addInstanceType is the equivalent of addInterestingTypes for a
+// monomorphized instantiation of a generic type. It adds the given type to
+// the table, and then finds its dependencies by walking the instantiated
+// type rather than the generic type's syntax.
+func addInstanceType(ty *takeType, table typeTable, prog *loader.Program, opts Options) {
+	table.Add(ty)
+
+	walkTypeNames(ty.Instance.Underlying(), "", func(t types.Type, path string) {
+		dep, isNamed := t.(*types.Named)
+		if !isNamed || dep.Obj().Pkg() == nil {
+			return
+		}
+
+		if shouldMonomorphize(dep, opts) {
+			addInstanceRef(ty, path, dep, table, prog, opts)
+			return
+		}
+
+		addNamedRef(ty, path, dep.Origin().Obj(), table, prog, opts)
+		// Type arguments are needed too when not monomorphizing.
+		args := dep.TypeArgs()
+		for i := 0; i < args.Len(); i++ {
+			walkTypeNames(args.At(i), path, func(t types.Type, path string) {
+				if arg, isNamed := t.(*types.Named); isNamed && arg.Obj().Pkg() != nil {
+					addNamedRef(ty, path, arg.Origin().Obj(), table, prog, opts)
+				}
+			})
+		}
+	})
+}
+
+// addNamedRef records a reference from one type to the named type with the
+// given name, adding that type to the table along with all of the types it
+// depends on if it isn't already present.
+func addNamedRef(from *takeType, path string, tn *types.TypeName, table typeTable, prog *loader.Program, opts Options) {
+	// An alias that is kept needs its own entry even if its target already
+	// has one, so this doesn't fall back to the target as TypeByName does.
+	if ty := table.types[tn]; ty != nil {
+		table.AddEdge(from, ty, path)
+		return
+	}
+
+	ty := findTypeName(prog, tn)
+	if ty != nil {
+		ty.Parent = table.AddEdge(from, ty, path)
+		addInterestingTypes(ty, table, prog, opts)
+	}
+}
+
+// addInstanceRef records a reference from one type to an instantiation of
+// a generic type that is to be monomorphized, adding the monomorphized type
+// to the table if it isn't already present. It returns the entry for the
+// monomorphized type, or nil if the generic type's declaration can't be
+// found.
+func addInstanceRef(from *takeType, path string, named *types.Named, table typeTable, prog *loader.Program, opts Options) *takeType {
+	if ty := table.TypeByInstance(named); ty != nil {
+		table.AddEdge(from, ty, path)
+		return ty
+	}
+
+	generic := findTypeName(prog, named.Origin().Obj())
+	if generic == nil {
+		return nil
+	}
+	ty := &takeType{
+		Name:     types.NewTypeName(generic.Ident.Pos(), generic.Name.Pkg(), instanceName(named), named),
+		Ident:    generic.Ident,
+		Spec:     generic.Spec,
+		Type:     named,
+		Instance: named,
+	}
+	ty.Parent = table.AddEdge(from, ty, path)
+	addInstanceType(ty, table, prog, opts)
+	return ty
+}
+
+// walkTypeNames calls the given function for each named type or type
+// parameter that appears in the given type, along with the field path at
+// which it appears, as with walkTypeRefs. It doesn't visit the definitions
+// of the named types it finds.
+func walkTypeNames(t types.Type, path string, cb func(t types.Type, path string)) {
+	switch tt := t.(type) {
+	case *types.Named:
+		cb(tt, path)
+	case *types.TypeParam:
+		cb(tt, path)
+	case *types.Alias:
+		walkTypeNames(types.Unalias(tt), path, cb)
+	case *types.Pointer:
+		walkTypeNames(tt.Elem(), path, cb)
+	case *types.Slice:
+		walkTypeNames(tt.Elem(), path+"[]", cb)
+	case *types.Array:
+		walkTypeNames(tt.Elem(), path+"[]", cb)
+	case *types.Map:
+		walkTypeNames(tt.Key(), path+"[key]", cb)
+		walkTypeNames(tt.Elem(), path+"[value]", cb)
+	case *types.Chan:
+		walkTypeNames(tt.Elem(), path+"<-", cb)
+	case *types.Struct:
+		for i := 0; i < tt.NumFields(); i++ {
+			field := tt.Field(i)
+			walkTypeNames(field.Type(), joinFieldPath(path, field.Name()), cb)
+		}
+	case *types.Interface:
+		for i := 0; i < tt.NumEmbeddeds(); i++ {
+			walkTypeNames(tt.EmbeddedType(i), path, cb)
+		}
+		for i := 0; i < tt.NumExplicitMethods(); i++ {
+			walkTypeNames(tt.ExplicitMethod(i).Type(), path, cb)
+		}
+	case *types.Union:
+		for i := 0; i < tt.Len(); i++ {
+			walkTypeNames(tt.Term(i).Type(), path, cb)
+		}
+	case *types.Signature:
+		for i := 0; i < tt.Params().Len(); i++ {
+			walkTypeNames(tt.Params().At(i).Type(), path, cb)
+		}
+		for i := 0; i < tt.Results().Len(); i++ {
+			walkTypeNames(tt.Results().At(i).Type(), path, cb)
+		}
+	}
+}
+
+// instanceSpec builds a type declaration for the monomorphized version of
+// the given instantiation, using the new names of any types it refers to.
+func instanceSpec(ty *takeType, table typeTable) *ast.TypeSpec {
+	return &ast.TypeSpec{
+		Name: ast.NewIdent(ty.NewName),
+		Type: typeExpr(ty.Instance.Underlying(), table),
+	}
+}
+
+// typeExpr builds a type expression for the given type, using the new names
+// of any types it refers to.
+func typeExpr(t types.Type, table typeTable) ast.Expr {
+	switch tt := t.(type) {
+	case *types.Named:
+		if ty := table.TypeByInstance(tt); ty != nil {
+			return table.refExpr(ty)
+		}
+		var base ast.Expr
+		if ty := table.TypeByName(tt.Origin().Obj()); ty != nil {
+			base = table.refExpr(ty)
+		} else if pkg := tt.Obj().Pkg(); pkg != nil {
+			// A type that isn't copied can only be referred to in its
+			// original package, as for a kept type.
+			base = &ast.SelectorExpr{
+				X:   ast.NewIdent(table.gen.addImport(pkg.Path(), pkg.Name())),
+				Sel: ast.NewIdent(tt.Obj().Name()),
+			}
+		} else {
+			// Predeclared, like error.
+			base = ast.NewIdent(tt.Obj().Name())
+		}
+		args := tt.TypeArgs()
+		switch args.Len() {
+		case 0:
+			return base
+		case 1:
+			return &ast.IndexExpr{
+				X:     base,
+				Index: typeExpr(args.At(0), table),
+			}
+		default:
+			indices := make([]ast.Expr, args.Len())
+			for i := range indices {
+				indices[i] = typeExpr(args.At(i), table)
+			}
+			return &ast.IndexListExpr{
+				X:       base,
+				Indices: indices,
+			}
+		}
+	case *types.Alias:
+		if ty := table.types[tt.Obj()]; ty != nil {
+			return table.refExpr(ty)
+		}
+		return typeExpr(types.Unalias(tt), table)
+	case *types.Basic:
+		if tt.Kind() == types.UnsafePointer {
+			return &ast.SelectorExpr{
+				X:   ast.NewIdent("unsafe"),
+				Sel: ast.NewIdent("Pointer"),
+			}
+		}
+		return ast.NewIdent(tt.Name())
+	case *types.Pointer:
+		return &ast.StarExpr{
+			X: typeExpr(tt.Elem(), table),
+		}
+	case *types.Slice:
+		return &ast.ArrayType{
+			Elt: typeExpr(tt.Elem(), table),
+		}
+	case *types.Array:
+		return &ast.ArrayType{
+			Len: &ast.BasicLit{
+				Kind:  token.INT,
+				Value: strconv.FormatInt(tt.Len(), 10),
+			},
+			Elt: typeExpr(tt.Elem(), table),
+		}
+	case *types.Map:
+		return &ast.MapType{
+			Key:   typeExpr(tt.Key(), table),
+			Value: typeExpr(tt.Elem(), table),
+		}
+	case *types.Chan:
+		dir := ast.SEND | ast.RECV
+		switch tt.Dir() {
+		case types.SendOnly:
+			dir = ast.SEND
+		case types.RecvOnly:
+			dir = ast.RECV
+		}
+		return &ast.ChanType{
+			Dir:   dir,
+			Value: typeExpr(tt.Elem(), table),
+		}
+	case *types.Struct:
+		fields := &ast.FieldList{}
+		for i := 0; i < tt.NumFields(); i++ {
+			v := tt.Field(i)
+			field := &ast.Field{
+				Type: typeExpr(v.Type(), table),
+			}
+			if !v.Embedded() {
+				field.Names = []*ast.Ident{ast.NewIdent(v.Name())}
+			}
+			if tag := tt.Tag(i); tag != "" {
+				field.Tag = &ast.BasicLit{
+					Kind:  token.STRING,
+					Value: quoteTag(tag),
+				}
+			}
+			fields.List = append(fields.List, field)
+		}
+		return &ast.StructType{
+			Fields: fields,
+		}
+	case *types.Interface:
+		methods := &ast.FieldList{}
+		for i := 0; i < tt.NumEmbeddeds(); i++ {
+			methods.List = append(methods.List, &ast.Field{
+				Type: typeExpr(tt.EmbeddedType(i), table),
+			})
+		}
+		for i := 0; i < tt.NumExplicitMethods(); i++ {
+			m := tt.ExplicitMethod(i)
+			methods.List = append(methods.List, &ast.Field{
+				Names: []*ast.Ident{ast.NewIdent(m.Name())},
+				Type:  typeExpr(m.Type(), table),
+			})
+		}
+		return &ast.InterfaceType{
+			Methods: methods,
+		}
+	case *types.Union:
+		var expr ast.Expr
+		for i := 0; i < tt.Len(); i++ {
+			term := typeExpr(tt.Term(i).Type(), table)
+			if tt.Term(i).Tilde() {
+				term = &ast.UnaryExpr{
+					Op: token.TILDE,
+					X:  term,
+				}
+			}
+			if expr == nil {
+				expr = term
+			} else {
+				expr = &ast.BinaryExpr{
+					X:  expr,
+					Op: token.OR,
+					Y:  term,
+				}
+			}
+		}
+		return expr
+	case *types.Signature:
+		return &ast.FuncType{
+			Params:  tupleFields(tt.Params(), tt.Variadic(), table),
+			Results: tupleFields(tt.Results(), false, table),
+		}
+	case *types.TypeParam:
+		return ast.NewIdent(tt.Obj().Name())
+	default:
+		// Should never happen for the types that can appear in a type
+		// declaration, but we'll produce something that will at least
+		// make the problem visible in the generated code.
+		return ast.NewIdent(t.String())
+	}
+}
+
+func tupleFields(tuple *types.Tuple, variadic bool, table typeTable) *ast.FieldList {
+	fields := &ast.FieldList{}
+	for i := 0; i < tuple.Len(); i++ {
+		v := tuple.At(i)
+		var typ ast.Expr
+		if variadic && i == tuple.Len()-1 {
+			typ = &ast.Ellipsis{
+				Elt: typeExpr(v.Type().(*types.Slice).Elem(), table),
+			}
+		} else {
+			typ = typeExpr(v.Type(), table)
+		}
+		field := &ast.Field{
+			Type: typ,
+		}
+		if v.Name() != "" {
+			field.Names = []*ast.Ident{ast.NewIdent(v.Name())}
+		}
+		fields.List = append(fields.List, field)
+	}
+	return fields
+}
+
+// quoteTag returns a Go string literal for the given struct tag, preferring
+// a raw string literal as is conventional for tags.
+func quoteTag(tag string) string {
+	if strings.ContainsAny(tag, "`\r") {
+		return strconv.Quote(tag)
+	}
+	return "`" + tag + "`"
+}
@@ -0,0 +1,80 @@
+package pilfer
+
+import (
+	"bytes"
+	"go/format"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+func TestMonomorphize(t *testing.T) {
+	prog := loadTestdata(t, "example.com/generic")
+	roots := []Root{{Package: "example.com/generic", Type: "Config"}}
+
+	// Kept types are referred to in their own package, also from within
+	// the new declarations of instantiations.
+	opts := Options{
+		Monomorphize: []string{"all"},
+		Keep:         []string{"time.Time"},
+	}
+	checkGolden(t, "generic_all", pilferTestdata(t, prog, roots, opts))
+
+	// Instantiations can also be selected one at a time, with or without
+	// spaces between type arguments.
+	opts.Monomorphize = []string{"example.com/generic.Pair[string, int]"}
+	checkContains(t, "generic_one", pilferTestdata(t, prog, roots, opts),
+		"Pairs []PairStringInt",
+		"Other Pair[Cursor, []error]",
+		"type Page[T any] struct",
+	)
+}
+
+func TestTypeExprUncopied(t *testing.T) {
+	// A named type that isn't in the table, because its declaration
+	// couldn't be found, is referred to in its original package.
+	pkg := types.NewPackage("example.com/other", "other")
+	named := types.NewNamed(types.NewTypeName(token.NoPos, pkg, "Thing", nil), types.Typ[types.Int], nil)
+	errorType := types.Universe.Lookup("error").Type()
+
+	table := newTypeTable(Options{})
+	table.gen.imports = map[string]string{}
+	got := typeExprString(t, typeExpr(types.NewMap(named, errorType), table))
+	if want := "map[other.Thing]error"; got != want {
+		t.Errorf("wrong type expression %q; want %q", got, want)
+	}
+	if name := table.gen.imports["example.com/other"]; name != "other" {
+		t.Errorf("package imported as %q; want other", name)
+	}
+}
+
+func TestInstanceKey(t *testing.T) {
+	pkg := types.NewPackage("example.com/foo", "foo")
+	tparams := []*types.TypeParam{
+		types.NewTypeParam(types.NewTypeName(token.NoPos, pkg, "K", nil), types.Universe.Lookup("comparable").Type()),
+		types.NewTypeParam(types.NewTypeName(token.NoPos, pkg, "V", nil), types.NewInterfaceType(nil, nil)),
+	}
+	generic := types.NewNamed(types.NewTypeName(token.NoPos, pkg, "Pair", nil), types.NewStruct(nil, nil), nil)
+	generic.SetTypeParams(tparams)
+	other := types.NewNamed(types.NewTypeName(token.NoPos, pkg, "Other", nil), types.Typ[types.Int], nil)
+	inst, err := types.Instantiate(nil, generic, []types.Type{types.Typ[types.String], types.NewMap(types.Typ[types.String], other)}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The arguments are separated by commas alone, as they may be
+	// written in Options.Monomorphize.
+	got := instanceKey(inst.(*types.Named))
+	if want := "example.com/foo.Pair[string,map[string]example.com/foo.Other]"; got != want {
+		t.Errorf("wrong key %q; want %q", got, want)
+	}
+}
+
+func typeExprString(t *testing.T, expr interface{}) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := format.Node(&buf, token.NewFileSet(), expr); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
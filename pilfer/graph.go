@@ -0,0 +1,198 @@
+package pilfer
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"strconv"
+)
+
+// typeEdge is a reference from one type's definition to another type,
+// recorded while traversing the type graph.
+type typeEdge struct {
+	From *takeType
+	To   *takeType
+
+	// Path is the location of the reference within the definition of From,
+	// such as "Inner", "Others[]" or "Labels[value]". It is empty if From
+	// is defined directly in terms of To.
+	Path string
+}
+
+func (e *typeEdge) String() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s -> %s", e.From.QualifiedName(), e.To.QualifiedName())
+	}
+	return fmt.Sprintf("%s.%s -> %s", e.From.QualifiedName(), e.Path, e.To.QualifiedName())
+}
+
+// walkTypeRefs calls the given function for each identifier in the given
+// type expression that could refer to a named type, along with the field
+// path at which that identifier appears.
+//
+// The given function also receives the expression containing the reference,
+// which for an instantiation of a generic type is the whole index
+// expression. In that case, the type arguments are visited only if the
+// function returns true.
+func walkTypeRefs(expr ast.Expr, path string, cb func(ref ast.Expr, ident *ast.Ident, path string) bool) {
+	switch tn := expr.(type) {
+	case *ast.Ident:
+		cb(tn, tn, path)
+	case *ast.SelectorExpr:
+		cb(tn, tn.Sel, path)
+	case *ast.IndexExpr:
+		if cb(tn, typeRefIdent(tn.X), path) {
+			walkTypeRefs(tn.Index, path, cb)
+		}
+	case *ast.IndexListExpr:
+		if cb(tn, typeRefIdent(tn.X), path) {
+			for _, index := range tn.Indices {
+				walkTypeRefs(index, path, cb)
+			}
+		}
+	case *ast.ParenExpr:
+		walkTypeRefs(tn.X, path, cb)
+	case *ast.StarExpr:
+		walkTypeRefs(tn.X, path, cb)
+	case *ast.ArrayType:
+		walkTypeRefs(tn.Elt, path+"[]", cb)
+	case *ast.MapType:
+		walkTypeRefs(tn.Key, path+"[key]", cb)
+		walkTypeRefs(tn.Value, path+"[value]", cb)
+	case *ast.ChanType:
+		walkTypeRefs(tn.Value, path+"<-", cb)
+	case *ast.StructType:
+		for _, field := range tn.Fields.List {
+			if len(field.Names) == 0 {
+				walkTypeRefs(field.Type, joinFieldPath(path, embeddedFieldName(field.Type)), cb)
+				continue
+			}
+			for _, name := range field.Names {
+				walkTypeRefs(field.Type, joinFieldPath(path, name.Name), cb)
+			}
+		}
+	default:
+		// For anything else (interfaces, function signatures, constraints)
+		// we don't try to be precise about the path, and just attribute
+		// everything to the expression as a whole.
+		astVisitor(func(node ast.Node) {
+			if ident, isIdent := node.(*ast.Ident); isIdent {
+				cb(ident, ident, path)
+			}
+		}).VisitAll(expr)
+	}
+}
+
+// walkTypeParamRefs is like walkTypeRefs, but visits the constraints in the
+// given type parameter list, if any.
+func walkTypeParamRefs(params *ast.FieldList, cb func(ref ast.Expr, ident *ast.Ident, path string) bool) {
+	if params == nil {
+		return
+	}
+	for _, field := range params.List {
+		for _, name := range field.Names {
+			walkTypeRefs(field.Type, "["+name.Name+"]", cb)
+		}
+	}
+}
+
+// typeRefIdent returns the identifier that names the type in the given
+// type name expression, which is either an identifier or a qualified
+// identifier.
+func typeRefIdent(expr ast.Expr) *ast.Ident {
+	switch tn := expr.(type) {
+	case *ast.Ident:
+		return tn
+	case *ast.SelectorExpr:
+		return tn.Sel
+	case *ast.ParenExpr:
+		return typeRefIdent(tn.X)
+	default:
+		return nil
+	}
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// embeddedFieldName returns the implicit field name of an embedded field
+// with the given type expression.
+func embeddedFieldName(expr ast.Expr) string {
+	switch tn := expr.(type) {
+	case *ast.Ident:
+		return tn.Name
+	case *ast.SelectorExpr:
+		return tn.Sel.Name
+	case *ast.StarExpr:
+		return embeddedFieldName(tn.X)
+	case *ast.IndexExpr:
+		return embeddedFieldName(tn.X)
+	case *ast.IndexListExpr:
+		return embeddedFieldName(tn.X)
+	case *ast.ParenExpr:
+		return embeddedFieldName(tn.X)
+	default:
+		return "?"
+	}
+}
+
+// shortestChain returns the shortest sequence of edges leading from the
+// given root type to the given target type, or nil if the target is not
+// reachable from the root.
+func shortestChain(table typeTable, root, target *takeType) []*typeEdge {
+	if root == target {
+		return []*typeEdge{}
+	}
+
+	via := map[*takeType]*typeEdge{root: nil}
+	queue := []*takeType{root}
+	for len(queue) > 0 {
+		ty := queue[0]
+		queue = queue[1:]
+		for _, edge := range table.Edges(ty) {
+			if _, seen := via[edge.To]; seen {
+				continue
+			}
+			via[edge.To] = edge
+			if edge.To == target {
+				var chain []*typeEdge
+				for e := edge; e != nil; e = via[e.From] {
+					chain = append([]*typeEdge{e}, chain...)
+				}
+				return chain
+			}
+			queue = append(queue, edge.To)
+		}
+	}
+
+	return nil
+}
+
+// writeDOT writes the given type graph to the given writer in the Graphviz
+// DOT language, with one node per type and one labelled edge per reference.
+func writeDOT(w io.Writer, table typeTable) {
+	fmt.Fprintln(w, "digraph pilfer {")
+	fmt.Fprintln(w, "\tnode [shape=box];")
+	newNames := table.NewNames()
+	for _, newName := range newNames {
+		ty := table.TypeByNewName(newName)
+		label := fmt.Sprintf("%s\n%s", ty.NewName, ty.QualifiedName())
+		fmt.Fprintf(w, "\t%s [label=%s];\n", strconv.Quote(ty.QualifiedName()), strconv.Quote(label))
+	}
+	for _, newName := range newNames {
+		ty := table.TypeByNewName(newName)
+		for _, edge := range table.Edges(ty) {
+			fmt.Fprintf(
+				w, "\t%s -> %s [label=%s];\n",
+				strconv.Quote(edge.From.QualifiedName()),
+				strconv.Quote(edge.To.QualifiedName()),
+				strconv.Quote(edge.Path),
+			)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
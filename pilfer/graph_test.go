@@ -0,0 +1,75 @@
+package pilfer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	prog := loadTestdata(t, "example.com/graph")
+	long := Root{Package: "example.com/graph", Type: "Long"}
+	short := Root{Package: "example.com/graph", Type: "Short"}
+
+	tests := []struct {
+		name   string
+		roots  []Root
+		victim string
+		want   string
+	}{
+		{
+			"through fields and slices",
+			[]Root{long},
+			"example.com/graph.Leaf",
+			"example.com/graph.Long\n" +
+				"  example.com/graph.Long.Middle -> example.com/graph.Middle\n" +
+				"  example.com/graph.Middle.Items[] -> example.com/graph.Leaf\n",
+		},
+		{
+			// The chain from the root that reaches the type soonest is
+			// chosen, whatever the order of the roots.
+			"shortest of several roots",
+			[]Root{long, short},
+			"example.com/graph.Leaf",
+			"example.com/graph.Short\n" +
+				"  example.com/graph.Short.Leaves[value] -> example.com/graph.Leaf\n",
+		},
+		{
+			"root itself",
+			[]Root{long},
+			"example.com/graph.Long",
+			"example.com/graph.Long\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := prog.Explain(test.roots, test.victim, &buf, Options{}); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != test.want {
+				t.Errorf("wrong chain\ngot:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	err := prog.Explain([]Root{long}, "example.com/graph.Unused", &buf, Options{})
+	if err == nil || err.Error() != "type example.com/graph.Unused is not used by any of the root types" {
+		t.Errorf("wrong error for an unused type: %v", err)
+	}
+}
+
+func TestGraph(t *testing.T) {
+	prog := loadTestdata(t, "example.com/graph")
+	roots := []Root{
+		{Package: "example.com/graph", Type: "Long"},
+		{Package: "example.com/graph", Type: "Short"},
+	}
+
+	var buf bytes.Buffer
+	if err := prog.Graph(roots, &buf, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "graph", buf.Bytes())
+	checkNotContains(t, "graph", buf.Bytes(), "Unused")
+}
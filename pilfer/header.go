@@ -0,0 +1,270 @@
+package pilfer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/build"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/vcs"
+)
+
+// GeneratedHeader is the comment line that marks a file as having been
+// generated by pilfer, following the convention that tools and linters use
+// to recognize generated code.
+const GeneratedHeader = "// " + generatedComment
+
+const generatedComment = "Code generated by go-pilfer; DO NOT EDIT."
+
+// IsGenerated returns true if the given Go source file contains pilfer's
+// generated code header before its package clause.
+//
+// It also recognizes the other formats that pilfer generates, whose
+// headers are comments in the same form, HTML comments or, for JSON
+// Schema, the top-level "$comment" member.
+func IsGenerated(src []byte) bool {
+	jsonHeader := `"$comment": ` + strconv.Quote(generatedComment)
+	htmlHeader := "<!-- " + generatedComment + " -->"
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == GeneratedHeader || line == htmlHeader || strings.TrimSuffix(line, ",") == jsonHeader {
+			return true
+		}
+		if strings.HasPrefix(line, "package ") {
+			return false
+		}
+	}
+	return false
+}
+
+// writeHeader writes the generated code header, along with a description
+// of where the generated declarations came from.
+//
+// The header is followed by a blank line so that it will not be taken as
+// the package documentation comment.
+func writeHeader(w io.Writer, prog *loader.Program, roots []*takeType, pkgPaths []string, opts Options) {
+	fmt.Fprintf(w, "%s\n//\n", GeneratedHeader)
+	for _, line := range headerLines(prog, roots, pkgPaths, opts) {
+		fmt.Fprintf(w, "// %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+// headerLines returns the lines of the description of where the generated
+// declarations came from, which follows the generated code header.
+func headerLines(prog *loader.Program, roots []*takeType, pkgPaths []string, opts Options) []string {
+	var lines []string
+	if opts.Command != "" {
+		lines = append(lines, fmt.Sprintf("Command:  %s", opts.Command))
+	}
+	if opts.Directive != "" {
+		lines = append(lines, fmt.Sprintf("From:     %s", opts.Directive))
+	}
+	rootNames := make([]string, len(roots))
+	for i, root := range roots {
+		rootNames[i] = root.QualifiedName()
+	}
+	lines = append(lines, fmt.Sprintf("Roots:    %s", strings.Join(rootNames, ", ")))
+	lines = append(lines, fmt.Sprintf("Packages: %s", strings.Join(pkgPaths, ", ")))
+
+	// The roots may belong to different repositories, each with its own
+	// revision, so each repository gets a line of its own. When there are
+	// several, each line names the first root package found in it.
+	var revs, revPkgs []string
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		pkgPath := root.Name.Pkg().Path()
+		repo, rev := sourceRevision(packageDir(prog, pkgPath))
+		if rev == "" || seen[repo] {
+			continue
+		}
+		seen[repo] = true
+		revs = append(revs, rev)
+		revPkgs = append(revPkgs, pkgPath)
+	}
+	for i, rev := range revs {
+		if len(revs) > 1 {
+			rev = fmt.Sprintf("%s (%s)", rev, revPkgs[i])
+		}
+		lines = append(lines, fmt.Sprintf("Revision: %s", rev))
+	}
+	return lines
+}
+
+// packageDir returns the directory containing the source files of the given
+// package, or an empty string if it cannot be determined.
+func packageDir(prog *loader.Program, pkgPath string) string {
+	info := prog.Package(pkgPath)
+	if info == nil || len(info.Files) == 0 {
+		return ""
+	}
+	filename := prog.Fset.Position(info.Files[0].Package).Filename
+	if filename == "" {
+		return ""
+	}
+	return filepath.Dir(filename)
+}
+
+// sourceRevision returns the root directory of the repository that the
+// given directory belongs to, along with a description of its current
+// version control revision, or empty strings if it is not under a
+// recognized version control system.
+//
+// For Git and Mercurial the description includes the current revision and
+// whether the repository has uncommitted changes anywhere in its working
+// directory. For other systems only the name of the system is returned.
+func sourceRevision(dir string) (string, string) {
+	if dir == "" {
+		return "", ""
+	}
+
+	srcRoot := vcsSrcRoot(dir)
+	cmd, repo, err := vcs.FromDir(dir, srcRoot)
+	if err != nil {
+		return "", ""
+	}
+	return filepath.Join(srcRoot, filepath.FromSlash(repo)), repoRevision(cmd, dir)
+}
+
+// repoRevision returns the description of the revision that sourceRevision
+// returns for a directory under the given version control system.
+func repoRevision(cmd *vcs.Cmd, dir string) string {
+
+	switch cmd.Cmd {
+	case "git":
+		rev, err := vcsOutput(dir, "git", "rev-parse", "HEAD")
+		if err != nil {
+			return cmd.Name
+		}
+		status, err := vcsOutput(dir, "git", "status", "--porcelain")
+		if err != nil {
+			return fmt.Sprintf("%s %s", cmd.Name, rev)
+		}
+		if status != "" {
+			return fmt.Sprintf("%s %s (dirty)", cmd.Name, rev)
+		}
+		return fmt.Sprintf("%s %s", cmd.Name, rev)
+	case "hg":
+		// "hg identify" marks a dirty working directory with a trailing "+"
+		id, err := vcsOutput(dir, "hg", "identify", "--id", "--debug")
+		if err != nil {
+			return cmd.Name
+		}
+		if strings.HasSuffix(id, "+") {
+			return fmt.Sprintf("%s %s (dirty)", cmd.Name, strings.TrimSuffix(id, "+"))
+		}
+		return fmt.Sprintf("%s %s", cmd.Name, id)
+	default:
+		return cmd.Name
+	}
+}
+
+// vcsSrcRoot chooses a source root to pass to vcs.FromDir for the given
+// directory. This is the GOPATH or GOROOT source directory containing it if
+// there is one, or otherwise its top-level directory.
+func vcsSrcRoot(dir string) string {
+	for _, srcDir := range build.Default.SrcDirs() {
+		if strings.HasPrefix(dir, srcDir+string(filepath.Separator)) {
+			return srcDir
+		}
+	}
+
+	root := dir
+	for {
+		parent := filepath.Dir(root)
+		if parent == filepath.Dir(parent) {
+			return root
+		}
+		root = parent
+	}
+}
+
+func vcsOutput(dir string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// SameGenerated returns true if the two given generated files differ only in
+// their headers, such as when they were generated from the same source by
+// different command lines or from different working copies.
+//
+// For files produced by Merge, the descriptions following MergeBeginMarker
+// are ignored in the same way.
+func SameGenerated(a, b []byte) bool {
+	return bytes.Equal(withoutMergeHeader(generatedBody(a)), withoutMergeHeader(generatedBody(b)))
+}
+
+// withoutMergeHeader returns the given source with the comment lines that
+// follow MergeBeginMarker removed, if it contains that marker.
+func withoutMergeHeader(src []byte) []byte {
+	begin, _, found := findMergeRegion(src)
+	if !found {
+		return src
+	}
+	start := begin + len(MergeBeginMarker) + 1
+	end := start
+	for end < len(src) && bytes.HasPrefix(src[end:], []byte("//")) {
+		next := bytes.IndexByte(src[end:], '\n')
+		if next < 0 {
+			end = len(src)
+			break
+		}
+		end += next + 1
+	}
+
+	ret := make([]byte, 0, len(src)-(end-start))
+	ret = append(ret, src[:start]...)
+	return append(ret, src[end:]...)
+}
+
+// generatedBody returns the given source file with everything before its
+// package clause removed, or for a file that has no package clause, such as
+// TypeScript declarations, with its leading comment lines removed. Leading
+// HTML comments are removed from Markdown and HTML files.
+func generatedBody(src []byte) []byte {
+	if bytes.HasPrefix(src, []byte("<!--")) {
+		for bytes.HasPrefix(src, []byte("<!--")) {
+			end := bytes.Index(src, []byte("-->"))
+			if end < 0 {
+				break
+			}
+			src = bytes.TrimLeft(src[end+len("-->"):], "\n")
+		}
+		return src
+	}
+
+	header := -1
+	for offset := 0; offset < len(src); {
+		next := bytes.IndexByte(src[offset:], '\n')
+		line := src[offset:]
+		if next >= 0 {
+			line = line[:next]
+		}
+		if bytes.HasPrefix(line, []byte("package ")) {
+			return src[offset:]
+		}
+		if header < 0 && !bytes.HasPrefix(line, []byte("//")) && len(bytes.TrimSpace(line)) != 0 {
+			header = offset
+		}
+		if next < 0 {
+			break
+		}
+		offset += next + 1
+	}
+	if header >= 0 {
+		return src[header:]
+	}
+	return src
+}
@@ -0,0 +1,130 @@
+package pilfer
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsGenerated(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want bool
+	}{
+		{
+			"go",
+			GeneratedHeader + "\n\npackage gen\n",
+			true,
+		},
+		{
+			"go after other comments",
+			"// Command: pilfer example.com/a:Config\n" + GeneratedHeader + "\n\npackage gen\n",
+			true,
+		},
+		{
+			"hand-written",
+			"// Package gen is written by hand.\npackage gen\n",
+			false,
+		},
+		{
+			"header after the package clause",
+			"package gen\n\n" + GeneratedHeader + "\n",
+			false,
+		},
+		{
+			"similar comment",
+			"// Code generated by stringer; DO NOT EDIT.\n\npackage gen\n",
+			false,
+		},
+		{
+			"html",
+			"<!-- " + generatedComment + " -->\n<h1>Config</h1>\n",
+			true,
+		},
+		{
+			"json schema",
+			"{\n  \"$comment\": \"" + generatedComment + "\",\n  \"$defs\": {}\n}\n",
+			true,
+		},
+		{
+			"empty",
+			"",
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsGenerated([]byte(test.src)); got != test.want {
+				t.Errorf("IsGenerated returned %v; want %v", got, test.want)
+			}
+		})
+	}
+
+	// Whatever pilfer writes must be recognized when it is run again.
+	prog := loadTestdata(t, "example.com/enums")
+	roots := []Root{{Package: "example.com/enums", Type: "Config"}}
+	if src := pilferTestdata(t, prog, roots, Options{}); !IsGenerated(src) {
+		t.Errorf("IsGenerated returned false for pilfer's own output:\n%s", src)
+	}
+}
+
+func TestSourceRevision(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	dir, err := ioutil.TempDir("", "pilfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Two repositories, one of them with a package in a subdirectory.
+	repoA := filepath.Join(dir, "a")
+	repoB := filepath.Join(dir, "b")
+	pkgB := filepath.Join(repoB, "sub", "pkg")
+	for _, repo := range []string{repoA, repoB} {
+		if err := os.MkdirAll(repo, 0755); err != nil {
+			t.Fatal(err)
+		}
+		git(t, repo, "init", "-q")
+		git(t, repo, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "initial")
+	}
+	if err := os.MkdirAll(pkgB, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	gotRepoA, revA := sourceRevision(repoA)
+	gotRepoB, revB := sourceRevision(pkgB)
+	if gotRepoA != repoA || gotRepoB != repoB {
+		t.Fatalf("wrong repositories %q and %q; want %q and %q", gotRepoA, gotRepoB, repoA, repoB)
+	}
+	if want := "Git " + git(t, repoA, "rev-parse", "HEAD"); revA != want {
+		t.Errorf("wrong revision for a %q; want %q", revA, want)
+	}
+
+	// A change anywhere in a repository makes it dirty, even outside the
+	// package directory, but doesn't affect the other repository.
+	if err := ioutil.WriteFile(filepath.Join(repoB, "new.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, rev := sourceRevision(pkgB); rev != revB+" (dirty)" {
+		t.Errorf("wrong revision for dirty b %q; want %q", rev, revB+" (dirty)")
+	}
+	if _, rev := sourceRevision(repoA); rev != revA {
+		t.Errorf("wrong revision for a %q after changing b; want %q", rev, revA)
+	}
+}
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := vcsOutput(dir, "git", args...)
+	if err != nil {
+		t.Fatalf("git %s: %s", strings.Join(args, " "), err)
+	}
+	return out
+}
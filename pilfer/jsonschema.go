@@ -0,0 +1,443 @@
+package pilfer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/constant"
+	"go/types"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// jsonSchemaDialect identifies the version of JSON Schema that JSONSchema
+// produces.
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchema is a JSON Schema, or one of the subschemas within it. Only the
+// keywords that JSONSchema uses are included. Type is either a string or,
+// for types that also allow null, a slice of strings.
+type jsonSchema struct {
+	Schema               string        `json:"$schema,omitempty"`
+	Comment              string        `json:"$comment,omitempty"`
+	Ref                  string        `json:"$ref,omitempty"`
+	Description          string        `json:"description,omitempty"`
+	Type                 interface{}   `json:"type,omitempty"`
+	Format               string        `json:"format,omitempty"`
+	ContentEncoding      string        `json:"contentEncoding,omitempty"`
+	Enum                 []interface{} `json:"enum,omitempty"`
+	AnyOf                []*jsonSchema `json:"anyOf,omitempty"`
+	Items                *jsonSchema   `json:"items,omitempty"`
+	MinItems             *int64        `json:"minItems,omitempty"`
+	MaxItems             *int64        `json:"maxItems,omitempty"`
+	Properties           schemaMap     `json:"properties,omitempty"`
+	Required             []string      `json:"required,omitempty"`
+	AdditionalProperties *jsonSchema   `json:"additionalProperties,omitempty"`
+	Defs                 schemaMap     `json:"$defs,omitempty"`
+}
+
+// schemaMap is a JSON object whose values are schemas, which keeps its
+// members in the order they were added.
+type schemaMap []schemaMember
+
+type schemaMember struct {
+	Name   string
+	Schema *jsonSchema
+}
+
+func (m schemaMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, member := range m {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(member.Name)
+		if err != nil {
+			return nil, err
+		}
+		schema, err := json.Marshal(member.Schema)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(schema)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// JSONSchema writes to the given writer a JSON Schema describing the JSON
+// encoding of the given root types, as produced by encoding/json.
+//
+// The schema is built from the same types and constants that Pilfer would
+// copy, with a definition for each named type that the roots depend on,
+// under the name its copy would have. Struct fields follow their json tags,
+// with fields that have the omitempty option not required, pointers
+// nullable, maps as objects with additionalProperties, and copied constants
+// as enum values. Slices and maps are nullable too, since encoding/json
+// encodes nil ones as null, except for fields with the omitempty option,
+// which leave them out instead. Constants that are bit flags, as for
+// Options.Enums, are listed in a description instead, since values can
+// combine them. Types that encode themselves, by implementing
+// json.Marshaler or encoding.TextMarshaler, can't be described and so allow
+// any value, or any string, respectively, except for time.Time.
+//
+// If there is only one root type then the schema refers to its definition,
+// and otherwise it consists only of the definitions. The definitions all
+// share one namespace, so opts.Layout is ignored.
+func (p *Program) JSONSchema(roots []Root, w io.Writer, opts Options) error {
+	opts.Layout = SingleFile
+	rootTypes, table, err := p.collect(roots, opts)
+	if err != nil {
+		return err
+	}
+	consts := findInterestingConsts(p.prog, table)
+	b := &schemaBuilder{
+		table:      table,
+		consts:     consts,
+		constNames: consts.NewNamesByTypeName(),
+		defs:       make(map[string]*jsonSchema),
+	}
+
+	schema := &jsonSchema{
+		Schema:  jsonSchemaDialect,
+		Comment: generatedComment,
+	}
+	for _, root := range rootTypes {
+		ref := b.ref(schemaRootType(root, table))
+		if len(rootTypes) == 1 {
+			schema.Ref = ref.Ref
+		}
+	}
+	for len(b.pending) > 0 {
+		ty := b.pending[0]
+		b.pending = b.pending[1:]
+		b.defs[ty.NewName] = b.def(ty)
+	}
+
+	names := make([]string, 0, len(b.defs))
+	for name := range b.defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		schema.Defs = append(schema.Defs, schemaMember{
+			Name:   name,
+			Schema: b.defs[name],
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(schema)
+}
+
+// schemaRootType returns the type whose definition describes the given root
+// type, following aliases.
+func schemaRootType(ty *takeType, table typeTable) *takeType {
+	if ty.IsAlias() {
+		if named, isNamed := types.Unalias(ty.Type).(*types.Named); isNamed {
+			if target := table.TypeByName(named.Obj()); target != nil {
+				return target
+			}
+		}
+	}
+	return ty
+}
+
+// schemaBuilder builds the definitions for a JSON Schema, adding one for
+// each named type as it is first referred to.
+type schemaBuilder struct {
+	table      typeTable
+	consts     constantTable
+	constNames map[string][]string
+
+	defs    map[string]*jsonSchema
+	pending []*takeType
+
+	// visiting tracks the instantiations of generic types that are being
+	// described in place, to avoid infinite recursion.
+	visiting map[string]bool
+}
+
+// ref returns a schema referring to the definition of the given type,
+// arranging for the definition to be built if it hasn't been already.
+func (b *schemaBuilder) ref(ty *takeType) *jsonSchema {
+	if _, has := b.defs[ty.NewName]; !has {
+		b.defs[ty.NewName] = nil
+		b.pending = append(b.pending, ty)
+	}
+	return &jsonSchema{Ref: "#/$defs/" + ty.NewName}
+}
+
+// def returns the definition of the given named type.
+func (b *schemaBuilder) def(ty *takeType) *jsonSchema {
+	t := ty.Type
+	if ty.Instance != nil {
+		t = ty.Instance
+	}
+	if special := specialSchema(t); special != nil {
+		return special
+	}
+
+	def := b.schema(t.Underlying())
+	key := b.table.nameKey(ty.OutputPkgPath(), ty.NewName)
+	var consts []*takeConstant
+	for _, constName := range b.constNames[key] {
+		consts = append(consts, b.consts.ConstantByNewName(constName))
+	}
+	values := enumValues(consts)
+	if basic, isBasic := t.Underlying().(*types.Basic); isBasic && len(values) > 0 {
+		if flags := flagValues(basic, values); flags != nil {
+			def.Description = flagsDescription(flags)
+			return def
+		}
+	}
+	for _, v := range values {
+		def.Enum = append(def.Enum, constantJSON(v.first().Value))
+	}
+	return def
+}
+
+// flagsDescription describes a type whose constants are the given bit
+// flags, whose values can't be listed because they can be combined.
+func flagsDescription(flags []*enumValue) string {
+	names := make([]string, len(flags))
+	for i, v := range flags {
+		names[i] = fmt.Sprintf("%s (%s)", v.first().NewName, v.first().Value.ExactString())
+	}
+	return "Bit flags: any combination of " + strings.Join(names, ", ") + "."
+}
+
+// schema returns a schema for the JSON encoding of the given type,
+// referring to the definitions of the named types it uses.
+//
+// The instantiations of generic types that aren't being monomorphized have
+// no definitions of their own and so are described in place.
+func (b *schemaBuilder) schema(t types.Type) *jsonSchema {
+	if special := specialSchema(t); special != nil {
+		return special
+	}
+
+	switch tt := types.Unalias(t).(type) {
+	case *types.Named:
+		if ty := b.table.TypeByInstance(tt); ty != nil {
+			return b.ref(ty)
+		}
+		if tt.TypeArgs().Len() == 0 {
+			if ty := b.table.TypeByName(tt.Obj()); ty != nil {
+				return b.ref(ty)
+			}
+			return b.schema(tt.Underlying())
+		}
+		key := instanceKey(tt)
+		if b.visiting[key] {
+			return &jsonSchema{}
+		}
+		if b.visiting == nil {
+			b.visiting = make(map[string]bool)
+		}
+		b.visiting[key] = true
+		defer delete(b.visiting, key)
+		return b.schema(tt.Underlying())
+	case *types.Basic:
+		return basicSchema(tt)
+	case *types.Pointer:
+		return nullableSchema(b.schema(tt.Elem()))
+	case *types.Slice:
+		if isByte(tt.Elem()) {
+			return &jsonSchema{
+				Type:            []string{"string", "null"},
+				ContentEncoding: "base64",
+			}
+		}
+		return &jsonSchema{
+			Type:  []string{"array", "null"},
+			Items: b.schema(tt.Elem()),
+		}
+	case *types.Array:
+		n := tt.Len()
+		return &jsonSchema{
+			Type:     "array",
+			Items:    b.schema(tt.Elem()),
+			MinItems: &n,
+			MaxItems: &n,
+		}
+	case *types.Map:
+		return &jsonSchema{
+			Type:                 []string{"object", "null"},
+			AdditionalProperties: b.schema(tt.Elem()),
+		}
+	case *types.Struct:
+		return b.structSchema(tt)
+	default:
+		// Interfaces can hold anything, and other types can't be encoded.
+		return &jsonSchema{}
+	}
+}
+
+// structSchema returns a schema for the JSON encoding of the given struct
+// type, with a property for each field that encoding/json would include.
+func (b *schemaBuilder) structSchema(st *types.Struct) *jsonSchema {
+	schema := &jsonSchema{
+		Type:       "object",
+		Properties: schemaMap{},
+	}
+	for _, jf := range orderedJSONFields(st) {
+		field, tag, viaPointer := fieldByIndex(st, jf.index)
+		_, opts := splitJSONTag(tag)
+
+		var prop *jsonSchema
+		if strings.Contains(opts, ",string") && isStringable(field.Type()) {
+			prop = &jsonSchema{Type: "string"}
+		} else {
+			prop = b.schema(field.Type())
+		}
+		if strings.Contains(opts, ",omitempty") {
+			// An empty slice or map is left out rather than encoded as
+			// null, unless it has a named type whose definition is shared.
+			if types, isNullable := prop.Type.([]string); isNullable {
+				prop.Type = types[0]
+			}
+		}
+		schema.Properties = append(schema.Properties, schemaMember{
+			Name:   jf.name,
+			Schema: prop,
+		})
+
+		// A field is always present unless it may be omitted when empty,
+		// or it was promoted from an embedded pointer that might be nil.
+		if !strings.Contains(opts, ",omitempty") && !viaPointer {
+			schema.Required = append(schema.Required, jf.name)
+		}
+	}
+	return schema
+}
+
+// orderedJSONFields returns the fields that encoding/json would include
+// when encoding the given struct type, in the order it would encode them.
+func orderedJSONFields(st *types.Struct) []jsonField {
+	fields := jsonFields(st)
+	ordered := make([]jsonField, len(fields))
+	copy(ordered, fields)
+	for i := 1; i < len(ordered); i++ {
+		for j := i; j > 0 && indexLess(ordered[j].index, ordered[j-1].index); j-- {
+			ordered[j], ordered[j-1] = ordered[j-1], ordered[j]
+		}
+	}
+	return ordered
+}
+
+// fieldByIndex returns the field of the given struct type at the given
+// index sequence, as for reflect.Type.FieldByIndex, along with its json tag
+// and whether it was promoted through an embedded pointer.
+func fieldByIndex(st *types.Struct, index []int) (*types.Var, string, bool) {
+	viaPointer := false
+	var field *types.Var
+	var tag string
+	for i, idx := range index {
+		field, tag = st.Field(idx), reflect.StructTag(st.Tag(idx)).Get("json")
+		if i == len(index)-1 {
+			break
+		}
+		t := types.Unalias(field.Type())
+		if ptr, isPtr := t.(*types.Pointer); isPtr {
+			viaPointer = true
+			t = ptr.Elem()
+		}
+		st = t.Underlying().(*types.Struct)
+	}
+	return field, tag, viaPointer
+}
+
+// specialSchema returns a schema for a type that encodes itself, or nil if
+// the given type doesn't.
+func specialSchema(t types.Type) *jsonSchema {
+	named, isNamed := types.Unalias(t).(*types.Named)
+	if !isNamed {
+		return nil
+	}
+	if isTime(named) {
+		return &jsonSchema{
+			Type:   "string",
+			Format: "date-time",
+		}
+	}
+	switch {
+	case hasMethod(named, "MarshalJSON"):
+		return &jsonSchema{}
+	case hasMethod(named, "MarshalText"):
+		return &jsonSchema{Type: "string"}
+	}
+	return nil
+}
+
+// hasMethod returns true if the given type or a pointer to it has a method
+// with the given name.
+func hasMethod(t types.Type, name string) bool {
+	obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(t), true, nil, name)
+	_, isFunc := obj.(*types.Func)
+	return isFunc
+}
+
+func basicSchema(b *types.Basic) *jsonSchema {
+	info := b.Info()
+	switch {
+	case info&types.IsBoolean != 0:
+		return &jsonSchema{Type: "boolean"}
+	case info&types.IsInteger != 0:
+		return &jsonSchema{Type: "integer"}
+	case info&types.IsFloat != 0:
+		return &jsonSchema{Type: "number"}
+	case info&types.IsString != 0:
+		return &jsonSchema{Type: "string"}
+	default:
+		return &jsonSchema{}
+	}
+}
+
+// nullableSchema returns a schema that allows null as well as anything the
+// given schema allows.
+func nullableSchema(schema *jsonSchema) *jsonSchema {
+	return &jsonSchema{
+		AnyOf: []*jsonSchema{
+			schema,
+			{Type: "null"},
+		},
+	}
+}
+
+// isStringable returns true if the ",string" json option applies to values
+// of the given type, which it does for strings, numbers and booleans.
+func isStringable(t types.Type) bool {
+	b, isBasic := t.Underlying().(*types.Basic)
+	return isBasic && b.Info()&(types.IsBoolean|types.IsNumeric|types.IsString) != 0 && b.Info()&types.IsComplex == 0
+}
+
+func isByte(t types.Type) bool {
+	b, isBasic := t.Underlying().(*types.Basic)
+	return isBasic && b.Kind() == types.Byte && !hasMethod(t, "MarshalJSON") && !hasMethod(t, "MarshalText")
+}
+
+// constantJSON returns the given constant value as it would be encoded by
+// encoding/json.
+func constantJSON(v constant.Value) interface{} {
+	switch v.Kind() {
+	case constant.Bool:
+		return constant.BoolVal(v)
+	case constant.String:
+		return constant.StringVal(v)
+	case constant.Int:
+		return json.Number(v.ExactString())
+	case constant.Float:
+		f, _ := constant.Float64Val(v)
+		return f
+	default:
+		return v.ExactString()
+	}
+}
@@ -0,0 +1,72 @@
+package pilfer
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONSchema(t *testing.T) {
+	prog := loadTestdata(t, "example.com/enums")
+	roots := []Root{{Package: "example.com/enums", Type: "Config"}}
+
+	var buf bytes.Buffer
+	if err := prog.JSONSchema(roots, &buf, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "jsonschema", buf.Bytes())
+
+	var schema struct {
+		Defs map[string]struct {
+			Type        interface{}   `json:"type"`
+			Description string        `json:"description"`
+			Enum        []interface{} `json:"enum"`
+			Properties  map[string]struct {
+				Type interface{} `json:"type"`
+			} `json:"properties"`
+			Required []string `json:"required"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crimson has the same value as Red, which is listed once.
+	if got, want := schema.Defs["Color"].Enum, []interface{}{"red", "green", "blue"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong Color enum %v; want %v", got, want)
+	}
+
+	// Bit flags can be combined, so they are described rather than listed,
+	// but two single bits are an ordinary enumeration.
+	perm := schema.Defs["Perm"]
+	if perm.Enum != nil || !strings.Contains(perm.Description, "PermWrite (2)") {
+		t.Errorf("Perm has enum %v and description %q; want no enum and a description of its flags", perm.Enum, perm.Description)
+	}
+	if got, want := schema.Defs["Mode"].Enum, []interface{}{1.0, 2.0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong Mode enum %v; want %v", got, want)
+	}
+
+	props := schema.Defs["Config"].Properties
+	for name, want := range map[string]interface{}{
+		// Nil slices and maps are encoded as null.
+		"palette": []interface{}{"array", "null"},
+		"Labels":  []interface{}{"object", "null"},
+
+		// Fields that omitempty can leave out are never null, and nor are
+		// arrays.
+		"tags":      "array",
+		"named":     "object",
+		"overrides": "array",
+	} {
+		if got := props[name].Type; !reflect.DeepEqual(got, want) {
+			t.Errorf("property %s has type %v; want %v", name, got, want)
+		}
+	}
+
+	required := strings.Join(schema.Defs["Config"].Required, ",")
+	if !strings.Contains(required, "color") || strings.Contains(required, "fallback") {
+		t.Errorf("wrong required properties %s; want color but not fallback", required)
+	}
+}
@@ -0,0 +1,411 @@
+package pilfer
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Layout decides how the generated declarations are divided between files
+// and packages.
+type Layout int
+
+const (
+	// SingleFile puts all of the generated declarations in one file.
+	SingleFile Layout = iota
+
+	// FilePerPackage puts the declarations copied from each source package
+	// in a separate file, all in the same package.
+	FilePerPackage
+
+	// PackagePerPackage puts the declarations copied from each source
+	// package in a separate package, in a directory structure that mirrors
+	// the source package paths. References between them become imports,
+	// and names need only be unique within each package.
+	PackagePerPackage
+)
+
+// OutputFile is a generated source file, as returned by PilferFiles.
+type OutputFile struct {
+	// Path is the location of the file relative to the output directory,
+	// using slashes as separators. It is empty for the SingleFile layout,
+	// whose location is chosen by the caller.
+	Path string
+
+	// PackageName is the name used in the file's package clause.
+	PackageName string
+
+	// Source is the content of the file.
+	Source []byte
+}
+
+// genState tracks the output package whose declarations are being
+// generated, and the imports needed to refer to the others.
+type genState struct {
+	// pkgPath is the path of the source package whose declarations are
+	// being generated, or empty if they are all in the same package.
+	pkgPath string
+
+	// importBase is the import path of the output directory, and pkgs has
+	// the source packages whose declarations are in other output packages.
+	importBase string
+	pkgs       map[string]*types.Package
+
+	// dirs maps the path of each source package in pkgs to the directory
+	// of its output package, relative to the output directory.
+	dirs map[string]string
+
+	// imports maps the import paths of the output packages referred to by
+	// the current one to their local names.
+	imports map[string]string
+
+	// funcs has the keys, as returned by nameKey, of the names given to
+	// the functions generated alongside the copied types.
+	funcs map[string]bool
+}
+
+// refExpr returns an expression that refers to the new declaration of the
+// given type from the output package currently being generated.
+func (t typeTable) refExpr(ty *takeType) ast.Expr {
+	if ty.Kept {
+		pkg := ty.Name.Pkg()
+		return &ast.SelectorExpr{
+			X:   ast.NewIdent(t.gen.addImport(pkg.Path(), pkg.Name())),
+			Sel: ast.NewIdent(ty.NewName),
+		}
+	}
+	pkgPath := ty.OutputPkgPath()
+	if !t.scoped || pkgPath == t.gen.pkgPath {
+		return &ast.Ident{
+			Name: ty.NewName,
+		}
+	}
+	return &ast.SelectorExpr{
+		X:   ast.NewIdent(t.gen.importName(pkgPath)),
+		Sel: ast.NewIdent(ty.NewName),
+	}
+}
+
+// exportSharedTypes renames each unexported type that is referred to from
+// a different output package, so that it can be referred to from there.
+func (t typeTable) exportSharedTypes() {
+	keys := t.NewNames()
+	tys := make([]*takeType, len(keys))
+	for i, key := range keys {
+		tys[i] = t.TypeByNewName(key)
+	}
+	for _, ty := range tys {
+		for _, edge := range t.Edges(ty) {
+			to := edge.To
+			pkgPath := to.OutputPkgPath()
+			if to.Reused != "" || ast.IsExported(to.NewName) || pkgPath == edge.From.OutputPkgPath() {
+				continue
+			}
+
+			base := exportedName(to.NewName)
+			if !ast.IsExported(base) {
+				base = "X" + base
+			}
+			newName := base
+			for n := 1; t.NewNameTaken(t.nameKey(pkgPath, newName), to.QualifiedName()); n++ {
+				newName = fmt.Sprintf("%s_%d", base, n)
+			}
+			delete(t.newNames, t.nameKey(pkgPath, to.NewName))
+			to.NewName = newName
+			t.newNames[t.nameKey(pkgPath, newName)] = to
+		}
+	}
+}
+
+// importName returns the local name for the output package corresponding
+// to the given source package, adding it to the current imports if needed.
+func (g *genState) importName(pkgPath string) string {
+	return g.addImport(path.Join(g.importBase, g.dirs[pkgPath]), mirrorPackageName(g.pkgs[pkgPath]))
+}
+
+// stdImport returns the local name for the given standard library package,
+// adding it to the current imports if needed.
+func (g *genState) stdImport(importPath string) string {
+	return g.addImport(importPath, path.Base(importPath))
+}
+
+// addImport returns the local name for the given import path, adding it to
+// the current imports under the given name, or a variation of it that isn't
+// already taken, if needed.
+func (g *genState) addImport(importPath, base string) string {
+	if name, exists := g.imports[importPath]; exists {
+		return name
+	}
+
+	name := base
+	for n := 1; g.importNameTaken(name); n++ {
+		name = fmt.Sprintf("%s%d", base, n)
+	}
+	g.imports[importPath] = name
+	return name
+}
+
+func (g *genState) importNameTaken(name string) bool {
+	for _, taken := range g.imports {
+		if taken == name {
+			return true
+		}
+	}
+	// Nothing may be imported under the name of the current package.
+	if pkg := g.pkgs[g.pkgPath]; pkg != nil {
+		return name == mirrorPackageName(pkg)
+	}
+	return false
+}
+
+// mirrorDirs returns the directories, relative to the output directory, of
+// the output packages for the given source packages, keyed by their paths.
+//
+// The directory of a package with an import path mirrors that path. A
+// package loaded from a directory outside of GOPATH has only the absolute
+// path of that directory, so it is given the directory's base name
+// instead, with a numeric suffix if another package already has that.
+func mirrorDirs(pkgs map[string]*types.Package) map[string]string {
+	dirs := make(map[string]string, len(pkgs))
+	used := map[string]bool{}
+	var local []string
+	for pkgPath := range pkgs {
+		if isLocalPackagePath(pkgPath) {
+			local = append(local, pkgPath)
+			continue
+		}
+		dirs[pkgPath] = pkgPath
+		used[pkgPath] = true
+	}
+	sort.Strings(local)
+	for _, pkgPath := range local {
+		base := path.Base(pkgPath)
+		dir := base
+		for n := 1; used[dir]; n++ {
+			dir = fmt.Sprintf("%s_%d", base, n)
+		}
+		dirs[pkgPath] = dir
+		used[dir] = true
+	}
+	return dirs
+}
+
+// mirrorPackageName returns the name of the output package for the given
+// source package, which is the same as the source package's name unless
+// that is "main", which can't be imported.
+func mirrorPackageName(pkg *types.Package) string {
+	if pkg.Name() != "main" {
+		return pkg.Name()
+	}
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+		return '_'
+	}, path.Base(pkg.Path()))
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "_" + name
+	}
+	return name
+}
+
+// PilferFiles is like Pilfer, but divides the generated declarations
+// between files according to the layout chosen in the given options.
+//
+// For the PackagePerPackage layout, opts.ImportPath must be the import
+// path of the output directory, and opts.PackageName is not used.
+func (p *Program) PilferFiles(roots []Root, opts Options) ([]OutputFile, error) {
+	if opts.Layout == PackagePerPackage && opts.ImportPath == "" {
+		return nil, fmt.Errorf("the import path of the output directory is required to generate a package per source package")
+	}
+
+	rootTypes, table, err := p.collect(roots, opts)
+	if err != nil {
+		return nil, err
+	}
+	consts := findInterestingConsts(p.prog, table)
+
+	// Every layout is driven by the same set of types, differing only in
+	// how they are grouped.
+	groups := map[string][]string{}
+	if opts.Layout == SingleFile {
+		// There is always a single file, even if it's empty because every
+		// type was reused.
+		groups[""] = nil
+	}
+	pkgs := map[string]*types.Package{}
+	for _, key := range table.NewNames() {
+		ty := table.TypeByNewName(key)
+		if ty.Reused != "" {
+			continue
+		}
+		pkgPath := ty.OutputPkgPath()
+		if ty.Instance == nil || ty.Parent == nil {
+			pkgs[pkgPath] = ty.Name.Pkg()
+		}
+		if opts.Layout == SingleFile {
+			pkgPath = ""
+		}
+		groups[pkgPath] = append(groups[pkgPath], key)
+	}
+	pkgPaths := make([]string, 0, len(groups))
+	for pkgPath := range groups {
+		pkgPaths = append(pkgPaths, pkgPath)
+	}
+	sort.Strings(pkgPaths)
+
+	table.gen.importBase = opts.ImportPath
+	table.gen.pkgs = pkgs
+	table.gen.dirs = mirrorDirs(pkgs)
+	table.gen.funcs = map[string]bool{}
+
+	usedPaths := map[string]bool{}
+	ret := make([]OutputFile, 0, len(pkgPaths))
+	for _, pkgPath := range pkgPaths {
+		file := OutputFile{
+			PackageName: opts.PackageName,
+		}
+		sourcePkgs := []string{pkgPath}
+		switch opts.Layout {
+		case SingleFile:
+			sourcePkgs = make([]string, 0, len(pkgs))
+			for pkgPath := range pkgs {
+				sourcePkgs = append(sourcePkgs, pkgPath)
+			}
+			sort.Strings(sourcePkgs)
+		case FilePerPackage:
+			file.Path = uniquePath(path.Base(table.gen.dirs[pkgPath]), usedPaths)
+		case PackagePerPackage:
+			dir := table.gen.dirs[pkgPath]
+			file.PackageName = mirrorPackageName(pkgs[pkgPath])
+			file.Path = path.Join(dir, path.Base(dir)+".go")
+		}
+
+		table.gen.pkgPath = pkgPath
+		table.gen.imports = map[string]string{}
+		decls := p.writeDecls(groups[pkgPath], table, consts, opts)
+
+		var buf bytes.Buffer
+		writeHeader(&buf, p.prog, rootTypes, sourcePkgs, opts)
+		fmt.Fprintf(&buf, "package %s\n\n", file.PackageName)
+		writeImports(&buf, table.gen.imports)
+		buf.Write(decls)
+
+		file.Source, err = format.Source(buf.Bytes())
+		if err != nil {
+			where := file.Path
+			if where == "" {
+				where = "package " + file.PackageName
+			}
+			return nil, fmt.Errorf("generated invalid source for %s: %s", where, err)
+		}
+		ret = append(ret, file)
+	}
+	return ret, nil
+}
+
+// writeDecls returns the declarations of the types with the given new name
+// keys, along with their constants and any methods selected in the given
+// options.
+func (p *Program) writeDecls(keys []string, types typeTable, consts constantTable, opts Options) []byte {
+	prog := p.prog
+	constNamesByType := consts.NewNamesByTypeName()
+
+	buf := bytes.Buffer{}
+	methods := newMethodWriter(types, consts, &buf)
+	for _, key := range keys {
+		ty := types.TypeByNewName(key)
+		pkgPath := ty.Name.Pkg().Path()
+		info := prog.Package(pkgPath)
+
+		var wrap *ast.GenDecl
+		if ty.Instance != nil {
+			// Monomorphized instantiations have no syntax of their own,
+			// so we build their declarations from the instantiated type.
+			wrap = &ast.GenDecl{
+				Tok: token.TYPE,
+				Specs: []ast.Spec{
+					instanceSpec(ty, types),
+				},
+			}
+		} else {
+			// We rewrite a copy of the declaration so that the program's
+			// own syntax trees remain intact for subsequent calls.
+			spec := cloneAST(ty.Spec).(*ast.TypeSpec)
+			if ty.Flattened != nil {
+				spec.Type = flattenedStructType(ty.Flattened, types)
+			}
+			wrap = &ast.GenDecl{
+				Tok: token.TYPE,
+				Specs: []ast.Spec{
+					spec,
+				},
+			}
+			rewriteTypeIdents(wrap, info, types)
+		}
+		if opts.StructTags != nil {
+			filterStructTags(wrap, opts.StructTags)
+		}
+		format.Node(&buf, prog.Fset, wrap)
+		buf.WriteString("\n\n")
+
+		constNames := constNamesByType[key]
+		tyConsts := make([]*takeConstant, len(constNames))
+		if len(constNames) > 0 {
+			buf.WriteString("const (\n")
+			for i, constName := range constNames {
+				cn := consts.ConstantByNewName(constName)
+				fmt.Fprintf(&buf, "\t%s %s = %s\n", cn.NewName, ty.NewName, cn.Value.ExactString())
+				tyConsts[i] = cn
+			}
+			buf.WriteString(")\n")
+		}
+		methods.WriteMethods(ty, tyConsts, opts)
+	}
+	return buf.Bytes()
+}
+
+// writeImports writes an import declaration for the given import paths,
+// which map to their local names, if there are any.
+func writeImports(buf *bytes.Buffer, imports map[string]string) {
+	if len(imports) == 0 {
+		return
+	}
+	importPaths := make([]string, 0, len(imports))
+	for importPath := range imports {
+		importPaths = append(importPaths, importPath)
+	}
+	sort.Strings(importPaths)
+
+	specs := make([]string, len(importPaths))
+	for i, importPath := range importPaths {
+		specs[i] = strconv.Quote(importPath)
+		if name := imports[importPath]; name != path.Base(importPath) {
+			specs[i] = name + " " + specs[i]
+		}
+	}
+	if len(specs) == 1 {
+		fmt.Fprintf(buf, "import %s\n\n", specs[0])
+		return
+	}
+	fmt.Fprintf(buf, "import (\n\t%s\n)\n\n", strings.Join(specs, "\n\t"))
+}
+
+// uniquePath returns a filename based on the given name that isn't already
+// in the given set, and adds it to the set.
+func uniquePath(name string, used map[string]bool) string {
+	filename := name + ".go"
+	for n := 1; used[filename]; n++ {
+		filename = fmt.Sprintf("%s_%d.go", name, n)
+	}
+	used[filename] = true
+	return filename
+}
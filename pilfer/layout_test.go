@@ -0,0 +1,29 @@
+package pilfer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPackagePerPackageLocalSource(t *testing.T) {
+	prog := loadTestdata(t, "./testdata/local/app")
+	files, err := prog.PilferFiles([]Root{{Package: "./testdata/local/app", Type: "Config"}}, Options{
+		Layout:     PackagePerPackage,
+		ImportPath: "example.com/out",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The package loaded from a directory has no import path to mirror,
+	// so its output package is named for the directory.
+	var paths []string
+	for _, file := range files {
+		paths = append(paths, file.Path)
+		checkGolden(t, "layout_"+strings.Replace(strings.TrimSuffix(file.Path, ".go"), "/", "_", -1), file.Source)
+	}
+	want := "app/app.go example.com/flat/meta/meta.go"
+	if got := strings.Join(paths, " "); got != want {
+		t.Errorf("wrong paths %q, want %q", got, want)
+	}
+}
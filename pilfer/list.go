@@ -0,0 +1,111 @@
+package pilfer
+
+import (
+	"fmt"
+)
+
+// ListEntry describes a single declaration that Pilfer would copy, as
+// returned by List.
+type ListEntry struct {
+	// Kind is either "type" or "const".
+	Kind string `json:"kind"`
+
+	// Original is the package path and name of the declaration in the
+	// source program, separated by a period.
+	Original string `json:"original"`
+
+	// Position is the location of the original declaration, as a
+	// filename, line and column.
+	Position string `json:"position"`
+
+	// NewName is the name the declaration will have in the generated file.
+	NewName string `json:"newName"`
+
+	// Conflict is the package path and name of the declaration that was
+	// already using the original name, if the declaration had to be renamed
+	// to avoid a collision. It is empty if no renaming was needed.
+	Conflict string `json:"conflict,omitempty"`
+
+	// Locked is true if the declaration was renamed only because a name
+	// lock records the new name from an earlier run.
+	Locked bool `json:"locked,omitempty"`
+
+	// Renamed is true if the declaration was given the name chosen for it
+	// by Options.Rename.
+	Renamed bool `json:"renamed,omitempty"`
+
+	// Reused is true if the declaration won't be copied because an
+	// existing type in the destination package is used in its place, in
+	// which case NewName is the name of that existing type.
+	Reused bool `json:"reused,omitempty"`
+}
+
+// Resolution returns a short description of how a naming collision was
+// resolved for the receiving entry, or an empty string if there was no
+// collision. Names kept from a name lock are described as such, since the
+// collision that caused them may no longer exist, as are names chosen by
+// Options.Rename.
+//
+// For an entry that is reused rather than copied, the result instead
+// describes the existing declaration it refers to.
+func (e ListEntry) Resolution() string {
+	if e.Reused {
+		return fmt.Sprintf("reuses existing %s", e.NewName)
+	}
+	if e.Renamed {
+		return fmt.Sprintf("renamed to %s as requested", e.NewName)
+	}
+	if e.Locked {
+		return fmt.Sprintf("renamed to %s by the name lock", e.NewName)
+	}
+	if e.Conflict == "" {
+		return ""
+	}
+	return fmt.Sprintf("renamed to %s because %s uses the original name", e.NewName, e.Conflict)
+}
+
+// List returns a description of each of the types and constants that Pilfer
+// would copy for the given root types, without generating any code.
+//
+// The entries are in the same order that their declarations would appear
+// in the generated file.
+func (p *Program) List(roots []Root, opts Options) ([]ListEntry, error) {
+	prog := p.prog
+	_, types, err := p.collect(roots, opts)
+	if err != nil {
+		return nil, err
+	}
+	consts := findInterestingConsts(prog, types)
+	constNamesByType := consts.NewNamesByTypeName()
+
+	var ret []ListEntry
+	for _, newName := range types.NewNames() {
+		ty := types.TypeByNewName(newName)
+		entry := ListEntry{
+			Kind:     "type",
+			Original: ty.QualifiedName(),
+			Position: prog.Fset.Position(ty.Ident.Pos()).String(),
+			NewName:  ty.NewName,
+			Conflict: ty.ConflictName(),
+			Locked:   ty.Locked,
+			Renamed:  ty.Renamed,
+			Reused:   ty.Reused != "",
+		}
+		ret = append(ret, entry)
+
+		for _, constName := range constNamesByType[newName] {
+			cn := consts.ConstantByNewName(constName)
+			ret = append(ret, ListEntry{
+				Kind:     "const",
+				Original: cn.QualifiedName(),
+				Position: prog.Fset.Position(cn.Name.Pos()).String(),
+				NewName:  cn.NewName,
+				Conflict: cn.Conflict,
+				Locked:   cn.Locked,
+				Renamed:  cn.Renamed,
+			})
+		}
+	}
+
+	return ret, nil
+}
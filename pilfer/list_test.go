@@ -0,0 +1,77 @@
+package pilfer
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	prog := loadTestdata(t, "example.com/lock/a")
+	roots := []Root{{Package: "example.com/lock/a", Type: "Config"}}
+
+	type entry struct {
+		Kind, NewName, Original, Resolution string
+	}
+	list := func(t *testing.T, opts Options) []entry {
+		t.Helper()
+		entries, err := prog.List(roots, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ret []entry
+		for _, e := range entries {
+			ret = append(ret, entry{e.Kind, e.NewName, e.Original, e.Resolution()})
+		}
+		return ret
+	}
+
+	t.Run("conflicts", func(t *testing.T) {
+		// The entries are in the order of the generated declarations, with
+		// each type's constants after it.
+		want := []entry{
+			{"type", "Config", "example.com/lock/a.Config", ""},
+			{"type", "Config_1", "example.com/lock/b.Config", "renamed to Config_1 because example.com/lock/a.Config uses the original name"},
+			{"type", "Kind", "example.com/lock/a.Kind", ""},
+			{"const", "KindLocal", "example.com/lock/a.KindLocal", ""},
+			{"type", "Kind_1", "example.com/lock/b.Kind", "renamed to Kind_1 because example.com/lock/a.Kind uses the original name"},
+			{"const", "KindLocal_1", "example.com/lock/b.KindLocal", "renamed to KindLocal_1 because example.com/lock/a.KindLocal uses the original name"},
+			{"const", "KindRemote", "example.com/lock/b.KindRemote", ""},
+			{"type", "Status", "example.com/lock/a.Status", ""},
+		}
+		if got := list(t, Options{}); !reflect.DeepEqual(got, want) {
+			t.Errorf("wrong entries\ngot:  %v\nwant: %v", got, want)
+		}
+	})
+
+	t.Run("renamed and locked", func(t *testing.T) {
+		opts := Options{
+			Rename: map[string]string{"example.com/lock/b.Config": "Remote"},
+			Lock: &NameLock{
+				Names: map[string]string{"example.com/lock/a.Status": "OldStatus"},
+			},
+		}
+		got := map[string]string{}
+		for _, e := range list(t, opts) {
+			got[e.Original] = e.Resolution
+		}
+		for original, want := range map[string]string{
+			"example.com/lock/b.Config": "renamed to Remote as requested",
+			"example.com/lock/a.Status": "renamed to OldStatus by the name lock",
+			"example.com/lock/a.Config": "",
+		} {
+			if got[original] != want {
+				t.Errorf("%s has resolution %q; want %q", original, got[original], want)
+			}
+		}
+	})
+
+	entries, err := prog.List(roots, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos := filepath.ToSlash(entries[0].Position); !strings.HasSuffix(pos, "example.com/lock/a/a.go:5:6") {
+		t.Errorf("wrong position %s for %s", pos, entries[0].Original)
+	}
+}
@@ -0,0 +1,136 @@
+package pilfer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// NameLock records the new names assigned to copied declarations, so that
+// they can stay the same when the output is regenerated after the source
+// packages have changed.
+//
+// Without a lock, the numeric suffixes used to resolve naming collisions
+// depend on the order in which declarations are found, so adding a new
+// type to a source package can change the names of existing copies.
+type NameLock struct {
+	// Names maps the package path and name of each source type or constant,
+	// like "example.com/foo.Config", to the name of its copy.
+	Names map[string]string `json:"names"`
+}
+
+// ReadNameLock reads a name lock in the JSON format written by Write.
+func ReadNameLock(r io.Reader) (*NameLock, error) {
+	var lock NameLock
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&lock); err != nil {
+		return nil, fmt.Errorf("invalid name lock: %s", err)
+	}
+	if lock.Names == nil {
+		lock.Names = make(map[string]string)
+	}
+	return &lock, nil
+}
+
+// Write writes the receiver to the given writer as JSON, with the names in
+// sorted order so that the result is stable.
+func (l *NameLock) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(l)
+}
+
+// Name returns the name that the lock assigns to the declaration with the
+// given package path and name, if any. It is safe to call on a nil
+// NameLock, which assigns no names.
+func (l *NameLock) Name(qualifiedName string) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	name, has := l.Names[qualifiedName]
+	return name, has
+}
+
+// NameLock returns a lock recording the names that Pilfer assigns to the
+// declarations it would copy for the given root types. Types that are
+// reused rather than copied are not included.
+//
+// Any names already recorded in opts.Lock are kept, so the result is
+// suitable for replacing that lock after generating code with the same
+// options.
+func (p *Program) NameLock(roots []Root, opts Options) (*NameLock, error) {
+	entries, err := p.List(roots, opts)
+	if err != nil {
+		return nil, err
+	}
+	lock := &NameLock{
+		Names: make(map[string]string, len(entries)),
+	}
+	for _, entry := range entries {
+		if entry.Reused {
+			continue
+		}
+		lock.Names[entry.Original] = entry.NewName
+	}
+	return lock, nil
+}
+
+// lockedPkgPath returns the package path from the given key of a name lock,
+// which is the qualified name of either a declaration or an instantiation
+// of a generic type.
+func lockedPkgPath(qualifiedName string) string {
+	if bracket := strings.Index(qualifiedName, "["); bracket != -1 {
+		qualifiedName = qualifiedName[:bracket]
+	}
+	if dot := strings.LastIndex(qualifiedName, "."); dot != -1 {
+		return qualifiedName[:dot]
+	}
+	return ""
+}
+
+// FieldNumberLock records the field numbers assigned to the fields of the
+// messages that Proto generates, along with the numbers assigned to enum
+// values that aren't integers, so that they can stay the same when the
+// schema is regenerated and data encoded with it remains readable.
+type FieldNumberLock struct {
+	// Types maps the package path and name of each source type, like
+	// "example.com/foo.Config", to a map from the names of the fields or
+	// enum values generated for it to their numbers. Fields that have been
+	// removed stay in the lock so that their numbers are never reused.
+	Types map[string]map[string]int `json:"types"`
+}
+
+// ReadFieldNumberLock reads a field number lock in the JSON format written
+// by Write.
+func ReadFieldNumberLock(r io.Reader) (*FieldNumberLock, error) {
+	var lock FieldNumberLock
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&lock); err != nil {
+		return nil, fmt.Errorf("invalid field number lock: %s", err)
+	}
+	if lock.Types == nil {
+		lock.Types = make(map[string]map[string]int)
+	}
+	return &lock, nil
+}
+
+// Write writes the receiver to the given writer as JSON, with the names in
+// sorted order so that the result is stable.
+func (l *FieldNumberLock) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(l)
+}
+
+// Numbers returns the numbers that the lock assigns to the members of the
+// type with the given package path and name. It is safe to call on a nil
+// FieldNumberLock, which assigns no numbers.
+func (l *FieldNumberLock) Numbers(qualifiedName string) map[string]int {
+	if l == nil {
+		return nil
+	}
+	return l.Types[qualifiedName]
+}
@@ -0,0 +1,41 @@
+package pilfer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNameLock(t *testing.T) {
+	prog := loadTestdata(t, "example.com/lock/a")
+	roots := []Root{{Package: "example.com/lock/a", Type: "Config"}}
+
+	// Without a lock, the copies found later are the ones renamed.
+	checkGolden(t, "lock_none", pilferTestdata(t, prog, roots, Options{}))
+
+	// The lock keeps the names from an earlier run, where the types from
+	// package b were found first, and reserves the name of a type that has
+	// since been removed.
+	lock := &NameLock{
+		Names: map[string]string{
+			"example.com/lock/a.Kind":       "Kind_1",
+			"example.com/lock/a.KindLocal":  "KindLocal_1",
+			"example.com/lock/b.Config":     "BConfig",
+			"example.com/lock/b.Kind":       "Kind",
+			"example.com/lock/b.KindLocal":  "KindLocal",
+			"example.com/lock/b.KindRemote": "KindRemote",
+			"example.com/lock/b.Status":     "Status",
+		},
+	}
+	opts := Options{Lock: lock}
+	checkGolden(t, "lock_names", pilferTestdata(t, prog, roots, opts))
+
+	updated, err := prog.NameLock(roots, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := updated.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "lock_names_lock", buf.Bytes())
+}
@@ -0,0 +1,508 @@
+package pilfer
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// MergeBeginMarker and MergeEndMarker are the comment lines that delimit
+// the declarations Pilfer owns in a file that also contains hand-written
+// code, as produced by Merge.
+const (
+	MergeBeginMarker = "// BEGIN go-pilfer generated declarations; DO NOT EDIT."
+	MergeEndMarker   = "// END go-pilfer generated declarations."
+)
+
+// Merge combines a file generated by Pilfer with the existing content of
+// the file it is to replace, so that hand-written declarations in the
+// existing file are preserved.
+//
+// The generated declarations are placed between MergeBeginMarker and
+// MergeEndMarker, along with the description of where they came from, while
+// the packages they import are added to the existing file's imports, whose
+// unused entries are removed. If the existing file already contains those
+// markers then only the declarations between them are replaced. If it is
+// empty then the result contains only the generated declarations.
+// Otherwise, the generated declarations are appended to it.
+//
+// An existing file that was generated by Pilfer without the markers may
+// still have had declarations added by hand. Of its declarations, only
+// those that the generated declarations declare again are replaced, along
+// with its generated code header. The others are kept.
+//
+// The result is also returned along with a description of any conflicts
+// between the hand-written and generated code: methods whose receiver type
+// is no longer declared in the destination package, declarations whose
+// names are also used by generated declarations, and declarations that
+// were kept from a generated file without markers, which may be generated
+// declarations that are no longer needed. The given destination describes
+// the other files in the package, and may be nil.
+func Merge(existing, generated []byte, dest *Destination) ([]byte, []string, error) {
+	header, imports, body, err := splitGenerated(generated)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var kept map[string]bool
+	if _, _, found := findMergeRegion(existing); !found && IsGenerated(existing) {
+		existing, kept, err = withoutGenerated(existing, generated)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var region bytes.Buffer
+	region.WriteString(MergeBeginMarker + "\n")
+	if len(header) > 0 {
+		region.WriteString("//\n")
+		region.Write(header)
+	}
+	region.WriteString("\n")
+	region.Write(bytes.TrimSpace(body))
+	region.WriteString("\n\n" + MergeEndMarker + "\n")
+
+	var merged []byte
+	begin, end, found := findMergeRegion(existing)
+	switch {
+	case found:
+		merged = append(merged, existing[:begin]...)
+		merged = append(merged, region.Bytes()...)
+		merged = append(merged, existing[end:]...)
+	case len(bytes.TrimSpace(existing)) == 0:
+		merged = append(merged, packageClause(generated)...)
+		merged = append(merged, "\n\n"...)
+		merged = append(merged, region.Bytes()...)
+	default:
+		merged = append(merged, bytes.TrimRight(existing, "\n")...)
+		merged = append(merged, "\n\n"...)
+		merged = append(merged, region.Bytes()...)
+	}
+
+	merged, err = mergeImports(merged, imports)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to merge with existing file: %s", err)
+	}
+	fmted, err := format.Source(merged)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to merge with existing file: %s", err)
+	}
+	conflicts, err := mergeConflicts(fmted, dest, kept)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fmted, conflicts, nil
+}
+
+// splitGenerated splits a file generated by Pilfer into the lines of its
+// header that describe where its declarations came from, the packages it
+// imports, and the declarations themselves.
+func splitGenerated(src []byte) ([]byte, []*ast.ImportSpec, []byte, error) {
+	body := generatedBody(src)
+	var header bytes.Buffer
+	for _, line := range strings.SplitAfter(string(src[:len(src)-len(body)]), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "//" || trimmed == GeneratedHeader {
+			continue
+		}
+		header.WriteString(line)
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", body, 0)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse generated file: %s", err)
+	}
+	// Imports always come first, so the declarations follow the last one.
+	start := fset.Position(file.Name.End()).Offset
+	for _, decl := range file.Decls {
+		if gd, isGen := decl.(*ast.GenDecl); isGen && gd.Tok == token.IMPORT {
+			start = fset.Position(gd.End()).Offset
+		}
+	}
+	return header.Bytes(), file.Imports, body[start:], nil
+}
+
+// mergeImports adds the given imports of the generated declarations to the
+// imports of the given merged file, and removes the imports that it no
+// longer uses, such as those of declarations that were generated before.
+func mergeImports(src []byte, imports []*ast.ImportSpec) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, imp := range imports {
+		importPath, _ := strconv.Unquote(imp.Path.Value)
+		for _, existing := range file.Imports {
+			if existingPath, _ := strconv.Unquote(existing.Path.Value); existingPath == importPath && importName(existing) != importName(imp) {
+				return nil, fmt.Errorf("the existing file imports %q as %s, but the generated declarations refer to it as %s", importPath, importName(existing), importName(imp))
+			}
+		}
+		astutil.AddNamedImport(fset, file, importSpecName(imp), importPath)
+	}
+
+	used := map[string]bool{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		if sel, isSel := n.(*ast.SelectorExpr); isSel {
+			if id, isIdent := sel.X.(*ast.Ident); isIdent && id.Obj == nil {
+				used[id.Name] = true
+			}
+		}
+		return true
+	})
+	for _, imp := range append([]*ast.ImportSpec(nil), file.Imports...) {
+		name := importName(imp)
+		if name == "_" || name == "." || !token.IsIdentifier(name) || used[name] {
+			continue
+		}
+		importPath, _ := strconv.Unquote(imp.Path.Value)
+		if importPath == "C" {
+			continue
+		}
+		astutil.DeleteNamedImport(fset, file, importSpecName(imp), importPath)
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, file); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// importName returns the name that the given import declares, assuming
+// that a package without an explicit name is named for the last element of
+// its path, as Pilfer's generated imports are.
+func importName(imp *ast.ImportSpec) string {
+	if imp.Name != nil {
+		return imp.Name.Name
+	}
+	importPath, _ := strconv.Unquote(imp.Path.Value)
+	return path.Base(importPath)
+}
+
+// importSpecName returns the explicit name of the given import, or an empty
+// string if it has none.
+func importSpecName(imp *ast.ImportSpec) string {
+	if imp.Name == nil {
+		return ""
+	}
+	return imp.Name.Name
+}
+
+// withoutGenerated returns the given file, which was generated by Pilfer
+// but has no merge markers, without its generated code header and without
+// the declarations that the given generated file declares again, which are
+// to replace them.
+//
+// It also returns the names of the remaining declarations other than
+// methods, which either were added by hand or were generated before but
+// are no longer needed. A declaration whose names are only partly declared
+// again is kept whole, so that the names in common are reported as
+// conflicts.
+func withoutGenerated(existing, generated []byte) ([]byte, map[string]bool, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", existing, parser.ParseComments)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse existing file: %s", err)
+	}
+	genFile, err := parser.ParseFile(token.NewFileSet(), "", generated, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse generated file: %s", err)
+	}
+	owned := map[string]bool{}
+	for _, decl := range genFile.Decls {
+		for _, key := range declKeys(decl) {
+			owned[key] = true
+		}
+	}
+
+	offset := func(pos token.Pos) int {
+		return fset.Position(pos).Offset
+	}
+	// Everything before the package clause is the generated code header,
+	// unless it is a package comment that was added below the header.
+	start := offset(file.Package)
+	if file.Doc != nil && !strings.Contains(file.Doc.Text(), generatedComment) {
+		start = offset(file.Doc.Pos())
+	}
+	var cuts [][2]int
+	cut := func(node ast.Node, doc *ast.CommentGroup) {
+		from := node.Pos()
+		if doc != nil {
+			from = doc.Pos()
+		}
+		cuts = append(cuts, [2]int{offset(from), offset(node.End())})
+	}
+
+	kept := map[string]bool{}
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if owned[declKeys(decl)[0]] {
+				cut(decl, decl.Doc)
+			} else if decl.Recv == nil {
+				kept[decl.Name.Name] = true
+			}
+		case *ast.GenDecl:
+			if decl.Tok == token.IMPORT {
+				continue
+			}
+			var ownedSpecs []ast.Spec
+			for _, spec := range decl.Specs {
+				names := specNames(spec)
+				all := len(names) > 0
+				for _, name := range names {
+					all = all && owned[name.Name]
+				}
+				if all {
+					ownedSpecs = append(ownedSpecs, spec)
+					continue
+				}
+				for _, name := range names {
+					kept[name.Name] = true
+				}
+			}
+			switch {
+			case len(ownedSpecs) == len(decl.Specs):
+				cut(decl, decl.Doc)
+			case decl.Lparen.IsValid():
+				for _, spec := range ownedSpecs {
+					cut(spec, specDoc(spec))
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	pos := start
+	for _, c := range cuts {
+		buf.Write(existing[pos:c[0]])
+		pos = c[1]
+	}
+	buf.Write(existing[pos:])
+	return buf.Bytes(), kept, nil
+}
+
+// declKeys returns keys identifying the declarations in the given top-level
+// declaration: the names of types, constants, variables and functions, and
+// the receiver type and name of methods, like "Config.String".
+func declKeys(decl ast.Decl) []string {
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		if decl.Recv == nil || len(decl.Recv.List) == 0 {
+			return []string{decl.Name.Name}
+		}
+		return []string{receiverTypeName(decl.Recv.List[0].Type) + "." + decl.Name.Name}
+	case *ast.GenDecl:
+		var keys []string
+		for _, spec := range decl.Specs {
+			for _, name := range specNames(spec) {
+				keys = append(keys, name.Name)
+			}
+		}
+		return keys
+	}
+	return nil
+}
+
+// specNames returns the names declared by the given type or value spec.
+func specNames(spec ast.Spec) []*ast.Ident {
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		return []*ast.Ident{spec.Name}
+	case *ast.ValueSpec:
+		return spec.Names
+	}
+	return nil
+}
+
+// specDoc returns the doc comment of the given type or value spec.
+func specDoc(spec ast.Spec) *ast.CommentGroup {
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		return spec.Doc
+	case *ast.ValueSpec:
+		return spec.Doc
+	}
+	return nil
+}
+
+// packageClause returns the package clause of the given source file.
+func packageClause(src []byte) []byte {
+	clause := generatedBody(src)
+	if nl := bytes.IndexByte(clause, '\n'); nl >= 0 {
+		clause = clause[:nl]
+	}
+	return clause
+}
+
+// findMergeRegion returns the offsets of the start of the line containing
+// MergeBeginMarker and of the end of the line containing MergeEndMarker in
+// the given source, if it contains both in that order.
+func findMergeRegion(src []byte) (int, int, bool) {
+	begin := lineIndex(src, MergeBeginMarker)
+	if begin < 0 {
+		return 0, 0, false
+	}
+	end := lineIndex(src[begin:], MergeEndMarker)
+	if end < 0 {
+		return 0, 0, false
+	}
+	end += begin + len(MergeEndMarker)
+	if end < len(src) && src[end] == '\n' {
+		end++
+	}
+	return begin, end, true
+}
+
+// lineIndex returns the offset of the first line in the given source that
+// consists of exactly the given text, or -1 if there is none.
+func lineIndex(src []byte, line string) int {
+	for offset := 0; offset < len(src); {
+		next := bytes.IndexByte(src[offset:], '\n')
+		lineEnd := len(src)
+		if next >= 0 {
+			lineEnd = offset + next
+		}
+		if string(bytes.TrimRight(src[offset:lineEnd], "\r")) == line {
+			return offset
+		}
+		if next < 0 {
+			break
+		}
+		offset = lineEnd + 1
+	}
+	return -1
+}
+
+// mergeRegionPos returns the positions of the markers delimiting the
+// generated declarations in the given parsed file, or false if it has no
+// such markers.
+func mergeRegionPos(file *ast.File) (token.Pos, token.Pos, bool) {
+	var begin, end token.Pos
+	for _, group := range file.Comments {
+		for _, c := range group.List {
+			switch c.Text {
+			case MergeBeginMarker:
+				if begin == token.NoPos {
+					begin = c.Pos()
+				}
+			case MergeEndMarker:
+				if begin != token.NoPos && end == token.NoPos {
+					end = c.End()
+				}
+			}
+		}
+	}
+	return begin, end, begin != token.NoPos && end != token.NoPos
+}
+
+// mergeConflicts describes any conflicts between the hand-written and the
+// generated declarations in the given merged file. The names in kept are
+// of declarations that were kept from a generated file without markers,
+// which are reported unless they conflict in some other way.
+func mergeConflicts(src []byte, dest *Destination, kept map[string]bool) ([]string, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse merged file: %s", err)
+	}
+	begin, end, _ := mergeRegionPos(file)
+	generated := func(node ast.Node) bool {
+		return node.Pos() > begin && node.End() < end
+	}
+
+	declared := map[string]bool{}
+	generatedNames := map[string]bool{}
+	var handWritten []*ast.Ident
+	for _, decl := range file.Decls {
+		var names []*ast.Ident
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					names = append(names, spec.Name)
+					declared[spec.Name.Name] = true
+				case *ast.ValueSpec:
+					names = append(names, spec.Names...)
+				}
+			}
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				names = append(names, decl.Name)
+			}
+		}
+		for _, name := range names {
+			if generated(decl) {
+				generatedNames[name.Name] = true
+			} else {
+				handWritten = append(handWritten, name)
+			}
+		}
+	}
+
+	var conflicts []string
+	for _, name := range handWritten {
+		switch {
+		case generatedNames[name.Name]:
+			conflicts = append(conflicts, fmt.Sprintf(
+				"line %d: %s is declared both by hand and by the generated code",
+				fset.Position(name.Pos()).Line, name.Name,
+			))
+		case kept[name.Name]:
+			conflicts = append(conflicts, fmt.Sprintf(
+				"line %d: %s is not generated any more, or was added by hand to a generated file; it has been kept, so remove it if it isn't needed",
+				fset.Position(name.Pos()).Line, name.Name,
+			))
+		}
+	}
+	for _, decl := range file.Decls {
+		fd, isFunc := decl.(*ast.FuncDecl)
+		if !isFunc || fd.Recv == nil || len(fd.Recv.List) == 0 || generated(fd) {
+			continue
+		}
+		recvType := receiverTypeName(fd.Recv.List[0].Type)
+		if recvType == "" || declared[recvType] {
+			continue
+		}
+		if _, isType := dest.Declared(recvType).(*types.TypeName); isType {
+			continue
+		}
+		conflicts = append(conflicts, fmt.Sprintf(
+			"line %d: method %s.%s has a receiver type that is no longer declared",
+			fset.Position(fd.Pos()).Line, recvType, fd.Name.Name,
+		))
+	}
+	return conflicts, nil
+}
+
+// receiverTypeName returns the name of the base type of the given method
+// receiver type expression.
+func receiverTypeName(expr ast.Expr) string {
+	switch tn := expr.(type) {
+	case *ast.Ident:
+		return tn.Name
+	case *ast.StarExpr:
+		return receiverTypeName(tn.X)
+	case *ast.ParenExpr:
+		return receiverTypeName(tn.X)
+	case *ast.IndexExpr:
+		return receiverTypeName(tn.X)
+	case *ast.IndexListExpr:
+		return receiverTypeName(tn.X)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,213 @@
+package pilfer
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"testing"
+)
+
+func TestMergeImports(t *testing.T) {
+	existing := []byte(`package gen
+
+import (
+	"fmt"
+)
+
+// Describe is written by hand.
+func (c *Config) Describe() string {
+	return fmt.Sprint(c.When)
+}
+`)
+	generated := []byte(GeneratedHeader + `
+//
+// Roots:    example.com/a.Config
+// Packages: example.com/a
+
+package gen
+
+import (
+	"net/url"
+	"time"
+)
+
+type Config struct {
+	When time.Time
+	Home *url.URL
+}
+`)
+
+	merged, conflicts, err := Merge(existing, generated, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) > 0 {
+		t.Errorf("unexpected conflicts: %q", conflicts)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "", merged, 0); err != nil {
+		t.Fatalf("merged file is invalid: %s\n%s", err, merged)
+	}
+	checkGolden(t, "merge_imports", merged)
+
+	// Merging again replaces the generated declarations, dropping the
+	// imports that only they used.
+	regenerated := []byte(GeneratedHeader + `
+//
+// Roots:    example.com/a.Config
+// Packages: example.com/a
+
+package gen
+
+import "time"
+
+type Config struct {
+	When time.Time
+}
+`)
+	merged, _, err = Merge(merged, regenerated, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "merge_reimports", merged)
+}
+
+func TestMergeImportConflict(t *testing.T) {
+	existing := []byte(`package gen
+
+import tm "time"
+
+var Epoch tm.Time
+`)
+	generated := []byte(GeneratedHeader + `
+
+package gen
+
+import "time"
+
+type Config struct {
+	When time.Time
+}
+`)
+	if _, _, err := Merge(existing, generated, nil); err == nil {
+		t.Fatal("expected an error for an import with a different name")
+	}
+}
+
+func TestMergeGenerated(t *testing.T) {
+	// A file that was generated without merge markers and then had
+	// methods added by hand.
+	existing := []byte(GeneratedHeader + `
+//
+// Roots:    example.com/a.Server
+// Packages: example.com/a
+
+package gen
+
+import "fmt"
+
+type Label string
+
+type Server struct {
+	Name   string
+	Labels []Label
+}
+
+// Describe is written by hand.
+func (s *Server) Describe() string {
+	return fmt.Sprintf("%s %v", s.Name, s.Labels)
+}
+
+func (l Label) String() string {
+	return string(l)
+}
+`)
+	generated := []byte(GeneratedHeader + `
+//
+// Roots:    example.com/a.Server
+// Packages: example.com/a
+
+package gen
+
+type Label string
+
+type Server struct {
+	Name   string
+	Port   int
+	Labels []Label
+}
+`)
+
+	merged, conflicts, err := Merge(existing, generated, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) > 0 {
+		t.Errorf("unexpected conflicts: %q", conflicts)
+	}
+	checkGolden(t, "merge_generated", merged)
+	checkContains(t, "merge_generated", merged,
+		"func (s *Server) Describe() string",
+		"func (l Label) String() string",
+		"Port   int",
+	)
+	if IsGenerated(merged) {
+		t.Errorf("merged file with hand-written code still has the generated code header")
+	}
+}
+
+func TestMergeGeneratedConflicts(t *testing.T) {
+	// The existing file has a type that is no longer generated, a
+	// constant added by hand to a generated block, and a method on a type
+	// that is no longer generated.
+	existing := []byte(GeneratedHeader + `
+
+package gen
+
+type Kind int
+
+const (
+	KindLocal  Kind = 0
+	KindRemote Kind = 1
+	KindMine   Kind = 2
+)
+
+type Legacy struct{}
+
+func (l Legacy) Old() {}
+`)
+	generated := []byte(GeneratedHeader + `
+
+package gen
+
+type Kind int
+
+const (
+	KindLocal  Kind = 0
+	KindRemote Kind = 1
+)
+`)
+
+	merged, conflicts, err := Merge(existing, generated, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "merge_generated_conflicts", merged)
+	want := []string{
+		"line 4: KindMine is not generated any more, or was added by hand to a generated file; it has been kept, so remove it if it isn't needed",
+		"line 7: Legacy is not generated any more, or was added by hand to a generated file; it has been kept, so remove it if it isn't needed",
+	}
+	if !reflect.DeepEqual(conflicts, want) {
+		t.Errorf("wrong conflicts\ngot:  %q\nwant: %q", conflicts, want)
+	}
+
+	// Once the conflicts are resolved by removing what isn't needed,
+	// merging again reports none.
+	resolved := bytes.Replace(merged, []byte("\tKindMine Kind = 2\n"), nil, 1)
+	resolved = bytes.Replace(resolved, []byte("type Legacy struct{}\n\nfunc (l Legacy) Old() {}\n"), nil, 1)
+	if _, conflicts, err = Merge(resolved, generated, nil); err != nil {
+		t.Fatal(err)
+	} else if len(conflicts) > 0 {
+		t.Errorf("unexpected conflicts after resolving: %q", conflicts)
+	}
+}
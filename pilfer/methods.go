@@ -0,0 +1,705 @@
+package pilfer
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+)
+
+// methodWriter writes the methods that Pilfer generates for the copied
+// types, as selected in the options, following their declarations.
+type methodWriter struct {
+	table  typeTable
+	consts constantTable
+	buf    *bytes.Buffer
+
+	// recursive is set for the copied types that can contain values of
+	// their own type, whose values may form cyclic pointer graphs. Their
+	// DeepCopy and Equal methods keep track of the pointers they have
+	// visited, in a seen map, so that cycles neither copy nor compare
+	// forever.
+	recursive map[*takeType]bool
+
+	// guarded is set while writing a method that has a seen map, which
+	// the methods it calls on recursive types should share.
+	guarded bool
+
+	// inlining has the instantiations of generic types currently being
+	// handled inline, rather than by their methods.
+	inlining map[string]bool
+
+	// enums is set for the copied types with constants that have IsValid
+	// methods.
+	enums map[*takeType]bool
+}
+
+func newMethodWriter(table typeTable, consts constantTable, buf *bytes.Buffer) *methodWriter {
+	return &methodWriter{
+		table:     table,
+		consts:    consts,
+		buf:       buf,
+		recursive: recursiveTypes(table),
+		inlining:  map[string]bool{},
+		enums:     enumTypes(table, consts),
+	}
+}
+
+// methodField is a field of the copy of a struct type.
+type methodField struct {
+	name string
+	typ  types.Type
+	tag  string
+}
+
+// recursiveTypes returns the copied types in the given table that can
+// contain values of their own type.
+func recursiveTypes(table typeTable) map[*takeType]bool {
+	refs := map[*takeType][]*takeType{}
+	for _, key := range table.NewNames() {
+		ty := table.TypeByNewName(key)
+		if ty.Reused != "" || ty.Instance == nil && ty.IsAlias() {
+			continue
+		}
+		var walk func(t types.Type)
+		walk = func(t types.Type) {
+			walkTypeNames(t, "", func(t types.Type, path string) {
+				named, isNamed := t.(*types.Named)
+				if !isNamed {
+					return
+				}
+				if to := table.copiedType(named); to != nil && to.Reused == "" {
+					refs[ty] = append(refs[ty], to)
+				}
+				for i := 0; i < named.TypeArgs().Len(); i++ {
+					walk(named.TypeArgs().At(i))
+				}
+			})
+		}
+		for _, f := range table.methodFields(ty) {
+			walk(f.typ)
+		}
+		if _, isStruct := table.methodUnderlying(ty).(*types.Struct); !isStruct {
+			walk(table.methodUnderlying(ty))
+		}
+	}
+
+	ret := map[*takeType]bool{}
+	for ty := range refs {
+		seen := map[*takeType]bool{}
+		queue := append([]*takeType(nil), refs[ty]...)
+		for len(queue) > 0 {
+			next := queue[0]
+			queue = queue[1:]
+			if next == ty {
+				ret[ty] = true
+				break
+			}
+			if !seen[next] {
+				seen[next] = true
+				queue = append(queue, refs[next]...)
+			}
+		}
+	}
+	return ret
+}
+
+// copiedType returns the entry in the table for the copy of the given
+// named type, or for the generic type it instantiates, following aliases.
+// The result is nil if the type isn't named or isn't in the table.
+func (t typeTable) copiedType(typ types.Type) *takeType {
+	named, isNamed := types.Unalias(typ).(*types.Named)
+	if !isNamed {
+		return nil
+	}
+	if ty := t.TypeByInstance(named); ty != nil {
+		return ty
+	}
+	return t.TypeByName(named.Origin().Obj())
+}
+
+// methodUnderlying returns the underlying type of the copy of the given
+// type.
+func (t typeTable) methodUnderlying(ty *takeType) types.Type {
+	if ty.Instance != nil {
+		return ty.Instance.Underlying()
+	}
+	return ty.Type.Underlying()
+}
+
+// methodFields returns the fields of the copy of the given struct type,
+// after any flattening, except for blank fields. The result is empty if the
+// type isn't a struct.
+func (t typeTable) methodFields(ty *takeType) []methodField {
+	var ret []methodField
+	if ty.Flattened != nil {
+		for _, f := range ty.Flattened {
+			name := t.embeddedFieldName(f.Var.Type())
+			if len(f.Field.Names) > 0 {
+				name = f.Field.Names[0].Name
+			}
+			if name != "_" {
+				ret = append(ret, methodField{name, f.Var.Type(), string(fieldTag(f.Field))})
+			}
+		}
+		return ret
+	}
+
+	st, isStruct := t.methodUnderlying(ty).(*types.Struct)
+	if !isStruct {
+		return nil
+	}
+	for i := 0; i < st.NumFields(); i++ {
+		v := st.Field(i)
+		name := v.Name()
+		if v.Embedded() {
+			name = t.embeddedFieldName(v.Type())
+		}
+		if name != "_" {
+			ret = append(ret, methodField{name, v.Type(), st.Tag(i)})
+		}
+	}
+	return ret
+}
+
+// embeddedFieldName returns the name of an embedded field of the given
+// type in a copied struct, which is the new name of the embedded type if it
+// was copied.
+func (t typeTable) embeddedFieldName(typ types.Type) string {
+	if ptr, isPtr := typ.(*types.Pointer); isPtr {
+		typ = ptr.Elem()
+	}
+	switch tt := typ.(type) {
+	case *types.Alias:
+		if ty := t.types[tt.Obj()]; ty != nil {
+			return ty.NewName
+		}
+		return tt.Obj().Name()
+	case *types.Named:
+		if ty := t.copiedType(tt); ty != nil {
+			if ty.Reused != "" {
+				return ty.Reused
+			}
+			return ty.NewName
+		}
+		return tt.Obj().Name()
+	case *types.Basic:
+		return tt.Name()
+	}
+	return "_"
+}
+
+// WriteMethods writes the methods selected in the given options for the
+// given copied type, which has the given constants, if it can have methods.
+func (w *methodWriter) WriteMethods(ty *takeType, consts []*takeConstant, opts Options) {
+	if ty.Instance == nil && ty.IsAlias() || ty.Reused != "" {
+		return
+	}
+	switch w.table.methodUnderlying(ty).(type) {
+	case *types.Interface, *types.Pointer:
+		// Methods can't be declared on these.
+		return
+	}
+	if opts.DeepCopy {
+		w.writeDeepCopy(ty)
+	}
+	if opts.Equal {
+		w.writeEqual(ty)
+	}
+	if opts.Enums {
+		w.writeEnum(ty, consts)
+	} else if opts.Validate {
+		// Validate checks values with IsValid.
+		w.writeIsValid(ty, consts)
+	}
+	if opts.Validate {
+		w.writeValidate(ty)
+	}
+}
+
+// recvType returns the receiver type for the methods of the given type,
+// which includes its type parameters if it is generic.
+func (w *methodWriter) recvType(ty *takeType) string {
+	if ty.Instance != nil || ty.Spec == nil || ty.Spec.TypeParams == nil {
+		return ty.NewName
+	}
+	var params []string
+	for _, field := range ty.Spec.TypeParams.List {
+		for _, name := range field.Names {
+			params = append(params, name.Name)
+		}
+	}
+	return ty.NewName + "[" + strings.Join(params, ", ") + "]"
+}
+
+// writeDeepCopy writes the DeepCopy method of the given copied type, along
+// with DeepCopyInto if it is a struct, which return copies that share no
+// memory with the original. Values that can't be copied in general, like
+// interfaces and functions, and map keys are copied shallowly, as are
+// reused types unless they have a DeepCopyInto method of their own.
+func (w *methodWriter) writeDeepCopy(ty *takeType) {
+	u := w.table.methodUnderlying(ty)
+	recv := w.recvType(ty)
+	recursive := w.recursive[ty]
+
+	if _, isStruct := u.(*types.Struct); isStruct {
+		fmt.Fprintf(w.buf, "\n// DeepCopy returns a copy of in that shares no memory with it, or nil if\n// in is nil.\n")
+		fmt.Fprintf(w.buf, "func (in *%s) DeepCopy() *%s {\n", recv, recv)
+		if recursive {
+			fmt.Fprintf(w.buf, "return in.deepCopy(make(map[interface{}]interface{}))\n}\n")
+		} else {
+			fmt.Fprintf(w.buf, "if in == nil {\nreturn nil\n}\nout := new(%s)\nin.DeepCopyInto(out)\nreturn out\n}\n", recv)
+		}
+
+		fmt.Fprintf(w.buf, "\n// DeepCopyInto copies in into out, sharing no memory with it.\n")
+		fmt.Fprintf(w.buf, "func (in *%s) DeepCopyInto(out *%s) {\n", recv, recv)
+		if recursive {
+			fmt.Fprintf(w.buf, "in.deepCopyInto(out, map[interface{}]interface{}{in: out})\n}\n")
+
+			fmt.Fprintf(w.buf, "\n// deepCopy is like DeepCopy, but returns the copy already made of in if\n// seen has one, so that cyclic pointer graphs are copied as cycles.\n")
+			fmt.Fprintf(w.buf, "func (in *%s) deepCopy(seen map[interface{}]interface{}) *%s {\n", recv, recv)
+			fmt.Fprintf(w.buf, "if in == nil {\nreturn nil\n}\n")
+			fmt.Fprintf(w.buf, "if out, ok := seen[in]; ok {\nreturn out.(*%s)\n}\n", recv)
+			fmt.Fprintf(w.buf, "out := new(%s)\nseen[in] = out\nin.deepCopyInto(out, seen)\nreturn out\n}\n", recv)
+
+			fmt.Fprintf(w.buf, "\nfunc (in *%s) deepCopyInto(out *%s, seen map[interface{}]interface{}) {\n", recv, recv)
+		}
+		w.guarded = recursive
+		fmt.Fprintf(w.buf, "*out = *in\n")
+		for _, f := range w.table.methodFields(ty) {
+			w.copyValue("out."+f.name, "in."+f.name, f.typ, 0)
+		}
+		w.guarded = false
+		fmt.Fprintf(w.buf, "}\n")
+		return
+	}
+
+	fmt.Fprintf(w.buf, "\n// DeepCopy returns a copy of in that shares no memory with it.\n")
+	fmt.Fprintf(w.buf, "func (in %s) DeepCopy() %s {\n", recv, recv)
+	if !w.needsCopy(u, nil) {
+		fmt.Fprintf(w.buf, "return in\n}\n")
+		return
+	}
+	if recursive {
+		fmt.Fprintf(w.buf, "return in.deepCopy(make(map[interface{}]interface{}))\n}\n")
+		fmt.Fprintf(w.buf, "\nfunc (in %s) deepCopy(seen map[interface{}]interface{}) %s {\n", recv, recv)
+	}
+	w.guarded = recursive
+	fmt.Fprintf(w.buf, "out := in\n")
+	w.copyValue("out", "in", u, 0)
+	w.guarded = false
+	fmt.Fprintf(w.buf, "return out\n}\n")
+}
+
+// writeEqual writes the Equal method of the given copied type, which
+// compares what pointers refer to rather than the pointers themselves, and
+// uses reflect.DeepEqual for values that can't be compared otherwise.
+func (w *methodWriter) writeEqual(ty *takeType) {
+	u := w.table.methodUnderlying(ty)
+	recv := w.recvType(ty)
+	recursive := w.recursive[ty]
+
+	if _, isStruct := u.(*types.Struct); isStruct {
+		fmt.Fprintf(w.buf, "\n// Equal reports whether in and other hold the same data, comparing what\n// pointers refer to rather than the pointers themselves.\n")
+		fmt.Fprintf(w.buf, "func (in *%s) Equal(other *%s) bool {\n", recv, recv)
+		if recursive {
+			fmt.Fprintf(w.buf, "return in.equal(other, make(map[[2]interface{}]bool))\n}\n")
+
+			fmt.Fprintf(w.buf, "\n// equal is like Equal, but reports pairs of values in seen as equal, since\n// they are already being compared further up a cyclic pointer graph.\n")
+			fmt.Fprintf(w.buf, "func (in *%s) equal(other *%s, seen map[[2]interface{}]bool) bool {\n", recv, recv)
+		}
+		fmt.Fprintf(w.buf, "if in == other {\nreturn true\n}\nif in == nil || other == nil {\nreturn false\n}\n")
+		if recursive {
+			fmt.Fprintf(w.buf, "pair := [2]interface{}{in, other}\nif seen[pair] {\nreturn true\n}\nseen[pair] = true\n")
+		}
+		w.guarded = recursive
+		for _, f := range w.table.methodFields(ty) {
+			w.compareValues("in."+f.name, "other."+f.name, f.typ, 0)
+		}
+		w.guarded = false
+		fmt.Fprintf(w.buf, "return true\n}\n")
+		return
+	}
+
+	fmt.Fprintf(w.buf, "\n// Equal reports whether in and other hold the same data, comparing what\n// pointers refer to rather than the pointers themselves.\n")
+	fmt.Fprintf(w.buf, "func (in %s) Equal(other %s) bool {\n", recv, recv)
+	if w.plainComparable(u, nil) {
+		fmt.Fprintf(w.buf, "return in == other\n}\n")
+		return
+	}
+	if recursive {
+		fmt.Fprintf(w.buf, "return in.equal(other, make(map[[2]interface{}]bool))\n}\n")
+		fmt.Fprintf(w.buf, "\nfunc (in %s) equal(other %s, seen map[[2]interface{}]bool) bool {\n", recv, recv)
+	}
+	w.guarded = recursive
+	w.compareValues("in", "other", u, 0)
+	w.guarded = false
+	fmt.Fprintf(w.buf, "return true\n}\n")
+}
+
+// copyValue writes statements that replace the shallow copy in dst of the
+// value in src, of the given type, with a deep copy. Nothing is written if
+// a shallow copy shares no memory with the original.
+//
+// Map keys, and values that can't be copied in general, such as
+// interfaces and functions, are copied shallowly.
+func (w *methodWriter) copyValue(dst, src string, t types.Type, depth int) {
+	if !w.needsCopy(t, nil) {
+		return
+	}
+	if expr, ok := w.copyExpr(src, t); ok {
+		fmt.Fprintf(w.buf, "%s = %s\n", dst, expr)
+		return
+	}
+	if key, inline := w.inlineCopy(t); inline {
+		w.inlining[key] = true
+		defer delete(w.inlining, key)
+	} else if ty := w.table.copiedType(t); ty != nil {
+		name, seen := "DeepCopyInto", ""
+		if ty.Reused == "" {
+			name, seen = w.method(ty, name)
+		}
+		fmt.Fprintf(w.buf, "%s.%s(%s%s)\n", receiver(src), name, addr(dst), seen)
+		return
+	}
+
+	switch tt := t.Underlying().(type) {
+	case *types.Pointer:
+		fmt.Fprintf(w.buf, "if %s != nil {\n%s = new(%s)\n*%s = *%s\n", src, dst, w.typeString(tt.Elem()), dst, src)
+		w.copyValue("*"+dst, "*"+src, tt.Elem(), depth)
+		fmt.Fprintf(w.buf, "}\n")
+	case *types.Slice:
+		fmt.Fprintf(w.buf, "if %s != nil {\n%s = make(%s, len(%s))\n", src, dst, w.typeString(t), src)
+		i := depthName("i", depth)
+		if expr, ok := w.copyExpr(selector(src)+"["+i+"]", tt.Elem()); ok {
+			fmt.Fprintf(w.buf, "for %s := range %s {\n%s[%s] = %s\n}\n", i, src, selector(dst), i, expr)
+		} else {
+			fmt.Fprintf(w.buf, "copy(%s, %s)\n", dst, src)
+			if w.needsCopy(tt.Elem(), nil) {
+				fmt.Fprintf(w.buf, "for %s := range %s {\n", i, src)
+				w.copyValue(selector(dst)+"["+i+"]", selector(src)+"["+i+"]", tt.Elem(), depth+1)
+				fmt.Fprintf(w.buf, "}\n")
+			}
+		}
+		fmt.Fprintf(w.buf, "}\n")
+	case *types.Array:
+		i := depthName("i", depth)
+		fmt.Fprintf(w.buf, "for %s := range %s {\n", i, src)
+		w.copyValue(selector(dst)+"["+i+"]", selector(src)+"["+i+"]", tt.Elem(), depth+1)
+		fmt.Fprintf(w.buf, "}\n")
+	case *types.Map:
+		k, v := depthName("k", depth), depthName("v", depth)
+		fmt.Fprintf(w.buf, "if %s != nil {\n%s = make(%s, len(%s))\n", src, dst, w.typeString(t), src)
+		fmt.Fprintf(w.buf, "for %s, %s := range %s {\n", k, v, src)
+		if expr, ok := w.copyExpr(v, tt.Elem()); ok {
+			v = expr
+		} else if w.needsCopy(tt.Elem(), nil) {
+			c := depthName("c", depth)
+			fmt.Fprintf(w.buf, "%s := %s\n", c, v)
+			w.copyValue(c, v, tt.Elem(), depth+1)
+			v = c
+		}
+		fmt.Fprintf(w.buf, "%s[%s] = %s\n}\n}\n", selector(dst), k, v)
+	case *types.Struct:
+		for i := 0; i < tt.NumFields(); i++ {
+			f := tt.Field(i)
+			name := f.Name()
+			if f.Embedded() {
+				name = w.table.embeddedFieldName(f.Type())
+			}
+			if name != "_" {
+				w.copyValue(selector(dst)+"."+name, selector(src)+"."+name, f.Type(), depth)
+			}
+		}
+	}
+}
+
+// copyExpr returns an expression for a deep copy of the value in src, of
+// the given type, if a method of a copied type returns one.
+func (w *methodWriter) copyExpr(src string, t types.Type) (string, bool) {
+	isPtr := false
+	if ptr, ok := t.Underlying().(*types.Pointer); ok && w.table.copiedType(t) == nil {
+		t, isPtr = ptr.Elem(), true
+	}
+	ty := w.table.copiedType(t)
+	if ty == nil || ty.Reused != "" || isStructType(t) != isPtr {
+		return "", false
+	}
+	if _, inline := w.inlineCopy(t); inline {
+		return "", false
+	}
+	if !isPtr {
+		src = receiver(src)
+	}
+	name, seen := w.method(ty, "DeepCopy")
+	return fmt.Sprintf("%s.%s(%s)", selector(src), name, strings.TrimPrefix(seen, ", ")), true
+}
+
+// inlineCopy returns true if a value of the given type is an instantiation
+// of a generic type that must be copied inline, because its DeepCopy method
+// would copy values of its type arguments shallowly, along with a key for
+// the instantiation.
+func (w *methodWriter) inlineCopy(t types.Type) (string, bool) {
+	return w.inlineInstance(t, func(arg types.Type) bool {
+		return w.needsCopy(arg, nil)
+	})
+}
+
+// inlineInstance returns true if a value of the given type is an
+// instantiation of a generic type with a type argument for which the given
+// function returns true, and which isn't already being handled inline,
+// along with a key for the instantiation.
+func (w *methodWriter) inlineInstance(t types.Type, need func(arg types.Type) bool) (string, bool) {
+	named, isNamed := types.Unalias(t).(*types.Named)
+	if !isNamed || named.TypeArgs().Len() == 0 {
+		return "", false
+	}
+	if ty := w.table.copiedType(named); ty == nil || ty.Instance != nil || ty.Reused != "" {
+		return "", false
+	}
+	key := types.TypeString(named, nil)
+	if w.inlining[key] {
+		// A type that contains itself can only be handled by its methods.
+		return "", false
+	}
+	for i := 0; i < named.TypeArgs().Len(); i++ {
+		if need(named.TypeArgs().At(i)) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// compareValues writes statements that return false if the values in a
+// and b, of the given type, don't hold the same data.
+//
+// Values that can't be compared in general, such as interfaces and
+// functions, are compared with reflect.DeepEqual.
+func (w *methodWriter) compareValues(a, b string, t types.Type, depth int) {
+	if w.plainComparable(t, nil) {
+		fmt.Fprintf(w.buf, "if %s != %s {\nreturn false\n}\n", a, b)
+		return
+	}
+	if ty := w.table.copiedType(t); ty != nil {
+		if ty.Reused != "" {
+			w.compareReused(a, b, ty)
+			return
+		}
+		name, seen := w.method(ty, "Equal")
+		if isStructType(t) {
+			b = addr(b)
+		}
+		fmt.Fprintf(w.buf, "if !%s.%s(%s%s) {\nreturn false\n}\n", receiver(a), name, b, seen)
+		return
+	}
+
+	switch tt := t.Underlying().(type) {
+	case *types.Pointer:
+		if ty := w.table.copiedType(tt.Elem()); ty != nil && ty.Reused == "" && isStructType(tt.Elem()) {
+			name, seen := w.method(ty, "Equal")
+			fmt.Fprintf(w.buf, "if !%s.%s(%s%s) {\nreturn false\n}\n", a, name, b, seen)
+			return
+		}
+		fmt.Fprintf(w.buf, "if (%s == nil) != (%s == nil) {\nreturn false\n}\n", a, b)
+		fmt.Fprintf(w.buf, "if %s != nil {\n", a)
+		w.compareValues("*"+a, "*"+b, tt.Elem(), depth)
+		fmt.Fprintf(w.buf, "}\n")
+	case *types.Slice:
+		fmt.Fprintf(w.buf, "if (%s == nil) != (%s == nil) || len(%s) != len(%s) {\nreturn false\n}\n", a, b, a, b)
+		i := depthName("i", depth)
+		fmt.Fprintf(w.buf, "for %s := range %s {\n", i, a)
+		w.compareValues(selector(a)+"["+i+"]", selector(b)+"["+i+"]", tt.Elem(), depth+1)
+		fmt.Fprintf(w.buf, "}\n")
+	case *types.Array:
+		i := depthName("i", depth)
+		fmt.Fprintf(w.buf, "for %s := range %s {\n", i, a)
+		w.compareValues(selector(a)+"["+i+"]", selector(b)+"["+i+"]", tt.Elem(), depth+1)
+		fmt.Fprintf(w.buf, "}\n")
+	case *types.Map:
+		k, v := depthName("k", depth), depthName("v", depth)
+		ov, ok := depthName("w", depth), depthName("ok", depth)
+		fmt.Fprintf(w.buf, "if (%s == nil) != (%s == nil) || len(%s) != len(%s) {\nreturn false\n}\n", a, b, a, b)
+		fmt.Fprintf(w.buf, "for %s, %s := range %s {\n%s, %s := %s[%s]\nif !%s {\nreturn false\n}\n", k, v, a, ov, ok, selector(b), k, ok)
+		w.compareValues(v, ov, tt.Elem(), depth+1)
+		fmt.Fprintf(w.buf, "}\n")
+	case *types.Struct:
+		for i := 0; i < tt.NumFields(); i++ {
+			f := tt.Field(i)
+			name := f.Name()
+			if f.Embedded() {
+				name = w.table.embeddedFieldName(f.Type())
+			}
+			if name != "_" {
+				w.compareValues(selector(a)+"."+name, selector(b)+"."+name, f.Type(), depth)
+			}
+		}
+	default:
+		fmt.Fprintf(w.buf, "if !%s.DeepEqual(%s, %s) {\nreturn false\n}\n", w.table.gen.stdImport("reflect"), a, b)
+	}
+}
+
+// compareReused writes statements that return false if the values in a
+// and b, of the given reused type, differ, using its Equal method if it
+// has one.
+func (w *methodWriter) compareReused(a, b string, ty *takeType) {
+	if sig := w.existingMethod(ty, "Equal"); sig != nil && sig.Params().Len() == 1 {
+		if _, isPtr := sig.Params().At(0).Type().(*types.Pointer); isPtr {
+			b = addr(b)
+		}
+		fmt.Fprintf(w.buf, "if !%s.Equal(%s) {\nreturn false\n}\n", receiver(a), b)
+		return
+	}
+	fmt.Fprintf(w.buf, "if !%s.DeepEqual(%s, %s) {\nreturn false\n}\n", w.table.gen.stdImport("reflect"), a, b)
+}
+
+// method returns the name of the method to call on a value of the given
+// copied type, and any extra argument to pass it, using the variant that
+// shares the seen map of the method being written if it has one.
+func (w *methodWriter) method(ty *takeType, name string) (string, string) {
+	sameOutput := !w.table.scoped || ty.OutputPkgPath() == w.table.gen.pkgPath
+	if w.guarded && w.recursive[ty] && sameOutput {
+		return strings.ToLower(name[:1]) + name[1:], ", seen"
+	}
+	return name, ""
+}
+
+// existingMethod returns the signature of the method with the given name
+// of the existing type that the given reused or kept type resolves to, or
+// nil if it has no such method.
+func (w *methodWriter) existingMethod(ty *takeType, name string) *types.Signature {
+	existing, isTypeName := w.table.dest.Declared(ty.Reused).(*types.TypeName)
+	if ty.Kept {
+		existing, isTypeName = ty.Name, true
+	}
+	if !isTypeName {
+		return nil
+	}
+	mset := types.NewMethodSet(types.NewPointer(existing.Type()))
+	sel := mset.Lookup(existing.Pkg(), name)
+	if sel == nil {
+		return nil
+	}
+	sig, _ := sel.Type().(*types.Signature)
+	return sig
+}
+
+// needsCopy returns true if a shallow copy of a value of the given type
+// could share memory with the original that DeepCopy ought to copy.
+func (w *methodWriter) needsCopy(t types.Type, visiting map[*takeType]bool) bool {
+	if ty := w.table.copiedType(t); ty != nil {
+		if ty.Reused != "" {
+			return w.existingMethod(ty, "DeepCopyInto") != nil
+		}
+		if visiting[ty] {
+			// Anything that refers back to itself has to do so through
+			// something that needs copying, which will be found anyway.
+			return false
+		}
+		if visiting == nil {
+			visiting = map[*takeType]bool{}
+		}
+		visiting[ty] = true
+		defer delete(visiting, ty)
+	}
+
+	switch tt := t.Underlying().(type) {
+	case *types.Pointer, *types.Slice, *types.Map:
+		return true
+	case *types.Array:
+		return w.needsCopy(tt.Elem(), visiting)
+	case *types.Struct:
+		for i := 0; i < tt.NumFields(); i++ {
+			if w.needsCopy(tt.Field(i).Type(), visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// plainComparable returns true if values of the given type hold the same
+// data exactly when they are equal according to the == operator.
+func (w *methodWriter) plainComparable(t types.Type, visiting map[*takeType]bool) bool {
+	if _, isParam := t.(*types.TypeParam); isParam {
+		return false
+	}
+	if ty := w.table.copiedType(t); ty != nil {
+		if ty.Reused != "" && w.existingMethod(ty, "Equal") != nil {
+			return false
+		}
+		if visiting[ty] {
+			return false
+		}
+		if visiting == nil {
+			visiting = map[*takeType]bool{}
+		}
+		visiting[ty] = true
+		defer delete(visiting, ty)
+	}
+
+	switch tt := t.Underlying().(type) {
+	case *types.Basic, *types.Chan:
+		return true
+	case *types.Array:
+		return w.plainComparable(tt.Elem(), visiting)
+	case *types.Struct:
+		for i := 0; i < tt.NumFields(); i++ {
+			if !w.plainComparable(tt.Field(i).Type(), visiting) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// typeString returns the given type as written in the output package.
+func (w *methodWriter) typeString(t types.Type) string {
+	var buf bytes.Buffer
+	format.Node(&buf, token.NewFileSet(), typeExpr(t, w.table))
+	return buf.String()
+}
+
+// selector returns the given expression in a form that a selector or
+// index can follow, which needs parentheses if it is dereferenced.
+func selector(x string) string {
+	if strings.HasPrefix(x, "*") {
+		return "(" + x + ")"
+	}
+	return x
+}
+
+// receiver returns the given expression, for a value that isn't itself a
+// pointer, as the receiver of a method call. A dereferenced pointer can be
+// the receiver itself, since its methods include those of what it points
+// to.
+func receiver(x string) string {
+	return selector(strings.TrimPrefix(x, "*"))
+}
+
+// addr returns an expression for the address of the given expression.
+func addr(x string) string {
+	if strings.HasPrefix(x, "*") {
+		return x[1:]
+	}
+	return "&" + x
+}
+
+// isStructType returns true if the given type is a struct type.
+func isStructType(t types.Type) bool {
+	_, isStruct := t.Underlying().(*types.Struct)
+	return isStruct
+}
+
+// depthName returns a name for a variable declared at the given depth of
+// nested loops, so that the names of nested variables don't shadow the
+// outer ones.
+func depthName(base string, depth int) string {
+	if depth == 0 {
+		return base
+	}
+	return base + strconv.Itoa(depth+1)
+}
@@ -0,0 +1,116 @@
+package pilfer
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDeepCopyEqual runs the DeepCopy and Equal methods generated for
+// types whose values can be cyclic, to check what they do rather than how
+// they are written.
+func TestDeepCopyEqual(t *testing.T) {
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go command is not available")
+	}
+
+	prog := loadTestdata(t, "example.com/cyclic")
+	roots := []Root{{Package: "example.com/cyclic", Type: "Tree"}}
+	opts := Options{
+		PackageName: "main",
+		DeepCopy:    true,
+		Equal:       true,
+		Keep:        []string{"time.Time"},
+	}
+	src := pilferTestdata(t, prog, roots, opts)
+
+	dir, err := ioutil.TempDir("", "pilfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string][]byte{
+		"gen.go":  src,
+		"main.go": []byte(deepCopyEqualMain),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(goCmd, "run", "gen.go", "main.go")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GO111MODULE=off", "GOFLAGS=", "GOPATH="+dir)
+	out, err := cmd.CombinedOutput()
+	if err != nil || strings.TrimSpace(string(out)) != "ok" {
+		t.Fatalf("generated methods failed: %v\n%s\ngenerated code:\n%s", err, out, src)
+	}
+}
+
+const deepCopyEqualMain = `package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+func check(ok bool, what string) {
+	if !ok {
+		fmt.Println(what)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	timeout := Second
+	a := &Node{
+		Name:    "a",
+		Attrs:   map[string][]string{"k": {"v"}},
+		Data:    []byte("x"),
+		Created: time.Unix(1, 0),
+		Timeout: &timeout,
+	}
+	b := &Node{Name: "b", Next: a}
+	a.Next = b
+	a.Children = []*Node{b, a}
+	tree := &Tree{
+		Root:   a,
+		Index:  map[string]*Node{"a": a, "b": b},
+		Stamps: []time.Time{time.Unix(2, 0)},
+	}
+
+	c := tree.DeepCopy()
+	check(c.Equal(tree) && tree.Equal(c), "copy is not equal to the original")
+	check(c.Root != a && c.Root.Next != b && c.Index["a"] != a, "copy shares nodes with the original")
+	check(c.Root.Next.Next == c.Root, "cycle is not copied as a cycle")
+	check(c.Root.Children[1] == c.Root, "self reference is not copied as one")
+
+	c.Root.Attrs["k"][0] = "changed"
+	c.Root.Data[0] = 'y'
+	*c.Root.Timeout = Minute
+	check(a.Attrs["k"][0] == "v" && a.Data[0] == 'x' && timeout == Second, "copy shares memory with the original")
+	check(!c.Equal(tree), "changed copy is equal to the original")
+
+	// Kept types are copied as values and compared with their own Equal
+	// method, so the same instant in another location is equal.
+	d := tree.DeepCopy()
+	d.Stamps[0] = time.Unix(3, 0)
+	check(tree.Stamps[0].Equal(time.Unix(2, 0)), "kept type is shared with the copy")
+	check(!d.Equal(tree), "difference in kept type is ignored")
+	d.Stamps[0] = time.Unix(2, 0).UTC()
+	check(d.Equal(tree), "kept type is not compared with its Equal method")
+
+	// Different cycles over equal names are told apart.
+	e := tree.DeepCopy()
+	e.Root.Next.Next = e.Root.Next
+	check(!e.Equal(tree), "different cycles are equal")
+
+	fmt.Println("ok")
+}
+`
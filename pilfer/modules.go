@@ -0,0 +1,231 @@
+package pilfer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// moduleResolver finds packages using "go list", so that packages can be
+// loaded from within a Go module, resolving dependencies through the module
+// graph, including any "replace" directives and vendor directory, rather
+// than through GOPATH.
+//
+// "go list" will use the module cache and will not access the network as
+// long as all of the required modules are already present there (or in the
+// vendor directory).
+type moduleResolver struct {
+	ctxt *build.Context
+
+	mu     sync.Mutex
+	byPath map[string]*goListPackage
+	byDir  map[string]*goListPackage
+}
+
+// goListPackage is the subset of the JSON package description produced by
+// "go list -json" that we need to construct a build.Package.
+type goListPackage struct {
+	Dir        string
+	ImportPath string
+	Name       string
+	Goroot     bool
+
+	GoFiles  []string
+	CgoFiles []string
+
+	CgoCFLAGS    []string
+	CgoCPPFLAGS  []string
+	CgoCXXFLAGS  []string
+	CgoLDFLAGS   []string
+	CgoPkgConfig []string
+
+	Imports   []string
+	ImportMap map[string]string
+
+	Error *struct {
+		Err string
+	}
+}
+
+// inModule returns true if the go command considers the given directory
+// (or the working directory, if empty) to be inside a Go module.
+func inModule(dir string) bool {
+	cmd := exec.Command("go", "env", "GOMOD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+	gomod := strings.TrimSpace(string(out))
+	return gomod != "" && gomod != os.DevNull
+}
+
+func newModuleResolver(ctxt *build.Context) *moduleResolver {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	return &moduleResolver{
+		ctxt:   ctxt,
+		byPath: make(map[string]*goListPackage),
+		byDir:  make(map[string]*goListPackage),
+	}
+}
+
+// FindPackage implements the signature of loader.Config.FindPackage.
+func (r *moduleResolver) FindPackage(ctxt *build.Context, importPath, fromDir string, mode build.ImportMode) (*build.Package, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The importing package may see this import path as something else,
+	// such as a package in the standard library's vendor directory.
+	if importer := r.byDir[fromDir]; importer != nil {
+		if mapped, ok := importer.ImportMap[importPath]; ok {
+			importPath = mapped
+		}
+	}
+
+	lp := r.byPath[importPath]
+	if lp == nil {
+		if err := r.list(fromDir, importPath); err != nil {
+			return nil, err
+		}
+		lp = r.byPath[importPath]
+	}
+	if lp == nil {
+		return nil, fmt.Errorf("cannot find package %q", importPath)
+	}
+	if lp.Error != nil {
+		return nil, fmt.Errorf("%s", lp.Error.Err)
+	}
+
+	return &build.Package{
+		Dir:          lp.Dir,
+		Name:         lp.Name,
+		ImportPath:   lp.ImportPath,
+		Goroot:       lp.Goroot,
+		GoFiles:      lp.GoFiles,
+		CgoFiles:     lp.CgoFiles,
+		CgoCFLAGS:    lp.CgoCFLAGS,
+		CgoCPPFLAGS:  lp.CgoCPPFLAGS,
+		CgoCXXFLAGS:  lp.CgoCXXFLAGS,
+		CgoLDFLAGS:   lp.CgoLDFLAGS,
+		CgoPkgConfig: lp.CgoPkgConfig,
+		Imports:      lp.Imports,
+	}, nil
+}
+
+// Prefetch resolves the given patterns and all of their dependencies with a
+// single "go list" call, so that later calls to FindPackage for those
+// packages need not run "go list" again.
+func (r *moduleResolver) Prefetch(patterns ...string) error {
+	if len(patterns) == 0 {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.list("", patterns...)
+}
+
+// ImportPathForDir returns the import path of the package in the given
+// directory, or an empty string if the directory is not part of the main
+// module or one of its dependencies.
+func (r *moduleResolver) ImportPathForDir(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.list("", abs); err != nil {
+		return "", err
+	}
+	lp := r.byDir[abs]
+	if lp == nil || lp.Error != nil || lp.ImportPath == "command-line-arguments" {
+		return "", nil
+	}
+	return lp.ImportPath, nil
+}
+
+// list runs "go list" in the given directory for the given patterns,
+// recording the packages it reports. The caller must hold r.mu.
+func (r *moduleResolver) list(dir string, patterns ...string) error {
+	args := []string{"list", "-e", "-deps", "-json"}
+	if len(r.ctxt.BuildTags) > 0 {
+		args = append(args, "-tags="+strings.Join(r.ctxt.BuildTags, ","))
+	}
+	args = append(args, "--")
+	args = append(args, patterns...)
+
+	cgo := "0"
+	if r.ctxt.CgoEnabled {
+		cgo = "1"
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GOOS="+r.ctxt.GOOS,
+		"GOARCH="+r.ctxt.GOARCH,
+		"CGO_ENABLED="+cgo,
+	)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("go list failed: %s\n%s", err, stderr.String())
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		lp := &goListPackage{}
+		err := dec.Decode(lp)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid output from go list: %s", err)
+		}
+		r.byPath[lp.ImportPath] = lp
+		if lp.Dir != "" {
+			r.byDir[lp.Dir] = lp
+		}
+	}
+	return nil
+}
+
+// moduleFile returns the location of the go.mod file for the module that
+// contains the given directory, along with the module's path.
+func moduleFile(dir string) (string, string, error) {
+	cmd := exec.Command("go", "env", "GOMOD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to find go.mod for %s: %s", dir, err)
+	}
+	gomod := strings.TrimSpace(string(out))
+	src, err := ioutil.ReadFile(gomod)
+	if err != nil {
+		return "", "", err
+	}
+	for _, line := range strings.Split(string(src), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "module" {
+			modPath := fields[1]
+			if unquoted, err := strconv.Unquote(modPath); err == nil {
+				modPath = unquoted
+			}
+			return gomod, modPath, nil
+		}
+	}
+	return "", "", fmt.Errorf("%s does not declare a module path", gomod)
+}
@@ -0,0 +1,87 @@
+package pilfer
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadModule(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("the go command is not available")
+	}
+
+	// The app module gets example.com/upstream through a replace directive
+	// pointing at a directory outside GOPATH, so the packages can only be
+	// found through the module graph. Load looks for a module in the
+	// working directory.
+	dir, err := filepath.Abs(filepath.Join("testdata", "mod", "app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	defer setenv(t, "GO111MODULE", "on")()
+	defer setenv(t, "GOFLAGS", "-mod=mod")()
+
+	if !inModule("") {
+		t.Fatalf("%s is not recognized as being in a module", dir)
+	}
+	gomod, modPath, err := moduleFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gomod != filepath.Join(dir, "go.mod") || modPath != "example.com/app" {
+		t.Errorf("wrong module file %s for %s", gomod, modPath)
+	}
+
+	// A package in the replaced module, by its import path, along with
+	// its own internal package.
+	prog, err := Load(nil, "example.com/upstream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := []Root{{Package: "example.com/upstream", Type: "Config"}}
+	checkContains(t, "upstream", pilferTestdata(t, prog, roots, Options{}),
+		"Kind Kind   `json:\"kind\"`",
+		"Local  Kind = \"local\"",
+	)
+
+	// The main module's own package, by its directory, which resolves to
+	// its import path in the module.
+	prog, err = Load(nil, "./")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := prog.pkgPaths["./"]; got != "example.com/app" {
+		t.Errorf("directory resolved to package %q; want example.com/app", got)
+	}
+	roots = []Root{{Package: "./", Type: "Settings"}}
+	checkContains(t, "app", pilferTestdata(t, prog, roots, Options{}),
+		"Upstream Config `json:\"upstream\"`",
+		"type Config struct",
+	)
+}
+
+// setenv sets the given environment variable, returning a function that
+// restores its original value.
+func setenv(t *testing.T, name, value string) func() {
+	old, wasSet := os.LookupEnv(name)
+	if err := os.Setenv(name, value); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		if wasSet {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package pilfer
+
+// Options customizes the code that Pilfer generates.
+type Options struct {
+	// PackageName is the name to use in the package clause of the generated
+	// file.
+	PackageName string
+
+	// Command is the command line used to run pilfer, which is recorded in
+	// the header of the generated file. It is omitted if empty.
+	Command string
+
+	// Directive is the location of the go:generate directive that ran
+	// pilfer, such as "types.go:12", which is recorded in the header of
+	// the generated file. It is omitted if empty.
+	Directive string
+
+	// Aliases decides how type aliases are handled, both as roots and when
+	// encountered as dependencies.
+	Aliases AliasMode
+
+	// Monomorphize selects instantiations of generic types that should be
+	// copied as new non-generic named types, rather than referring to a
+	// copy of the generic type. Each element is either the package path
+	// and name of a generic type, like "example.com/foo.Page", to select
+	// all of its instantiations, or a specific instantiation written out
+	// in full, like "example.com/foo.Page[example.com/foo.Resource]". The
+	// special element "all" selects every instantiation.
+	Monomorphize []string
+
+	// Flatten selects struct types whose fields should be copied directly
+	// into any struct that embeds them, rather than copying the embedded
+	// type separately. Each element is the package path and name of a
+	// type, like "example.com/foo.Meta", or the special element "all".
+	//
+	// Flattening keeps the JSON encoding of the embedding struct the same
+	// as the original: embedded fields with a json name of their own
+	// aren't flattened, shadowed fields are renamed and given their
+	// original json names, and fields that encoding/json would have ignored
+	// are tagged to be ignored. A struct whose encoding can't be preserved
+	// in this way isn't flattened at all. Since the fields of an embedded
+	// pointer are copied as fields of the embedding struct, they are always
+	// present even where the pointer would have been nil.
+	Flatten []string
+
+	// Destination describes the package that the generated file will
+	// belong to. Generated declarations avoid the names of the existing
+	// declarations there, and may refer to existing types as selected by
+	// Reuse. If it is nil, the destination package is assumed to be empty.
+	Destination *Destination
+
+	// Reuse maps the package path and name of source types, like
+	// "example.com/foo.Address", to the names of existing types in the
+	// destination package that should be used instead of copying them. An
+	// empty name means the same name as the source type. Each existing type
+	// must be structurally compatible with the source type it replaces,
+	// and the constants of reused types are not copied.
+	Reuse map[string]string
+
+	// Keep lists the package path and name of source types, like
+	// "time.Time", that the generated declarations should refer to in
+	// their original packages rather than copying them, importing those
+	// packages as needed. Kept types must be exported, and their packages
+	// importable.
+	Keep []string
+
+	// Rename maps the package path and name of source types and constants
+	// to the names to give their copies, taking priority over any names
+	// recorded in Lock.
+	Rename map[string]string
+
+	// StructTags lists the keys of the struct tags to keep on the fields
+	// of the copied structs, like "json", removing any others. If it is nil
+	// then all of the tags are kept.
+	StructTags []string
+
+	// Lock gives the names assigned to declarations when the output was
+	// previously generated, which are kept where possible so that the
+	// names of copies don't change when the source packages do. New names
+	// are allocated only for declarations that aren't in the lock, and
+	// avoid the names it records. It may be nil.
+	Lock *NameLock
+
+	// FieldNumbers gives the numbers assigned to the fields of messages
+	// when a protobuf schema was previously generated by Proto, which are
+	// kept so that data encoded with the old schema can be read with the
+	// new one. It may be nil.
+	FieldNumbers *FieldNumberLock
+
+	// DeepCopy adds DeepCopy methods to the copied types, along with
+	// DeepCopyInto for structs, which copy values without sharing memory.
+	DeepCopy bool
+
+	// Equal adds Equal methods to the copied types, which compare values
+	// by what their pointers refer to.
+	Equal bool
+
+	// Enums adds String and IsValid methods, and Parse and Values
+	// functions, to each copied type with constants whose underlying type
+	// is a basic type.
+	Enums bool
+
+	// Validate adds a Validate method to each copied struct, and to other
+	// copied types that can contain values of types with constants, which
+	// reports the values that aren't one of the constants of their type,
+	// except for zero values of fields with the omitempty option.
+	Validate bool
+
+	// Layout decides how the generated declarations are divided between
+	// files and packages by PilferFiles. Pilfer always uses SingleFile.
+	Layout Layout
+
+	// ImportPath is the import path of the directory that the generated
+	// files are written to. It is required for the PackagePerPackage
+	// layout, whose packages import each other, and unused otherwise.
+	ImportPath string
+}
+
+// AliasMode decides how Pilfer handles type aliases, like "type Foo = Bar".
+type AliasMode int
+
+const (
+	// FollowAliases replaces each alias with the type it refers to, so
+	// that the alias itself does not appear in the result. An alias whose
+	// target is not a named type, like "type Strings = []string", cannot be
+	// followed and so is reproduced as with KeepAliases.
+	FollowAliases AliasMode = iota
+
+	// KeepAliases reproduces each alias declaration, referring to the copy
+	// of its target.
+	KeepAliases
+)
@@ -1,79 +1,277 @@
 package pilfer
 
 import (
-	"bytes"
 	"fmt"
 	"go/ast"
-	"go/format"
+	"go/build"
 	"go/token"
 	"go/types"
 	"io"
+	"sort"
+	"strings"
 
 	"golang.org/x/tools/go/loader"
 )
 
-func Pilfer(srcPkg, typeName string, w io.Writer, pkgName string) error {
-	prog, err := sourceProgram(srcPkg)
-	if err != nil {
-		return err
+// Root identifies a type that Pilfer should copy, along with all of the
+// types it depends on.
+type Root struct {
+	// Package is the import path of the package that defines the type.
+	Package string
+
+	// Type is the name of the type within that package.
+	Type string
+}
+
+func (r Root) String() string {
+	return fmt.Sprintf("%s:%s", r.Package, r.Type)
+}
+
+// RootPackages returns the distinct package paths of the given roots, in
+// the order they first appear.
+func RootPackages(roots []Root) []string {
+	var ret []string
+	seen := map[string]bool{}
+	for _, root := range roots {
+		if !seen[root.Package] {
+			ret = append(ret, root.Package)
+			seen[root.Package] = true
+		}
 	}
-	info := prog.Imported[srcPkg]
+	return ret
+}
+
+// Program is a loaded source program from which types can be pilfered.
+//
+// A single program can be used for any number of calls, so that multiple
+// extractions from the same source packages need only load them once.
+type Program struct {
+	prog *loader.Program
+
+	// pkgPaths maps each of the source arguments given to Load to the
+	// path of the package that was loaded for it.
+	pkgPaths map[string]string
+}
 
-	ty := findTypeNameString(info, typeName)
-	if ty == nil {
-		return fmt.Errorf("package %s contains no type named %q", info.Pkg.Name(), typeName)
+// Load parses and type-checks the given source packages, along with all of
+// the packages they depend on.
+//
+// Each source is either an import path, a directory containing a package
+// (which must begin with "./", "../" or "/"), or a comma-separated list of
+// Go source files that together form a package. Directories and files need
+// not be within GOPATH, and may belong to a "main" package.
+//
+// The given build context decides which files are included in each package,
+// based on build constraints. If it is nil, build.Default is used.
+//
+// If the working directory is inside a Go module then packages are found
+// using the go command, which resolves them through the module graph.
+// Otherwise, they are found in GOPATH.
+func Load(ctxt *build.Context, srcs ...string) (*Program, error) {
+	cfg := loader.Config{
+		Build: ctxt,
 	}
-	if !ty.IsNamed() {
-		return fmt.Errorf("type %s in package %s is not a named type", typeName, info.Pkg.Name())
+
+	var modules *moduleResolver
+	if inModule("") {
+		modules = newModuleResolver(ctxt)
+		cfg.FindPackage = modules.FindPackage
+
+		var importPaths []string
+		for _, src := range srcs {
+			if !IsLocalSource(src) {
+				importPaths = append(importPaths, src)
+			}
+		}
+		if err := modules.Prefetch(importPaths...); err != nil {
+			return nil, err
+		}
 	}
 
-	types := findInterestingTypes(ty, prog)
-	consts := findInterestingConsts(prog, types)
-	constNamesByType := findInterestingConsts(prog, types).NewNamesByTypeName()
+	pkgPaths := make(map[string]string, len(srcs))
+	for _, src := range srcs {
+		if _, done := pkgPaths[src]; done {
+			continue
+		}
+		if !IsLocalSource(src) {
+			cfg.Import(src)
+			pkgPaths[src] = src
+			continue
+		}
 
-	buf := bytes.Buffer{}
-	fmt.Fprintf(&buf, "package %s\n\n", pkgName)
+		if modules != nil && !isFileList(src) {
+			pkgPath, err := modules.ImportPathForDir(src)
+			if err != nil {
+				return nil, err
+			}
+			if pkgPath != "" {
+				cfg.Import(pkgPath)
+				pkgPaths[src] = pkgPath
+				continue
+			}
+		}
 
-	for _, newName := range types.NewNames() {
-		ty := types.TypeByNewName(newName)
-		pkgPath := ty.Name.Pkg().Path()
-		info := prog.Package(pkgPath)
+		pkgPath, filenames, err := localSourceFiles(cfg.Build, src)
+		if err != nil {
+			return nil, err
+		}
+		if filenames == nil {
+			cfg.Import(pkgPath)
+		} else {
+			cfg.CreateFromFilenames(pkgPath, filenames...)
+		}
+		pkgPaths[src] = pkgPath
+	}
 
-		wrap := &ast.GenDecl{
-			Tok: token.TYPE,
-			Specs: []ast.Spec{
-				ty.Spec,
-			},
+	prog, err := cfg.Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Program{
+		prog:     prog,
+		pkgPaths: pkgPaths,
+	}, nil
+}
+
+// Pilfer writes to the given writer a Go source file containing copies of
+// the given root types and all of the types and constants they depend on.
+//
+// The layout in the given options is ignored, so that all of the
+// declarations are written to the same file. Use PilferFiles to divide them
+// between files or packages.
+func (p *Program) Pilfer(roots []Root, w io.Writer, opts Options) error {
+	opts.Layout = SingleFile
+	files, err := p.PilferFiles(roots, opts)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		w.Write(file.Source)
+	}
+	return nil
+}
+
+// Explain writes to the given writer the shortest chain of references that
+// leads from one of the given root types to the given victim type, which is
+// given as a package path and type name separated by a period.
+func (p *Program) Explain(roots []Root, victim string, w io.Writer, opts Options) error {
+	rootTypes, table, err := p.collect(roots, opts)
+	if err != nil {
+		return err
+	}
+
+	sep := strings.LastIndex(victim, ".")
+	if sep < 1 {
+		return fmt.Errorf("victim type must be package path and type name separated by a period")
+	}
+	victimPkg, victimName := victim[:sep], victim[sep+1:]
+
+	var target *takeType
+	if info := p.prog.Package(victimPkg); info != nil {
+		if tn, isName := info.Pkg.Scope().Lookup(victimName).(*types.TypeName); isName {
+			target = table.TypeByName(tn)
 		}
-		rewriteTypeIdents(wrap, info, types)
-		format.Node(&buf, prog.Fset, wrap)
-		buf.WriteString("\n\n")
+	}
+	if target == nil {
+		return fmt.Errorf("type %s is not used by any of the root types", victim)
+	}
 
-		constNames := constNamesByType[newName]
-		if len(constNames) > 0 {
-			buf.WriteString("const (\n")
-			for _, constName := range constNames {
-				cn := consts.ConstantByNewName(constName)
-				fmt.Fprintf(&buf, "\t%s %s = %s\n", constName, newName, cn.Value.ExactString())
-			}
-			buf.WriteString(")\n")
+	var chain []*typeEdge
+	var from *takeType
+	for _, root := range rootTypes {
+		candidate := shortestChain(table, root, target)
+		if candidate != nil && (from == nil || len(candidate) < len(chain)) {
+			chain = candidate
+			from = root
 		}
 	}
 
-	fmted, err := format.Source(buf.Bytes())
+	fmt.Fprintln(w, from.QualifiedName())
+	for _, edge := range chain {
+		fmt.Fprintf(w, "  %s\n", edge)
+	}
+	return nil
+}
+
+// Graph writes to the given writer the full graph of types that would be
+// copied for the given root types, in the Graphviz DOT language.
+func (p *Program) Graph(roots []Root, w io.Writer, opts Options) error {
+	_, table, err := p.collect(roots, opts)
 	if err != nil {
-		// should never happen because we should always generate valid input
-		panic(err)
+		return err
 	}
-	w.Write(fmted)
 
+	writeDOT(w, table)
 	return nil
 }
 
-func sourceProgram(srcPkg string) (*loader.Program, error) {
-	var cfg loader.Config
-	cfg.Import(srcPkg)
-	return cfg.Load()
+// collect finds the given root types along with all of the types they
+// depend on.
+func (p *Program) collect(roots []Root, opts Options) ([]*takeType, typeTable, error) {
+	if opts.Layout == PackagePerPackage {
+		// Each output package is separate from the destination package,
+		// so it has no declarations to avoid or reuse.
+		opts.Destination = nil
+		opts.Reuse = nil
+	}
+	if err := checkRenames(opts); err != nil {
+		return nil, typeTable{}, err
+	}
+	table := newTypeTable(opts)
+	rootTypes := make([]*takeType, 0, len(roots))
+	for _, root := range roots {
+		info := p.prog.Package(p.pkgPaths[root.Package])
+		if info == nil {
+			return nil, typeTable{}, fmt.Errorf("package %s was not loaded", root.Package)
+		}
+
+		ty := findTypeNameString(info, root.Type)
+		if ty == nil {
+			return nil, typeTable{}, fmt.Errorf("package %s contains no type named %q", info.Pkg.Name(), root.Type)
+		}
+		if ty.IsAlias() && opts.Aliases == FollowAliases {
+			if target := aliasTarget(ty.Name); target != nil {
+				ty = findTypeName(p.prog, target)
+				if ty == nil {
+					return nil, typeTable{}, fmt.Errorf("cannot find the declaration of %s, which %s is an alias for", target.Type(), root.Type)
+				}
+			}
+		}
+		if !ty.IsNamed() && !ty.IsAlias() {
+			return nil, typeTable{}, fmt.Errorf("type %s in package %s is not a named type", root.Type, info.Pkg.Name())
+		}
+
+		if shouldKeep(ty.Name, opts) {
+			return nil, typeTable{}, fmt.Errorf("cannot keep %s, because it is a root", ty.QualifiedName())
+		}
+
+		if existing := table.TypeByName(ty.Name); existing != nil {
+			// Already reached from an earlier root.
+			rootTypes = append(rootTypes, existing)
+			continue
+		}
+		addInterestingTypes(ty, table, p.prog, opts)
+		rootTypes = append(rootTypes, ty)
+	}
+
+	for _, ty := range table.types {
+		if ty.Kept {
+			if err := checkKeptType(ty); err != nil {
+				return nil, typeTable{}, err
+			}
+		}
+	}
+	for _, newName := range table.NewNames() {
+		if ty := table.TypeByNewName(newName); ty.Reused != "" {
+			if err := checkReusedType(ty, opts); err != nil {
+				return nil, typeTable{}, err
+			}
+		}
+	}
+	if table.scoped {
+		table.exportSharedTypes()
+	}
+	return rootTypes, table, nil
 }
 
 func findTypeNameString(info *loader.PackageInfo, typeName string) *takeType {
@@ -167,42 +365,148 @@ func findTypeName(prog *loader.Program, name *types.TypeName) *takeType {
 	return nil
 }
 
-func findInterestingTypes(start *takeType, prog *loader.Program) typeTable {
-	ret := newTypeTable()
-	addInterestingTypes(start, ret, prog)
-	return ret
-}
+func addInterestingTypes(start *takeType, table typeTable, prog *loader.Program, opts Options) {
+	if start.Instance == nil && shouldKeep(start.Name, opts) {
+		// The original type is used along with everything it depends on.
+		start.Kept = true
+		start.Reused = start.Name.Name()
+		table.Add(start)
+		return
+	}
+	if destName, reuse := reuseName(start.Name, opts); reuse && start.Instance == nil {
+		// The existing type stands in for this one along with everything
+		// it depends on, so there's nothing more to find.
+		start.Reused = destName
+		table.Add(start)
+		return
+	}
 
-func addInterestingTypes(start *takeType, table typeTable, prog *loader.Program) {
 	table.Add(start)
 	info := prog.Package(start.Name.Pkg().Path())
-	astVisitor(func(node ast.Node) {
-		ident, isIdent := node.(*ast.Ident)
-		if !isIdent {
-			return
+	cb := func(ref ast.Expr, ident *ast.Ident, path string) bool {
+		if ident == nil {
+			return true
+		}
+
+		// An instantiation of a generic type may be copied as a distinct
+		// non-generic type, in which case its type arguments are dealt
+		// with by addInstanceRef.
+		switch ref.(type) {
+		case *ast.IndexExpr, *ast.IndexListExpr:
+			if named, isNamed := info.Types[ref].Type.(*types.Named); isNamed && shouldMonomorphize(named, opts) {
+				if ty := addInstanceRef(start, path, named, table, prog, opts); ty != nil {
+					table.AddInstanceRef(ident, ty)
+				}
+				return false
+			}
 		}
 
 		obj := info.Uses[ident]
 		if obj == nil {
-			return
+			return true
 		}
 
 		tn, isTypeName := obj.(*types.TypeName)
 		if !isTypeName {
-			return
+			return true
 		}
 
 		if tn.Pkg() == nil {
 			// Built-in types don't have packages, but we don't care about
 			// them anyway.
-			return
+			return true
+		}
+
+		if _, isParam := tn.Type().(*types.TypeParam); isParam {
+			// Type parameters are declared along with the type itself.
+			return true
 		}
 
-		ty := findTypeName(prog, tn)
-		if ty != nil && !table.Has(ty) {
-			addInterestingTypes(ty, table, prog)
+		if opts.Aliases == FollowAliases {
+			if target := aliasTarget(tn); target != nil {
+				tn = target
+			}
 		}
-	}).VisitAll(start.Spec)
+
+		addNamedRef(start, path, tn, table, prog, opts)
+		return true
+	}
+	walkTypeParamRefs(start.Spec.TypeParams, cb)
+
+	start.Flattened = flattenStruct(start, prog, opts)
+	if start.Flattened == nil {
+		walkTypeRefs(start.Spec.Type, "", cb)
+		return
+	}
+	// Fields from flattened structs must be resolved in the package where
+	// they were declared.
+	for _, f := range start.Flattened {
+		info = f.Info
+		walkTypeRefs(&ast.StructType{
+			Fields: &ast.FieldList{
+				List: []*ast.Field{f.Field},
+			},
+		}, "", cb)
+	}
+}
+
+// checkKeptType returns an error if the given type, which was selected to
+// be kept, can't be referred to from the generated code.
+func checkKeptType(ty *takeType) error {
+	pkg := ty.Name.Pkg()
+	switch {
+	case !ty.Name.Exported():
+		return fmt.Errorf("cannot keep %s, because it is not exported", ty.QualifiedName())
+	case pkg.Name() == "main":
+		return fmt.Errorf("cannot keep %s, because package main can't be imported", ty.QualifiedName())
+	case isLocalPackagePath(pkg.Path()):
+		return fmt.Errorf("cannot keep %s, because its package was loaded from a directory that has no import path", ty.QualifiedName())
+	}
+	return nil
+}
+
+// checkRenames returns an error if any of the names chosen by the given
+// options' Rename aren't identifiers, are chosen more than once for the
+// same package, or are already declared in the destination package.
+func checkRenames(opts Options) error {
+	owners := map[string]string{}
+	sources := make([]string, 0, len(opts.Rename))
+	for qualifiedName := range opts.Rename {
+		sources = append(sources, qualifiedName)
+	}
+	sort.Strings(sources)
+	for _, qualifiedName := range sources {
+		newName := opts.Rename[qualifiedName]
+		if !token.IsIdentifier(newName) {
+			return fmt.Errorf("cannot rename %s to %q, which is not an identifier", qualifiedName, newName)
+		}
+		key := newName
+		if opts.Layout == PackagePerPackage {
+			key = lockedPkgPath(qualifiedName) + "." + newName
+		} else if opts.Destination.Declared(newName) != nil {
+			return fmt.Errorf("cannot rename %s to %s, which the destination package already declares", qualifiedName, newName)
+		}
+		if owner, taken := owners[key]; taken {
+			return fmt.Errorf("cannot rename both %s and %s to %s", owner, qualifiedName, newName)
+		}
+		owners[key] = qualifiedName
+	}
+	return nil
+}
+
+// shouldKeep returns true if the given options select the given type to be
+// referred to in its original package rather than copied.
+func shouldKeep(tn *types.TypeName, opts Options) bool {
+	if tn.Pkg() == nil {
+		return false
+	}
+	qualifiedName := tn.Pkg().Path() + "." + tn.Name()
+	for _, name := range opts.Keep {
+		if name == qualifiedName {
+			return true
+		}
+	}
+	return false
 }
 
 func findInterestingConsts(prog *loader.Program, tys typeTable) constantTable {
@@ -211,6 +515,16 @@ func findInterestingConsts(prog *loader.Program, tys typeTable) constantTable {
 	typeNames := tys.NewNames()
 	for _, typeName := range typeNames {
 		ty := tys.TypeByNewName(typeName)
+		if ty.IsAlias() {
+			// Constants declared using an alias really belong to the
+			// target type, and so are copied along with it.
+			continue
+		}
+		if ty.Reused != "" {
+			// The constants of a reused type are the destination
+			// package's business.
+			continue
+		}
 
 		pkgPath := ty.Name.Pkg().Path()
 		info := prog.Package(pkgPath)
@@ -229,7 +543,7 @@ func findInterestingConsts(prog *loader.Program, tys typeTable) constantTable {
 									}
 									value := cd.Val()
 
-									tyObj := cd.Type()
+									tyObj := types.Unalias(cd.Type())
 									if tyObj != ty.Type {
 										continue
 									}
@@ -258,6 +572,7 @@ func rewriteTypeIdents(start ast.Node, info *loader.PackageInfo, table typeTable
 	astVisitor(func(node ast.Node) {
 		switch tn := node.(type) {
 		case *ast.TypeSpec:
+			tn.Type = rewriteTypeExpr(tn.Type, info, table)
 			ident := tn.Name
 			obj := info.Defs[ident]
 			if obj == nil {
@@ -283,6 +598,21 @@ func rewriteTypeIdents(start ast.Node, info *loader.PackageInfo, table typeTable
 			tn.Value = rewriteTypeExpr(tn.Value, info, table)
 		case *ast.StarExpr:
 			tn.X = rewriteTypeExpr(tn.X, info, table)
+		case *ast.IndexExpr:
+			tn.X = rewriteTypeExpr(tn.X, info, table)
+			tn.Index = rewriteTypeExpr(tn.Index, info, table)
+		case *ast.IndexListExpr:
+			tn.X = rewriteTypeExpr(tn.X, info, table)
+			for i, index := range tn.Indices {
+				tn.Indices[i] = rewriteTypeExpr(index, info, table)
+			}
+		case *ast.BinaryExpr:
+			// Union of terms in a type constraint
+			tn.X = rewriteTypeExpr(tn.X, info, table)
+			tn.Y = rewriteTypeExpr(tn.Y, info, table)
+		case *ast.UnaryExpr:
+			// Approximation term (~T) in a type constraint
+			tn.X = rewriteTypeExpr(tn.X, info, table)
 		}
 	}).VisitAll(start)
 }
@@ -290,6 +620,18 @@ func rewriteTypeIdents(start ast.Node, info *loader.PackageInfo, table typeTable
 func rewriteTypeExpr(expr ast.Expr, info *loader.PackageInfo, table typeTable) ast.Expr {
 	switch tn := expr.(type) {
 
+	case *ast.IndexExpr:
+		// An instantiation that was monomorphized is replaced entirely by
+		// the name of the new type.
+		if ty := table.TypeByInstanceRef(typeRefIdent(tn.X)); ty != nil {
+			return table.refExpr(ty)
+		}
+
+	case *ast.IndexListExpr:
+		if ty := table.TypeByInstanceRef(typeRefIdent(tn.X)); ty != nil {
+			return table.refExpr(ty)
+		}
+
 	case *ast.SelectorExpr:
 		ident := tn.Sel
 		obj := info.Uses[ident]
@@ -301,9 +643,7 @@ func rewriteTypeExpr(expr ast.Expr, info *loader.PackageInfo, table typeTable) a
 			if ty == nil {
 				return expr
 			}
-			return &ast.Ident{
-				Name: ty.NewName,
-			}
+			return table.refExpr(ty)
 		}
 
 	case *ast.Ident:
@@ -316,9 +656,7 @@ func rewriteTypeExpr(expr ast.Expr, info *loader.PackageInfo, table typeTable) a
 			if ty == nil {
 				return expr
 			}
-			return &ast.Ident{
-				Name: ty.NewName,
-			}
+			return table.refExpr(ty)
 		}
 
 	}
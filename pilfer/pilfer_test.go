@@ -0,0 +1,112 @@
+package pilfer
+
+import (
+	"bytes"
+	"flag"
+	"go/build"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata with the actual output")
+
+// loadTestdata loads the given sources with testdata as GOPATH, so that
+// the packages in testdata/src can be given by their import paths.
+func loadTestdata(t *testing.T, srcs ...string) *Program {
+	t.Helper()
+	ctxt := build.Default
+	return loadTestdataContext(t, &ctxt, srcs...)
+}
+
+// loadTestdataContext is like loadTestdata but loads with a copy of the
+// given build context, for selecting files by platform or build tags.
+func loadTestdataContext(t *testing.T, ctxt *build.Context, srcs ...string) *Program {
+	t.Helper()
+	gopath, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withGopath := *ctxt
+	withGopath.GOPATH = gopath
+	prog, err := Load(&withGopath, srcs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+// pilferTestdata returns the Go source file that Pilfer generates for the
+// given roots from the given program.
+func pilferTestdata(t *testing.T, prog *Program, roots []Root, opts Options) []byte {
+	t.Helper()
+	if opts.PackageName == "" {
+		opts.PackageName = "gen"
+	}
+	var buf bytes.Buffer
+	if err := prog.Pilfer(roots, &buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// checkGolden compares the given generated output with the golden file
+// testdata/NAME.golden, ignoring its header, which describes the working
+// copy that it was generated from. With the -update flag it writes the
+// golden file instead.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	got = stripHeader(got)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s; got:\n%s", path, got)
+	}
+}
+
+// stripHeader returns the given generated file without the comment lines
+// and blank lines that it begins with.
+func stripHeader(src []byte) []byte {
+	for len(src) > 0 {
+		line := src
+		if nl := bytes.IndexByte(src, '\n'); nl >= 0 {
+			line = src[:nl+1]
+		}
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && !bytes.HasPrefix(trimmed, []byte("//")) {
+			break
+		}
+		src = src[len(line):]
+	}
+	return src
+}
+
+// checkContains reports each of the given snippets that the given output,
+// described by name, doesn't contain.
+func checkContains(t *testing.T, name string, got []byte, snippets ...string) {
+	t.Helper()
+	for _, snippet := range snippets {
+		if !bytes.Contains(got, []byte(snippet)) {
+			t.Errorf("%s doesn't contain %q", name, snippet)
+		}
+	}
+}
+
+// checkNotContains reports each of the given snippets that the given
+// output, described by name, contains.
+func checkNotContains(t *testing.T, name string, got []byte, snippets ...string) {
+	t.Helper()
+	for _, snippet := range snippets {
+		if bytes.Contains(got, []byte(snippet)) {
+			t.Errorf("%s contains %q", name, snippet)
+		}
+	}
+}
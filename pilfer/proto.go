@@ -0,0 +1,583 @@
+package pilfer
+
+import (
+	"bytes"
+	"fmt"
+	"go/constant"
+	"go/types"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// protoTimestamp is the well-known protobuf message type that time.Time
+// fields become.
+const protoTimestamp = "google.protobuf.Timestamp"
+
+// Proto writes to the given writer a proto3 schema for the given root
+// types, as a first draft for moving data in the format described by
+// those types to Protocol Buffers.
+//
+// Each struct type that Pilfer would copy becomes a message, under the name
+// its copy would have, whose fields are those that encoding/json would
+// include, named after their json names. Each type with copied constants
+// becomes an enum, slices become repeated fields, maps become map fields
+// and time.Time becomes google.protobuf.Timestamp. Other named types are
+// replaced by their underlying types.
+//
+// Field numbers, and the numbers of enum values that aren't themselves
+// integers, are taken from opts.FieldNumbers where possible, and otherwise
+// allocated after the highest number used before. The numbers of fields
+// that have been removed are reserved. FieldNumberLock gives the numbers
+// assigned.
+//
+// The result describes each field that was left out because its type has
+// no protobuf equivalent, such as an interface or a slice of slices, and
+// each enum value that can't be represented.
+func (p *Program) Proto(roots []Root, w io.Writer, opts Options) ([]string, error) {
+	b, err := p.buildProto(roots, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	writeHeader(w, p.prog, b.rootTypes, b.sourcePkgs(), opts)
+	fmt.Fprintf(w, "syntax = \"proto3\";\n\npackage %s;\n", b.pkgName)
+	if b.usesTimestamp {
+		fmt.Fprintf(w, "\nimport \"google/protobuf/timestamp.proto\";\n")
+	}
+	for _, name := range b.declNames() {
+		fmt.Fprintf(w, "\n%s", b.decls[name])
+	}
+	return b.problems, nil
+}
+
+// FieldNumberLock returns a lock recording the field numbers that Proto
+// assigns for the given root types, including the numbers it reserves for
+// removed fields, suitable for replacing opts.FieldNumbers after
+// generating a schema with the same options.
+func (p *Program) FieldNumberLock(roots []Root, opts Options) (*FieldNumberLock, error) {
+	b, err := p.buildProto(roots, opts)
+	if err != nil {
+		return nil, err
+	}
+	return b.numbers, nil
+}
+
+func (p *Program) buildProto(roots []Root, opts Options) (*protoBuilder, error) {
+	// Messages and enums all share one namespace.
+	opts.Layout = SingleFile
+	rootTypes, table, err := p.collect(roots, opts)
+	if err != nil {
+		return nil, err
+	}
+	consts := findInterestingConsts(p.prog, table)
+	b := &protoBuilder{
+		prog:       p,
+		table:      table,
+		consts:     consts,
+		constNames: consts.NewNamesByTypeName(),
+		locked:     opts.FieldNumbers,
+		numbers: &FieldNumberLock{
+			Types: make(map[string]map[string]int),
+		},
+		rootTypes: rootTypes,
+		pkgName:   opts.PackageName,
+		decls:     make(map[string]string),
+		declTypes: make(map[string]*takeType),
+	}
+	if b.pkgName == "" {
+		b.pkgName = rootTypes[0].Name.Pkg().Name()
+	}
+
+	for _, root := range rootTypes {
+		named, isNamed := types.Unalias(root.Type).(*types.Named)
+		if !isNamed {
+			continue
+		}
+		ty := b.table.TypeByName(named.Obj())
+		if ty == nil {
+			continue
+		}
+		if _, err := b.declType(ty, named); err != nil {
+			b.problems = append(b.problems, fmt.Sprintf("%s: %s", b.pos(ty.Name), err))
+		}
+	}
+	for len(b.pending) > 0 {
+		ty := b.pending[0]
+		b.pending = b.pending[1:]
+		b.decls[ty.NewName] = b.decl(ty)
+	}
+	return b, nil
+}
+
+// protoBuilder builds the declarations of a protobuf schema, adding one for
+// each message or enum type as it is first referred to.
+type protoBuilder struct {
+	prog       *Program
+	table      typeTable
+	consts     constantTable
+	constNames map[string][]string
+	locked     *FieldNumberLock
+	numbers    *FieldNumberLock
+
+	rootTypes     []*takeType
+	pkgName       string
+	usesTimestamp bool
+
+	decls     map[string]string
+	declTypes map[string]*takeType
+	pending   []*takeType
+	problems  []string
+}
+
+// protoType is the type of a protobuf field.
+type protoType struct {
+	name     string
+	repeated bool
+	isMap    bool
+	message  bool
+
+	// optional is set for a scalar or enum that came from a pointer, and
+	// so has explicit presence.
+	optional bool
+}
+
+func (t protoType) String() string {
+	switch {
+	case t.repeated:
+		return "repeated " + t.name
+	case t.optional:
+		return "optional " + t.name
+	}
+	return t.name
+}
+
+func (b *protoBuilder) pos(obj types.Object) string {
+	return b.prog.prog.Fset.Position(obj.Pos()).String()
+}
+
+// sourcePkgs returns the sorted paths of the packages that declare the
+// types of the messages and enums.
+func (b *protoBuilder) sourcePkgs() []string {
+	seen := map[string]bool{}
+	var ret []string
+	for _, ty := range b.declTypes {
+		pkgPath := ty.Name.Pkg().Path()
+		if !seen[pkgPath] {
+			seen[pkgPath] = true
+			ret = append(ret, pkgPath)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (b *protoBuilder) declNames() []string {
+	names := make([]string, 0, len(b.decls))
+	for name := range b.decls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ref arranges for the declaration of the given type to be built if it
+// hasn't been already.
+func (b *protoBuilder) ref(ty *takeType) {
+	if _, has := b.declTypes[ty.NewName]; !has {
+		b.declTypes[ty.NewName] = ty
+		b.pending = append(b.pending, ty)
+	}
+}
+
+// isEnum returns true if the given type has copied constants, and so
+// becomes an enum.
+func (b *protoBuilder) isEnum(ty *takeType) bool {
+	return len(b.constNames[b.table.nameKey(ty.OutputPkgPath(), ty.NewName)]) > 0
+}
+
+// fieldType returns the protobuf type of a field of the given Go type, or
+// an error if it has none.
+func (b *protoBuilder) fieldType(t types.Type) (protoType, error) {
+	if isTime(t) {
+		b.usesTimestamp = true
+		return protoType{name: protoTimestamp, message: true}, nil
+	}
+
+	switch tt := types.Unalias(t).(type) {
+	case *types.Named:
+		if ty := b.table.TypeByInstance(tt); ty != nil {
+			return b.declType(ty, tt)
+		}
+		if tt.TypeArgs().Len() > 0 {
+			return protoType{}, fmt.Errorf("%s must be monomorphized to have a protobuf equivalent", types.TypeString(tt, nil))
+		}
+		if ty := b.table.TypeByName(tt.Obj()); ty != nil {
+			return b.declType(ty, tt)
+		}
+		return b.fieldType(tt.Underlying())
+	case *types.Basic:
+		return scalarProtoType(tt)
+	case *types.Pointer:
+		elem, err := b.fieldType(tt.Elem())
+		elem.optional = !elem.message && !elem.repeated && !elem.isMap
+		return elem, err
+	case *types.Slice:
+		if isByte(tt.Elem()) {
+			return protoType{name: "bytes"}, nil
+		}
+		return b.repeatedType(tt.Elem())
+	case *types.Array:
+		return b.repeatedType(tt.Elem())
+	case *types.Map:
+		// Enums can't be map keys, but their underlying integers or
+		// strings can.
+		keyBasic, isBasic := tt.Key().Underlying().(*types.Basic)
+		if !isBasic || keyBasic.Info()&(types.IsInteger|types.IsString|types.IsBoolean) == 0 {
+			return protoType{}, fmt.Errorf("map keys of type %s have no protobuf equivalent", tt.Key())
+		}
+		key, err := scalarProtoType(keyBasic)
+		if err != nil {
+			return protoType{}, err
+		}
+		elem, err := b.fieldType(tt.Elem())
+		if err != nil {
+			return protoType{}, err
+		}
+		if elem.repeated || elem.isMap {
+			return protoType{}, fmt.Errorf("maps of slices or maps have no protobuf equivalent")
+		}
+		return protoType{
+			name:  fmt.Sprintf("map<%s, %s>", key.name, elem.name),
+			isMap: true,
+		}, nil
+	case *types.Struct:
+		return protoType{}, fmt.Errorf("anonymous struct types have no protobuf equivalent")
+	case *types.Interface:
+		return protoType{}, fmt.Errorf("interface types have no protobuf equivalent")
+	default:
+		return protoType{}, fmt.Errorf("%s has no protobuf equivalent", t)
+	}
+}
+
+func (b *protoBuilder) repeatedType(elem types.Type) (protoType, error) {
+	t, err := b.fieldType(elem)
+	if err != nil {
+		return protoType{}, err
+	}
+	if t.repeated || t.isMap {
+		return protoType{}, fmt.Errorf("nested slices and slices of maps have no protobuf equivalent")
+	}
+	t.repeated = true
+	t.optional = false
+	return t, nil
+}
+
+// declType returns the protobuf type for the given type from the table,
+// which is a message for a struct type and an enum for a type with
+// constants. Other types are replaced by their underlying types.
+func (b *protoBuilder) declType(ty *takeType, t types.Type) (protoType, error) {
+	if _, isStruct := t.Underlying().(*types.Struct); isStruct {
+		b.ref(ty)
+		return protoType{name: ty.NewName, message: true}, nil
+	}
+	if b.isEnum(ty) {
+		b.ref(ty)
+		return protoType{name: ty.NewName}, nil
+	}
+	return b.fieldType(t.Underlying())
+}
+
+// decl returns the declaration of the message or enum for the given type.
+func (b *protoBuilder) decl(ty *takeType) string {
+	t := ty.Type
+	if ty.Instance != nil {
+		t = ty.Instance
+	}
+	if st, isStruct := t.Underlying().(*types.Struct); isStruct {
+		return b.message(ty, st)
+	}
+	return b.enum(ty)
+}
+
+// protoField is a field of a message.
+type protoField struct {
+	name     string
+	jsonName string
+	typ      protoType
+	number   int
+}
+
+func (b *protoBuilder) message(ty *takeType, st *types.Struct) string {
+	var fields []*protoField
+	used := map[string]bool{}
+	for _, jf := range orderedJSONFields(st) {
+		field, _, _ := fieldByIndex(st, jf.index)
+		typ, err := b.fieldType(field.Type())
+		if err != nil {
+			b.problems = append(b.problems, fmt.Sprintf("%s: %s.%s: %s", b.pos(field), ty.NewName, field.Name(), err))
+			continue
+		}
+		name := protoFieldName(jf.name)
+		if used[name] {
+			b.problems = append(b.problems, fmt.Sprintf("%s: %s.%s: field name %s is already used", b.pos(field), ty.NewName, field.Name(), name))
+			continue
+		}
+		used[name] = true
+		fields = append(fields, &protoField{
+			name:     name,
+			jsonName: jf.name,
+			typ:      typ,
+		})
+	}
+
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = field.name
+	}
+	numbers, reserved := b.allocate(ty.QualifiedName(), names, 1)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "message %s {\n", ty.NewName)
+	for i, field := range fields {
+		fmt.Fprintf(&buf, "  %s %s = %d", field.typ, field.name, numbers[i])
+		if protoJSONName(field.name) != field.jsonName {
+			fmt.Fprintf(&buf, " [json_name = %s]", strconv.Quote(field.jsonName))
+		}
+		buf.WriteString(";\n")
+	}
+	writeReserved(&buf, reserved)
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+func (b *protoBuilder) enum(ty *takeType) string {
+	constNames := b.constNames[b.table.nameKey(ty.OutputPkgPath(), ty.NewName)]
+	prefix := protoEnumValueName(ty.NewName) + "_"
+
+	type enumValue struct {
+		name   string
+		number int
+	}
+	var values []enumValue
+	var reserved []protoReserved
+
+	underlying, _ := ty.Underlying().(*types.Basic)
+	if underlying != nil && underlying.Info()&types.IsInteger != 0 {
+		// Integer constants keep their values, so that the numbers on the
+		// wire are the same as before.
+		hasZero := false
+		for _, constName := range constNames {
+			cn := b.consts.ConstantByNewName(constName)
+			n, exact := constant.Int64Val(cn.Value)
+			if !exact || n < math.MinInt32 || n > math.MaxInt32 {
+				b.problems = append(b.problems, fmt.Sprintf("%s: %s: value %s is out of range for a protobuf enum", b.pos(cn.Const), cn.NewName, cn.Value))
+				continue
+			}
+			hasZero = hasZero || n == 0
+			values = append(values, enumValue{protoEnumName(prefix, cn.Const.Name()), int(n)})
+		}
+		if !hasZero {
+			values = append(values, enumValue{prefix + "UNSPECIFIED", 0})
+		}
+	} else {
+		// Other constants are numbered, with zero left to mean that there
+		// is no value.
+		names := []string{prefix + "UNSPECIFIED"}
+		for _, constName := range constNames {
+			names = append(names, protoEnumName(prefix, b.consts.ConstantByNewName(constName).Const.Name()))
+		}
+		var numbers []int
+		numbers, reserved = b.allocate(ty.QualifiedName(), names[1:], 1)
+		values = append(values, enumValue{names[0], 0})
+		for i, name := range names[1:] {
+			values = append(values, enumValue{name, numbers[i]})
+		}
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].number < values[j].number
+	})
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "enum %s {\n", ty.NewName)
+	for i := 1; i < len(values); i++ {
+		if values[i].number == values[i-1].number {
+			buf.WriteString("  option allow_alias = true;\n")
+			break
+		}
+	}
+	for _, value := range values {
+		fmt.Fprintf(&buf, "  %s = %d;\n", value.name, value.number)
+	}
+	writeReserved(&buf, reserved)
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// protoReserved is a field or enum value that has been removed, whose
+// number and name must not be reused.
+type protoReserved struct {
+	number int
+	name   string
+}
+
+// allocate returns the numbers for the given names of the fields or enum
+// values of the type with the given qualified name, taking them from the
+// lock where possible and otherwise allocating them after the highest
+// number used before, starting from first. It records the numbers in the
+// resulting lock, and returns the locked members that no longer exist so
+// that they can be reserved.
+func (b *protoBuilder) allocate(qualifiedName string, names []string, first int) ([]int, []protoReserved) {
+	locked := b.locked.Numbers(qualifiedName)
+	result := make(map[string]int, len(locked)+len(names))
+	next := first
+	for name, n := range locked {
+		result[name] = n
+		if n >= next {
+			next = n + 1
+		}
+	}
+
+	ret := make([]int, len(names))
+	current := make(map[string]bool, len(names))
+	for i, name := range names {
+		current[name] = true
+		if n, has := result[name]; has {
+			ret[i] = n
+			continue
+		}
+		if next >= protoReservedStart && next <= protoReservedEnd {
+			next = protoReservedEnd + 1
+		}
+		ret[i] = next
+		result[name] = next
+		next++
+	}
+	b.numbers.Types[qualifiedName] = result
+
+	var removed []protoReserved
+	for name, n := range locked {
+		if !current[name] {
+			removed = append(removed, protoReserved{n, name})
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].number < removed[j].number
+	})
+	return ret, removed
+}
+
+// The field numbers that protobuf reserves for its own use.
+const (
+	protoReservedStart = 19000
+	protoReservedEnd   = 19999
+)
+
+// writeReserved writes statements reserving the numbers and names of the
+// given removed members.
+func writeReserved(buf *bytes.Buffer, removed []protoReserved) {
+	for _, r := range removed {
+		fmt.Fprintf(buf, "  reserved %d;\n  reserved %s;\n", r.number, strconv.Quote(r.name))
+	}
+}
+
+func scalarProtoType(b *types.Basic) (protoType, error) {
+	switch b.Kind() {
+	case types.Bool, types.UntypedBool:
+		return protoType{name: "bool"}, nil
+	case types.Int, types.Int64, types.UntypedInt:
+		return protoType{name: "int64"}, nil
+	case types.Int8, types.Int16, types.Int32, types.UntypedRune:
+		return protoType{name: "int32"}, nil
+	case types.Uint, types.Uint64, types.Uintptr:
+		return protoType{name: "uint64"}, nil
+	case types.Uint8, types.Uint16, types.Uint32:
+		return protoType{name: "uint32"}, nil
+	case types.Float32:
+		return protoType{name: "float"}, nil
+	case types.Float64, types.UntypedFloat:
+		return protoType{name: "double"}, nil
+	case types.String, types.UntypedString:
+		return protoType{name: "string"}, nil
+	default:
+		return protoType{}, fmt.Errorf("%s has no protobuf equivalent", b)
+	}
+}
+
+func isTime(t types.Type) bool {
+	named, isNamed := types.Unalias(t).(*types.Named)
+	if !isNamed {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == "time" && obj.Name() == "Time"
+}
+
+// protoFieldName returns the snake_case protobuf field name for the given
+// json field name.
+func protoFieldName(jsonName string) string {
+	return strings.ToLower(snakeCase(jsonName))
+}
+
+// protoEnumName returns the name of the enum value for the constant with
+// the given original name, which must start with the given prefix because
+// enum values share a namespace with the enums themselves.
+func protoEnumName(prefix, constName string) string {
+	name := protoEnumValueName(constName)
+	if !strings.HasPrefix(name, prefix) {
+		name = prefix + name
+	}
+	return name
+}
+
+func protoEnumValueName(name string) string {
+	return strings.ToUpper(snakeCase(name))
+}
+
+// snakeCase splits the given name into words at changes of case and at
+// characters that can't appear in a protobuf identifier, and joins them
+// with underscores.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var buf strings.Builder
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) || r > unicode.MaxASCII {
+			r = '_'
+		}
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				buf.WriteByte('_')
+			}
+		}
+		buf.WriteRune(r)
+	}
+	ret := buf.String()
+	if ret == "" || unicode.IsDigit(rune(ret[0])) {
+		ret = "_" + ret
+	}
+	return ret
+}
+
+// protoJSONName returns the name that a protobuf field with the given name
+// has in JSON unless it is overridden, which is the name in lowerCamelCase.
+func protoJSONName(name string) string {
+	var buf strings.Builder
+	upper := false
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
@@ -0,0 +1,74 @@
+package pilfer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFieldNumberLock(t *testing.T) {
+	prog := loadTestdata(t, "example.com/lock/a")
+	roots := []Root{{Package: "example.com/lock/a", Type: "Config"}}
+
+	var buf bytes.Buffer
+	if _, err := prog.Proto(roots, &buf, Options{PackageName: "gen"}); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "proto_none", buf.Bytes())
+	checkContains(t, "proto_none", buf.Bytes(),
+		// Without a lock, fields are numbered in the order they are
+		// declared.
+		"Kind kind = 1;",
+		"Kind_1 other = 4;",
+		// A string enum without a zero value gets one, but an int enum's
+		// own zero constant is kept.
+		"KIND_UNSPECIFIED = 0;",
+		"KIND_1_KIND_LOCAL = 0;",
+	)
+
+	// The lock keeps the numbers from an earlier schema, in which the
+	// fields were declared in a different order and which had a field
+	// that has since been removed.
+	opts := Options{
+		PackageName: "gen",
+		FieldNumbers: &FieldNumberLock{
+			Types: map[string]map[string]int{
+				"example.com/lock/a.Config": {
+					"status":  1,
+					"removed": 2,
+					"kind":    3,
+				},
+			},
+		},
+	}
+	buf.Reset()
+	if _, err := prog.Proto(roots, &buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "proto_numbers", buf.Bytes())
+	checkContains(t, "proto_numbers", buf.Bytes(),
+		"Kind kind = 3;",
+		"int64 status = 1;",
+		// New fields get numbers that the lock hasn't used, and the
+		// removed field's number and name are reserved.
+		"Config_1 remote = 4;",
+		"Kind_1 other = 5;",
+		"reserved 2;",
+		"reserved \"removed\";",
+	)
+
+	updated, err := prog.FieldNumberLock(roots, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := updated.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "proto_numbers_lock", buf.Bytes())
+
+	// The removed field stays in the lock, so that its number is never
+	// reused.
+	if got := updated.Types["example.com/lock/a.Config"]["removed"]; got != 2 {
+		t.Errorf("removed field has number %d in the updated lock; want 2", got)
+	}
+}
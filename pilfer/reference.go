@@ -0,0 +1,410 @@
+package pilfer
+
+import (
+	"fmt"
+	"go/types"
+	"html/template"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DocFormat selects the markup language of the reference documentation
+// written by Doc.
+type DocFormat int
+
+const (
+	// Markdown writes documentation in GitHub-flavored Markdown.
+	Markdown DocFormat = iota
+
+	// HTML writes documentation as a standalone HTML page.
+	HTML
+)
+
+// Doc writes to the given writer reference documentation for the data
+// format described by the given root types, in the given markup language.
+//
+// There is a section for each type that Pilfer would copy, under the name
+// its copy would have, in the order they are reached from the roots. Each
+// gives the original type and where it is declared, its doc comment, and
+// depending on its kind, a table of the fields that encoding/json would
+// include, the values of its copied constants or its underlying type.
+// References to other documented types are links to their sections.
+func (p *Program) Doc(roots []Root, format DocFormat, w io.Writer, opts Options) error {
+	// Sections all share one namespace.
+	opts.Layout = SingleFile
+	rootTypes, table, err := p.collect(roots, opts)
+	if err != nil {
+		return err
+	}
+	consts := findInterestingConsts(p.prog, table)
+	b := &docBuilder{
+		prog:       p,
+		table:      table,
+		consts:     consts,
+		constNames: consts.NewNamesByTypeName(),
+		comments:   newCommentIndex(p.prog.Fset),
+		seen:       make(map[string]bool),
+	}
+	for _, root := range rootTypes {
+		b.ref(schemaRootType(root, table))
+	}
+
+	var sections []*docSection
+	pkgPaths := []string{}
+	seenPkgs := map[string]bool{}
+	for len(b.pending) > 0 {
+		ty := b.pending[0]
+		b.pending = b.pending[1:]
+		sections = append(sections, b.section(ty))
+		if pkgPath := ty.Name.Pkg().Path(); !seenPkgs[pkgPath] {
+			seenPkgs[pkgPath] = true
+			pkgPaths = append(pkgPaths, pkgPath)
+		}
+	}
+	header := headerLines(p.prog, rootTypes, sortedStrings(pkgPaths), opts)
+
+	if format == HTML {
+		return docHTMLTemplate.Execute(w, map[string]interface{}{
+			"Generated": template.HTML("<!-- " + generatedComment + " -->"),
+			"Header":    template.HTML("<!--\n" + strings.Replace(strings.Join(header, "\n"), "--", "- -", -1) + "\n-->"),
+			"Sections":  sections,
+		})
+	}
+	writeDocMarkdown(w, header, sections)
+	return nil
+}
+
+// docSection is the documentation of a single type.
+type docSection struct {
+	Name     string
+	Original string
+	Position string
+	Comment  string
+
+	// Encoding describes how the type is encoded, for a type that encodes
+	// itself.
+	Encoding string
+
+	// Struct is set for a struct type, whose encoded fields are given by
+	// Fields. Otherwise, either Values or Underlying is set, unless
+	// Encoding is.
+	Struct     bool
+	Fields     []docField
+	Values     []docValue
+	Underlying docTypeExpr
+}
+
+type docField struct {
+	JSONName string
+	Type     docTypeExpr
+	Optional bool
+	Comment  string
+}
+
+type docValue struct {
+	Name    string
+	Value   string
+	Comment string
+}
+
+// docTypeExpr is a Go type expression, divided into segments that are
+// either plain text or the name of a documented type.
+type docTypeExpr []docSegment
+
+type docSegment struct {
+	Text string
+	Link string
+}
+
+func (e *docTypeExpr) add(text, link string) {
+	if n := len(*e); n > 0 && link == "" && (*e)[n-1].Link == "" {
+		(*e)[n-1].Text += text
+		return
+	}
+	*e = append(*e, docSegment{text, link})
+}
+
+// docBuilder builds the sections of reference documentation, adding one for
+// each named type as it is first referred to.
+type docBuilder struct {
+	prog       *Program
+	table      typeTable
+	consts     constantTable
+	constNames map[string][]string
+	comments   *commentIndex
+
+	seen    map[string]bool
+	pending []*takeType
+}
+
+// ref returns the name of the section for the given type, arranging for the
+// section to be built if it hasn't been already.
+func (b *docBuilder) ref(ty *takeType) string {
+	if !b.seen[ty.NewName] {
+		b.seen[ty.NewName] = true
+		b.pending = append(b.pending, ty)
+	}
+	return ty.NewName
+}
+
+func (b *docBuilder) section(ty *takeType) *docSection {
+	t := ty.Type
+	if ty.Instance != nil {
+		t = ty.Instance
+	}
+
+	position := b.prog.prog.Fset.Position(ty.Ident.Pos())
+	s := &docSection{
+		Name:     ty.NewName,
+		Original: ty.QualifiedName(),
+		Position: fmt.Sprintf("%s:%d", path.Join(ty.Name.Pkg().Path(), filepath.Base(position.Filename)), position.Line),
+		Comment:  b.comments.Comment(ty.Ident.Pos()),
+	}
+	if named, isNamed := t.(*types.Named); isNamed && named.TypeArgs().Len() == 0 && named.TypeParams().Len() > 0 {
+		names := make([]string, named.TypeParams().Len())
+		for i := range names {
+			names[i] = named.TypeParams().At(i).Obj().Name()
+		}
+		s.Name += "[" + strings.Join(names, ", ") + "]"
+	}
+
+	if special := specialSchema(t); special != nil {
+		switch {
+		case isTime(t):
+			s.Encoding = "a string containing a date and time in RFC 3339 format"
+		case special.Type == "string":
+			s.Encoding = "a string, by its own MarshalText method"
+		default:
+			s.Encoding = "its own MarshalJSON method"
+		}
+		return s
+	}
+
+	if st, isStruct := t.Underlying().(*types.Struct); isStruct {
+		s.Struct = true
+		for _, jf := range orderedJSONFields(st) {
+			field, tag, viaPointer := fieldByIndex(st, jf.index)
+			_, opts := splitJSONTag(tag)
+			s.Fields = append(s.Fields, docField{
+				JSONName: jf.name,
+				Type:     b.typeExpr(field.Type()),
+				Optional: strings.Contains(opts, ",omitempty") || viaPointer,
+				Comment:  b.comments.Comment(field.Pos()),
+			})
+		}
+		return s
+	}
+
+	key := b.table.nameKey(ty.OutputPkgPath(), ty.NewName)
+	if constNames := b.constNames[key]; len(constNames) > 0 {
+		for _, constName := range constNames {
+			cn := b.consts.ConstantByNewName(constName)
+			s.Values = append(s.Values, docValue{
+				Name:    cn.NewName,
+				Value:   tsLiteral(cn.Value),
+				Comment: b.comments.Comment(cn.Const.Pos()),
+			})
+		}
+		return s
+	}
+
+	s.Underlying = b.typeExpr(t.Underlying())
+	return s
+}
+
+// typeExpr returns the Go type expression for the given type, as it
+// appears in the copied declarations, with links to the sections of the
+// types it refers to.
+func (b *docBuilder) typeExpr(t types.Type) docTypeExpr {
+	var e docTypeExpr
+	b.writeTypeExpr(&e, t)
+	return e
+}
+
+func (b *docBuilder) writeTypeExpr(e *docTypeExpr, t types.Type) {
+	switch tt := types.Unalias(t).(type) {
+	case *types.Named:
+		if ty := b.table.TypeByInstance(tt); ty != nil {
+			e.add(ty.NewName, b.ref(ty))
+			return
+		}
+		if ty := b.table.TypeByName(tt.Origin().Obj()); ty != nil {
+			e.add(ty.NewName, b.ref(ty))
+		} else {
+			obj := tt.Origin().Obj()
+			name := obj.Name()
+			if obj.Pkg() != nil {
+				name = obj.Pkg().Name() + "." + name
+			}
+			e.add(name, "")
+		}
+		if args := tt.TypeArgs(); args.Len() > 0 {
+			e.add("[", "")
+			for i := 0; i < args.Len(); i++ {
+				if i > 0 {
+					e.add(", ", "")
+				}
+				b.writeTypeExpr(e, args.At(i))
+			}
+			e.add("]", "")
+		}
+	case *types.Pointer:
+		e.add("*", "")
+		b.writeTypeExpr(e, tt.Elem())
+	case *types.Slice:
+		e.add("[]", "")
+		b.writeTypeExpr(e, tt.Elem())
+	case *types.Array:
+		e.add(fmt.Sprintf("[%d]", tt.Len()), "")
+		b.writeTypeExpr(e, tt.Elem())
+	case *types.Map:
+		e.add("map[", "")
+		b.writeTypeExpr(e, tt.Key())
+		e.add("]", "")
+		b.writeTypeExpr(e, tt.Elem())
+	default:
+		e.add(types.TypeString(t, func(pkg *types.Package) string {
+			return pkg.Name()
+		}), "")
+	}
+}
+
+// writeDocMarkdown writes the given sections as Markdown, after a header
+// in HTML comments.
+func writeDocMarkdown(w io.Writer, header []string, sections []*docSection) {
+	fmt.Fprintf(w, "<!-- %s -->\n<!--\n%s\n-->\n", generatedComment, strings.Replace(strings.Join(header, "\n"), "--", "- -", -1))
+	for _, s := range sections {
+		fmt.Fprintf(w, "\n<a id=\"%s\"></a>\n## %s\n\n", docAnchor(s.Name), s.Name)
+		fmt.Fprintf(w, "Copied from `%s`, declared at `%s`.\n", s.Original, s.Position)
+		if s.Comment != "" {
+			fmt.Fprintf(w, "\n%s\n", s.Comment)
+		}
+
+		switch {
+		case s.Encoding != "":
+			fmt.Fprintf(w, "\nEncoded as %s.\n", s.Encoding)
+		case s.Struct && len(s.Fields) == 0:
+			fmt.Fprintf(w, "\nEncoded as an empty object.\n")
+		case s.Struct:
+			fmt.Fprintf(w, "\n| JSON name | Go type | Required | Description |\n| --- | --- | --- | --- |\n")
+			for _, f := range s.Fields {
+				required := "required"
+				if f.Optional {
+					required = "optional"
+				}
+				fmt.Fprintf(w, "| `%s` | %s | %s | %s |\n", markdownCell(f.JSONName), f.Type.markdown(), required, markdownCell(f.Comment))
+			}
+		case s.Values != nil:
+			fmt.Fprintf(w, "\n| Name | Value | Description |\n| --- | --- | --- |\n")
+			for _, v := range s.Values {
+				fmt.Fprintf(w, "| `%s` | `%s` | %s |\n", v.Name, markdownCell(v.Value), markdownCell(v.Comment))
+			}
+		default:
+			fmt.Fprintf(w, "\nType: %s\n", s.Underlying.markdown())
+		}
+	}
+}
+
+// markdown returns the type expression as a series of code spans, with
+// those for documented types linked to their sections.
+func (e docTypeExpr) markdown() string {
+	var buf strings.Builder
+	for _, seg := range e {
+		text := "`" + markdownCell(seg.Text) + "`"
+		if seg.Link != "" {
+			text = fmt.Sprintf("[%s](#%s)", text, docAnchor(seg.Link))
+		}
+		buf.WriteString(text)
+	}
+	return buf.String()
+}
+
+// HTML returns the type expression as HTML, with the names of documented
+// types linked to their sections.
+func (e docTypeExpr) HTML() template.HTML {
+	var buf strings.Builder
+	for _, seg := range e {
+		text := template.HTMLEscapeString(seg.Text)
+		if seg.Link != "" {
+			text = fmt.Sprintf("<a href=\"#%s\">%s</a>", docAnchor(seg.Link), text)
+		}
+		buf.WriteString(text)
+	}
+	return template.HTML(buf.String())
+}
+
+// docAnchor returns the anchor for the section with the given name, which
+// may include type parameters.
+func docAnchor(name string) string {
+	if bracket := strings.Index(name, "["); bracket != -1 {
+		name = name[:bracket]
+	}
+	return name
+}
+
+// markdownCell escapes the given text for use within a table cell, where
+// it must all be on one line.
+func markdownCell(text string) string {
+	text = strings.Replace(text, "|", "\\|", -1)
+	text = strings.Replace(text, "\n\n", "<br><br>", -1)
+	return strings.Replace(text, "\n", " ", -1)
+}
+
+func sortedStrings(strs []string) []string {
+	ret := append([]string(nil), strs...)
+	sort.Strings(ret)
+	return ret
+}
+
+var docHTMLTemplate = template.Must(template.New("doc").Funcs(template.FuncMap{
+	"anchor": docAnchor,
+}).Parse(`{{.Generated}}
+{{.Header}}
+<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Reference</title>
+<style>
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.5em; text-align: left; vertical-align: top; }
+</style>
+</head>
+<body>
+{{- range .Sections}}
+
+<section id="{{anchor .Name}}">
+<h2>{{.Name}}</h2>
+<p>Copied from <code>{{.Original}}</code>, declared at <code>{{.Position}}</code>.</p>
+{{- if .Comment}}
+<p>{{.Comment}}</p>
+{{- end}}
+{{- if .Encoding}}
+<p>Encoded as {{.Encoding}}.</p>
+{{- else if and .Struct (not .Fields)}}
+<p>Encoded as an empty object.</p>
+{{- else if .Struct}}
+<table>
+<tr><th>JSON name</th><th>Go type</th><th>Required</th><th>Description</th></tr>
+{{- range .Fields}}
+<tr><td><code>{{.JSONName}}</code></td><td><code>{{.Type.HTML}}</code></td><td>{{if .Optional}}optional{{else}}required{{end}}</td><td>{{.Comment}}</td></tr>
+{{- end}}
+</table>
+{{- else if .Values}}
+<table>
+<tr><th>Name</th><th>Value</th><th>Description</th></tr>
+{{- range .Values}}
+<tr><td><code>{{.Name}}</code></td><td><code>{{.Value}}</code></td><td>{{.Comment}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>Type: <code>{{.Underlying.HTML}}</code></p>
+{{- end}}
+</section>
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,54 @@
+package pilfer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDoc(t *testing.T) {
+	prog := loadTestdata(t, "example.com/enums")
+	roots := []Root{{Package: "example.com/enums", Type: "Config"}}
+
+	// The command line has dashes, which can't appear in pairs within an
+	// HTML comment.
+	opts := Options{Command: "pilfer --doc=markdown"}
+	markdown := docTestdata(t, prog, roots, Markdown, opts)
+	checkGolden(t, "doc_markdown", withoutDocHeader(t, markdown))
+	header := markdown[:len(markdown)-len(withoutDocHeader(t, markdown))]
+	checkContains(t, "doc_markdown header", header, "Command:  pilfer - -doc=markdown")
+	checkNotContains(t, "doc_markdown header", header, "--doc")
+
+	html := docTestdata(t, prog, roots, HTML, Options{})
+	checkContains(t, "doc_html", html,
+		"<!DOCTYPE html>",
+		`<section id="Color">`,
+		`<td><code><a href="#Color">Color</a></code></td>`,
+		`<td><code>[]<a href="#Color">Color</a></code></td>`,
+		`<tr><td><code>fallback</code></td><td><code><a href="#Color">Color</a></code></td><td>optional</td><td></td></tr>`,
+	)
+	if !IsGenerated(html) || !IsGenerated(markdown) {
+		t.Errorf("documentation isn't recognized as generated")
+	}
+}
+
+// docTestdata returns the documentation in the given format that Doc
+// generates for the given roots from the given program and options.
+func docTestdata(t *testing.T, prog *Program, roots []Root, format DocFormat, opts Options) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := prog.Doc(roots, format, &buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withoutDocHeader returns the given documentation without the comment
+// that describes where it came from, like stripHeader for Go source.
+func withoutDocHeader(t *testing.T, src []byte) []byte {
+	t.Helper()
+	end := bytes.Index(src, []byte("-->\n\n"))
+	if end < 0 {
+		t.Fatalf("documentation has no header:\n%s", src)
+	}
+	return src[end+len("-->\n\n"):]
+}
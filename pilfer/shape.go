@@ -0,0 +1,74 @@
+package pilfer
+
+import (
+	"go/types"
+)
+
+// ShapeDiff describes a copied type whose definition is not the same in
+// all of the programs given to CompareShapes.
+type ShapeDiff struct {
+	// Type is the package path and name of the original type, separated
+	// by a period.
+	Type string
+
+	// Shapes has one element for each program, describing the type's
+	// underlying type in that program. An element is empty if the type
+	// would not be copied at all from the corresponding program.
+	Shapes []string
+}
+
+// CompareShapes finds the types that would be copied for the given roots
+// from each of the given programs, and reports any whose definitions
+// differ between programs.
+//
+// This is intended for use with programs loaded from the same source
+// packages under different build contexts, to detect types whose fields
+// depend on the target platform or on build tags.
+func CompareShapes(roots []Root, opts Options, progs ...*Program) ([]ShapeDiff, error) {
+	shapes := make([]map[string]string, len(progs))
+	var order []string
+	seen := map[string]bool{}
+	for i, p := range progs {
+		_, table, err := p.collect(roots, opts)
+		if err != nil {
+			return nil, err
+		}
+		shapes[i] = make(map[string]string)
+		for _, newName := range table.NewNames() {
+			ty := table.TypeByNewName(newName)
+			name := ty.QualifiedName()
+			shapes[i][name] = typeShape(ty)
+			if !seen[name] {
+				order = append(order, name)
+				seen[name] = true
+			}
+		}
+	}
+
+	var ret []ShapeDiff
+	for _, name := range order {
+		diff := ShapeDiff{
+			Type:   name,
+			Shapes: make([]string, len(progs)),
+		}
+		differs := false
+		for i := range progs {
+			diff.Shapes[i] = shapes[i][name]
+			if diff.Shapes[i] != diff.Shapes[0] {
+				differs = true
+			}
+		}
+		if differs {
+			ret = append(ret, diff)
+		}
+	}
+	return ret, nil
+}
+
+// typeShape returns a string describing the underlying type of the given
+// type, with named types qualified by their full package paths.
+func typeShape(ty *takeType) string {
+	return types.TypeString(ty.Type.Underlying(), func(pkg *types.Package) string {
+		return pkg.Path()
+	})
+}
@@ -0,0 +1,64 @@
+package pilfer
+
+import (
+	"go/build"
+	"reflect"
+	"testing"
+)
+
+func TestBuildContext(t *testing.T) {
+	roots := []Root{{Package: "example.com/platform", Type: "Config"}}
+	load := func(goos string, tags ...string) *Program {
+		ctxt := build.Default
+		ctxt.GOOS = goos
+		ctxt.GOARCH = "amd64"
+		ctxt.CgoEnabled = false
+		ctxt.BuildTags = tags
+		return loadTestdataContext(t, &ctxt, "example.com/platform")
+	}
+	linux := load("linux")
+	windows := load("windows")
+	debug := load("linux", "debug")
+
+	// Only the files for the selected platform and tags are copied from.
+	checkContains(t, "linux", pilferTestdata(t, linux, roots, Options{}),
+		"Fd int `json:\"fd\"`",
+		"type Level string",
+	)
+	checkContains(t, "windows", pilferTestdata(t, windows, roots, Options{}),
+		"Handle uintptr `json:\"handle\"`",
+	)
+	checkContains(t, "debug", pilferTestdata(t, debug, roots, Options{}),
+		"type Level int",
+	)
+
+	// Only the types that differ between the programs are reported, with a
+	// shape for each program in the order given.
+	diffs, err := CompareShapes(roots, Options{}, linux, windows, debug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, diff := range diffs {
+		got = append(got, diff.Type)
+		if len(diff.Shapes) != 3 {
+			t.Errorf("%s has %d shapes; want 3", diff.Type, len(diff.Shapes))
+		}
+	}
+	want := []string{"example.com/platform.File", "example.com/platform.Level"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong differing types\ngot:  %q\nwant: %q", got, want)
+	}
+	if level := diffs[1].Shapes; level[0] != level[1] || level[0] == level[2] {
+		t.Errorf("wrong shapes for Level: %q", level)
+	}
+
+	// The same configuration twice has no differences.
+	diffs, err = CompareShapes(roots, Options{}, linux, load("linux"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("unexpected differences between identical configurations: %v", diffs)
+	}
+}
@@ -0,0 +1,142 @@
+package pilfer
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IsLocalSource returns true if the given source argument refers to a
+// directory or to a list of source files, rather than to an import path.
+//
+// Local sources are relative to the working directory, whereas import
+// paths are resolved in GOPATH. Since import paths like
+// "github.com/nats-io/nats.go" can also end in ".go", a list of files that
+// isn't written as a relative or absolute path is recognized only if its
+// first file exists.
+func IsLocalSource(src string) bool {
+	if build.IsLocalImport(src) || filepath.IsAbs(src) {
+		return true
+	}
+	if !strings.HasSuffix(src, ".go") {
+		return false
+	}
+	info, err := os.Stat(strings.Split(src, ",")[0])
+	return err == nil && info.Mode().IsRegular()
+}
+
+// isFileList returns true if the given local source argument is a list of
+// source files rather than a directory, which may also have a name ending
+// in ".go".
+func isFileList(src string) bool {
+	if !strings.HasSuffix(src, ".go") {
+		return false
+	}
+	info, err := os.Stat(strings.Split(src, ",")[0])
+	return err != nil || !info.IsDir()
+}
+
+// localSourceFiles determines how to load a source argument that refers to
+// a directory or a list of source files.
+//
+// If the argument is a directory within GOPATH then it can be imported as
+// normal, and so only its import path is returned. Otherwise, the result
+// is a package path to assign along with the filenames that should be used
+// to create the package.
+func localSourceFiles(ctxt *build.Context, src string) (string, []string, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+
+	if isFileList(src) {
+		filenames := strings.Split(src, ",")
+		for i, filename := range filenames {
+			filenames[i] = filepath.Clean(filename)
+		}
+		return localPackagePath(ctxt, filepath.Dir(filenames[0])), filenames, nil
+	}
+
+	dir := filepath.Clean(src)
+	bp, err := ctxt.ImportDir(dir, 0)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to load package from %s: %s", src, err)
+	}
+	if bp.ImportPath != "." && bp.Root != "" {
+		return bp.ImportPath, nil, nil
+	}
+
+	var filenames []string
+	for _, name := range bp.GoFiles {
+		filenames = append(filenames, filepath.Join(dir, name))
+	}
+	for _, name := range bp.CgoFiles {
+		filenames = append(filenames, filepath.Join(dir, name))
+	}
+	return localPackagePath(ctxt, dir), filenames, nil
+}
+
+// localPackagePath chooses the package path for a package created from
+// source files in the given directory. This is its import path if it is
+// within GOPATH, and otherwise the absolute path of the directory.
+func localPackagePath(ctxt *build.Context, dir string) string {
+	bp, err := ctxt.ImportDir(dir, build.FindOnly)
+	if err == nil && bp.ImportPath != "." && bp.Root != "" {
+		return bp.ImportPath
+	}
+
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return filepath.ToSlash(dir)
+}
+
+// isLocalPackagePath returns true if the given package path is the absolute
+// path of the directory that the package was loaded from, as chosen by
+// localPackagePath, rather than an import path.
+func isLocalPackagePath(pkgPath string) bool {
+	return filepath.IsAbs(filepath.FromSlash(pkgPath))
+}
+
+// DirImportPath returns the import path that a package in the given
+// directory would have, either because it is within GOPATH or because it
+// is within a Go module. The directory need not exist yet, but the nearest
+// existing directory above it is used to find the module.
+func DirImportPath(ctxt *build.Context, dir string) (string, error) {
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	existing := abs
+	for !dirExists(ctxt, existing) && filepath.Dir(existing) != existing {
+		existing = filepath.Dir(existing)
+	}
+	if inModule(existing) {
+		gomod, modPath, err := moduleFile(existing)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(filepath.Dir(gomod), abs)
+		if err != nil {
+			return "", err
+		}
+		if rel == "." {
+			return modPath, nil
+		}
+		return modPath + "/" + filepath.ToSlash(rel), nil
+	}
+
+	for _, srcDir := range ctxt.SrcDirs() {
+		rel, err := filepath.Rel(srcDir, abs)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		return filepath.ToSlash(rel), nil
+	}
+	return "", fmt.Errorf("%s is not within GOPATH or a Go module", dir)
+}
@@ -0,0 +1,46 @@
+package pilfer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsLocalSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pilfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "types.go"), []byte("package types\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"example.com/foo", false},
+		{"github.com/nats-io/nats.go", false},
+		{"./foo", true},
+		{"../foo", true},
+		{dir, true},
+		{"types.go", true},
+		{"types.go,other.go", true},
+		{"missing.go", false},
+	}
+	for _, test := range tests {
+		if got := IsLocalSource(test.src); got != test.want {
+			t.Errorf("IsLocalSource(%q) = %v, want %v", test.src, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import "example.com/flat/meta"
+
+type Config struct {
+	Meta   meta.ObjectMeta `json:"meta"`
+	Server Server          `json:"server"`
+}
+
+type Server struct {
+	Addr string `json:"addr"`
+}
+
+func main() {}
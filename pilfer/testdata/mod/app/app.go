@@ -0,0 +1,9 @@
+// Package app is a module that gets example.com/upstream through a replace
+// directive, so that it can only be loaded through the module graph.
+package app
+
+import "example.com/upstream"
+
+type Settings struct {
+	Upstream upstream.Config `json:"upstream"`
+}
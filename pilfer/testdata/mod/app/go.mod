@@ -0,0 +1,7 @@
+module example.com/app
+
+go 1.16
+
+require example.com/upstream v1.0.0
+
+replace example.com/upstream => ../upstream
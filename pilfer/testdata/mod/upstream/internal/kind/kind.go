@@ -0,0 +1,8 @@
+package kind
+
+type Kind string
+
+const (
+	Local  Kind = "local"
+	Remote Kind = "remote"
+)
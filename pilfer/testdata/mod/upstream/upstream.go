@@ -0,0 +1,8 @@
+package upstream
+
+import "example.com/upstream/internal/kind"
+
+type Config struct {
+	Name string    `json:"name"`
+	Kind kind.Kind `json:"kind"`
+}
@@ -0,0 +1,19 @@
+// Package alias re-exports types from its internal package through
+// aliases, as packages do when they move types elsewhere.
+package alias
+
+import "example.com/alias/internal"
+
+// Config is a root that is an alias.
+type Config = internal.Config
+
+type Options struct {
+	Mode  Mode    `json:"mode"`
+	Names Strings `json:"names"`
+	Inner Config  `json:"inner"`
+}
+
+type Mode = internal.Mode
+
+// Strings is an alias of a type that has no name of its own.
+type Strings = []string
@@ -0,0 +1,19 @@
+package internal
+
+type Config struct {
+	Name string `json:"name"`
+	Mode Mode   `json:"mode"`
+}
+
+type Mode int
+
+const (
+	Slow Mode = iota
+	Normal
+)
+
+// Speed is another name for Mode, so Fast belongs with the constants of
+// Mode.
+type Speed = Mode
+
+const Fast Speed = 2
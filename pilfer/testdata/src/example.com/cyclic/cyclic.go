@@ -0,0 +1,21 @@
+// Package cyclic has types whose values can form cyclic pointer graphs,
+// for testing the DeepCopy and Equal methods generated for them.
+package cyclic
+
+import "time"
+
+type Tree struct {
+	Root   *Node
+	Index  map[string]*Node
+	Stamps []time.Time
+}
+
+type Node struct {
+	Name     string
+	Next     *Node
+	Children []*Node
+	Attrs    map[string][]string
+	Data     []byte
+	Created  time.Time
+	Timeout  *time.Duration
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"example.com/enums"
+)
+
+type local struct {
+	Name string
+}
+
+func save[T any](v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func write(v interface{}) {
+	var buf bytes.Buffer
+	json.NewEncoder(&buf).Encode(v)
+}
+
+func main() {
+	save(&enums.Config{})
+	write([]local{})
+	json.Marshal(struct{ A int }{})
+}
@@ -0,0 +1,46 @@
+package enums
+
+// Config has enum fields of ordinary and bit flag types, some of them in
+// containers.
+type Config struct {
+	Color     Color            `json:"color"`
+	Fallback  Color            `json:"fallback,omitempty"`
+	Palette   []Color          `json:"palette"`
+	Named     map[string]Color `json:"named,omitempty"`
+	Perms     Perm             `json:"perms"`
+	Mode      Mode             `json:"mode"`
+	Data      []byte           `json:"data"`
+	Tags      []string         `json:"tags,omitempty"`
+	Labels    map[string]string
+	Child     *Config       `json:"child,omitempty"`
+	ByColor   map[Color]int `json:"byColor"`
+	Overrides [2]Color      `json:"overrides"`
+}
+
+type Color string
+
+const (
+	Red     Color = "red"
+	Green   Color = "green"
+	Blue    Color = "blue"
+	Crimson       = Red
+)
+
+type Perm uint8
+
+const (
+	PermRead Perm = 1 << iota
+	PermWrite
+	PermExec
+
+	PermAll = PermRead | PermWrite | PermExec
+)
+
+// Mode has only two single bits, which aren't told apart from an ordinary
+// enumeration counting from one.
+type Mode uint8
+
+const (
+	ModeFast Mode = 1 << iota
+	ModeSafe
+)
@@ -0,0 +1,39 @@
+package flat
+
+import "example.com/flat/meta"
+
+type Pod struct {
+	meta.TypeMeta
+	*meta.ObjectMeta
+	Spec   PodSpec `json:"spec"`
+	Status Status  `json:"status"`
+}
+
+type PodSpec struct {
+	Base
+	Name string // shadows Base.Name in Go and JSON
+}
+
+type Base struct {
+	Name  string
+	Image string `json:"image"`
+}
+
+type Status struct {
+	Phase `json:"phase"`
+}
+
+type Phase struct {
+	Value string
+}
+
+type Tied struct {
+	A
+	B
+}
+
+type A struct{ X, Y int }
+type B struct {
+	X int
+	Z int
+}
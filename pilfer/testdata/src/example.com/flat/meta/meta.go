@@ -0,0 +1,20 @@
+package meta
+
+// ObjectMeta is common metadata.
+type ObjectMeta struct {
+	// Name is the object name.
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty" yaml:"ns"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Kind      Kind
+	internal  int
+}
+
+type Kind string
+
+const KindPod Kind = "Pod"
+
+type TypeMeta struct {
+	Kind       string `json:"kind"`
+	APIVersion string `json:"apiVersion"`
+}
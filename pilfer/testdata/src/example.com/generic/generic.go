@@ -0,0 +1,22 @@
+package generic
+
+import "time"
+
+type Page[T any] struct {
+	Items []T     `json:"items"`
+	Next  *Cursor `json:"next"`
+}
+
+type Pair[K comparable, V any] struct {
+	Key   K `json:"key"`
+	Value V `json:"value"`
+}
+
+type Cursor string
+
+type Config struct {
+	Names Page[string]          `json:"names"`
+	Times Page[time.Time]       `json:"times"`
+	Pairs []Pair[string, int]   `json:"pairs"`
+	Other Pair[Cursor, []error] `json:"other"`
+}
@@ -0,0 +1,20 @@
+package graph
+
+// Long reaches Leaf through Middle, whereas Short refers to it directly.
+type Long struct {
+	Middle *Middle
+}
+
+type Middle struct {
+	Items []Leaf
+}
+
+type Short struct {
+	Leaves map[string]Leaf
+}
+
+type Leaf struct {
+	Name string
+}
+
+type Unused struct{}
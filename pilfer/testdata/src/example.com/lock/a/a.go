@@ -0,0 +1,18 @@
+package a
+
+import "example.com/lock/b"
+
+type Config struct {
+	Kind   Kind     `json:"kind"`
+	Remote b.Config `json:"remote"`
+	Status Status   `json:"status"`
+	Other  b.Kind   `json:"other"`
+}
+
+type Kind string
+
+const (
+	KindLocal Kind = "local"
+)
+
+type Status int
@@ -0,0 +1,12 @@
+package b
+
+type Config struct {
+	URL string `json:"url"`
+}
+
+type Kind int
+
+const (
+	KindLocal Kind = iota
+	KindRemote
+)
@@ -0,0 +1,5 @@
+package platform
+
+type File struct {
+	Fd int `json:"fd"`
+}
@@ -0,0 +1,5 @@
+package platform
+
+type File struct {
+	Handle uintptr `json:"handle"`
+}
@@ -0,0 +1,5 @@
+//go:build !debug
+
+package platform
+
+type Level string
@@ -0,0 +1,15 @@
+package platform
+
+// Config has fields whose types depend on the target platform and on
+// build tags.
+type Config struct {
+	Name  string `json:"name"`
+	File  File   `json:"file"`
+	Same  Same   `json:"same"`
+	Level Level  `json:"level"`
+}
+
+// Same is the same on every platform.
+type Same struct {
+	Enabled bool `json:"enabled"`
+}
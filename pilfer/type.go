@@ -13,6 +13,75 @@ type takeType struct {
 	Spec    *ast.TypeSpec
 	Type    types.Type
 	NewName string // Assigned only when inserted into a typeTable
+
+	// Conflict is the type whose new name forced this one to be renamed
+	// with a numeric suffix, or nil if the original name was available.
+	Conflict *takeType
+
+	// ConflictExisting is the qualified name of the existing declaration in
+	// the destination package that forced this type to be renamed, or of
+	// the declaration that the name lock reserves its name for, if any.
+	ConflictExisting string
+
+	// Locked is set if this type was renamed only because the name lock
+	// records a different name for it.
+	Locked bool
+
+	// Renamed is set if this type was given the name chosen for it by
+	// Options.Rename.
+	Renamed bool
+
+	// Reused is the name of the existing type in the destination package
+	// that this type resolves to instead of being copied, or empty if the
+	// type is to be copied.
+	Reused string
+
+	// Kept is set for a type that is referred to in its original package
+	// rather than being copied, in which case Reused is its name there.
+	Kept bool
+
+	// Parent is the reference that caused this type to be added to a
+	// typeTable, or nil if it was added as a root.
+	Parent *typeEdge
+
+	// Instance is set for an instantiation of a generic type that is being
+	// monomorphized into a new, non-generic named type. In that case Name
+	// is a synthetic type name for the new type, while Ident and Spec
+	// belong to the generic type's declaration.
+	Instance *types.Named
+
+	// Flattened is set for a struct type that embeds structs selected for
+	// flattening, giving its fields after flattening.
+	Flattened []*flatField
+}
+
+func (ty *takeType) QualifiedName() string {
+	if ty.Instance != nil {
+		return instanceKey(ty.Instance)
+	}
+	return fmt.Sprintf("%s.%s", ty.Name.Pkg().Path(), ty.Name.Name())
+}
+
+// OutputPkgPath returns the path of the source package whose generated
+// declarations this type's declaration belongs with.
+//
+// That is the package that declares the type, except for a monomorphized
+// instantiation, which belongs with the type that refers to it so that the
+// instantiation can't cause an import cycle between the generated packages.
+func (ty *takeType) OutputPkgPath() string {
+	if ty.Instance != nil && ty.Parent != nil {
+		return ty.Parent.From.OutputPkgPath()
+	}
+	return ty.Name.Pkg().Path()
+}
+
+// ConflictName returns the qualified name of the declaration whose name
+// forced this type to be renamed, or an empty string if it wasn't renamed.
+func (ty *takeType) ConflictName() string {
+	if ty.Conflict != nil {
+		return ty.Conflict.QualifiedName()
+	}
+	return ty.ConflictExisting
 }
 
 func (ty *takeType) IsNamed() bool {
@@ -20,6 +89,12 @@ func (ty *takeType) IsNamed() bool {
 	return isNamed
 }
 
+// IsAlias returns true if the receiver is an alias declaration, like
+// "type Foo = Bar", rather than a definition of a new type.
+func (ty *takeType) IsAlias() bool {
+	return ty.Name.IsAlias()
+}
+
 func (ty *takeType) Underlying() types.Type {
 	tn, isNamed := ty.Type.(*types.Named)
 	if !isNamed {
@@ -29,34 +104,157 @@ func (ty *takeType) Underlying() types.Type {
 }
 
 type typeTable struct {
-	types    map[types.Type]*takeType
-	newNames map[string]*takeType
+	types     map[*types.TypeName]*takeType
+	instances map[string]*takeType
+	newNames  map[string]*takeType
+	edges     map[*types.TypeName][]*typeEdge
+
+	// instanceRefs maps the identifier naming the generic type in each
+	// monomorphized instantiation expression to the type that replaces it.
+	// Identifiers are shared with copies of the syntax tree, whereas the
+	// index expressions themselves are not.
+	instanceRefs map[*ast.Ident]*takeType
+
+	// dest is the destination package, whose existing declarations'
+	// names are not available for new declarations.
+	dest *Destination
+
+	// scoped is set when the declarations from each source package are
+	// generated into a separate package, in which case new names need only
+	// be unique among the declarations from the same source package. The
+	// keys of newNames are then qualified by package path, as returned by
+	// nameKey.
+	scoped bool
+
+	// gen tracks the output package currently being generated, so that
+	// references to types in other output packages can be qualified.
+	gen *genState
+
+	// lock gives the names assigned to declarations on earlier runs, and
+	// reserved maps the keys of those names to the qualified names of the
+	// declarations they belong to, so that no other declaration takes them.
+	lock     *NameLock
+	reserved map[string]string
+
+	// renames gives the names chosen for declarations by Options.Rename,
+	// which are also reserved for them.
+	renames map[string]string
+}
+
+func newTypeTable(opts Options) typeTable {
+	t := typeTable{
+		dest:    opts.Destination,
+		scoped:  opts.Layout == PackagePerPackage,
+		gen:     &genState{},
+		lock:    opts.Lock,
+		renames: opts.Rename,
+
+		types:     make(map[*types.TypeName]*takeType),
+		instances: make(map[string]*takeType),
+		newNames:  make(map[string]*takeType),
+		edges:     make(map[*types.TypeName][]*typeEdge),
+
+		instanceRefs: make(map[*ast.Ident]*takeType),
+		reserved:     make(map[string]string),
+	}
+	if opts.Lock != nil {
+		for qualifiedName, newName := range opts.Lock.Names {
+			t.reserved[t.nameKey(lockedPkgPath(qualifiedName), newName)] = qualifiedName
+		}
+	}
+	for qualifiedName, newName := range opts.Rename {
+		t.reserved[t.nameKey(lockedPkgPath(qualifiedName), newName)] = qualifiedName
+	}
+	return t
 }
 
-func newTypeTable() typeTable {
-	return typeTable{
-		types:    make(map[types.Type]*takeType),
-		newNames: make(map[string]*takeType),
+// chosenName returns the name that the declaration with the given
+// qualified name should have if it's available, which is the one chosen by
+// Options.Rename, or otherwise the one recorded in the name lock, or
+// otherwise its original name, along with whether it was renamed.
+func (t typeTable) chosenName(qualifiedName, original string) (string, bool) {
+	if renamed, has := t.renames[qualifiedName]; has {
+		return renamed, true
 	}
+	if locked, has := t.lock.Name(qualifiedName); has {
+		return locked, false
+	}
+	return original, false
 }
 
 func (t typeTable) Has(ty *takeType) bool {
-	_, has := t.types[ty.Type]
+	_, has := t.types[ty.Name]
 	return has
 }
 
-func (t typeTable) NewNameTaken(newName string) bool {
-	_, has := t.newNames[newName]
-	return has
+// nameKey returns the key that identifies the given new name for a
+// declaration belonging with the given source package, which is the name
+// itself unless the table is scoped.
+func (t typeTable) nameKey(pkgPath, newName string) string {
+	if !t.scoped {
+		return newName
+	}
+	return pkgPath + "." + newName
+}
+
+// NewNameTaken returns true if the new name with the given key, as returned
+// by nameKey, is already in use, or if the name lock reserves it for a
+// declaration other than the one with the given qualified name.
+func (t typeTable) NewNameTaken(key, qualifiedName string) bool {
+	_, has := t.newNames[key]
+	if has || (!t.scoped && t.dest.Declared(key) != nil) {
+		return true
+	}
+	owner, reserved := t.reserved[key]
+	return reserved && owner != qualifiedName
+}
+
+// conflictName returns the qualified name of the existing or reserved
+// declaration that prevents a new declaration from using the name with the
+// given key, when it isn't another new type.
+func (t typeTable) conflictName(key, newName string) string {
+	if !t.scoped && t.dest.Declared(key) != nil {
+		return t.dest.qualifiedName(newName)
+	}
+	return t.reserved[key]
 }
 
 func (t typeTable) Add(ty *takeType) {
-	newName := ty.Name.Name()
-	if _, conflict := t.newNames[newName]; conflict {
+	pkgPath := ty.OutputPkgPath()
+	if ty.Kept {
+		// A kept type is declared in another package, so it takes none
+		// of the names in this one.
+		ty.NewName = ty.Reused
+		t.types[ty.Name] = ty
+		return
+	}
+	if ty.Reused != "" {
+		// A reused type keeps the name of the existing type, which is
+		// already declared and so isn't available to any other type.
+		ty.NewName = ty.Reused
+		t.types[ty.Name] = ty
+		key := t.nameKey(pkgPath, ty.NewName)
+		if _, exists := t.newNames[key]; !exists {
+			t.newNames[key] = ty
+		}
+		return
+	}
+
+	// A name chosen by Options.Rename or recorded in the lock is used
+	// unless something else has taken it, in which case a new one is
+	// allocated as usual.
+	qualifiedName := ty.QualifiedName()
+	newName, renamed := t.chosenName(qualifiedName, ty.Name.Name())
+	if key := t.nameKey(pkgPath, newName); t.NewNameTaken(key, qualifiedName) {
+		if conflict, exists := t.newNames[key]; exists {
+			ty.Conflict = conflict
+		} else {
+			ty.ConflictExisting = t.conflictName(key, newName)
+		}
 		num := 1
 		for {
 			newName = fmt.Sprintf("%s_%d", ty.Name.Name(), num)
-			if _, conflict := t.newNames[newName]; !conflict {
+			if !t.NewNameTaken(t.nameKey(pkgPath, newName), qualifiedName) {
 				break
 			}
 			num++
@@ -64,18 +262,58 @@ func (t typeTable) Add(ty *takeType) {
 	}
 
 	ty.NewName = newName
-	t.types[ty.Type] = ty
-	t.newNames[newName] = ty
+	ty.Renamed = renamed && ty.Conflict == nil && ty.ConflictExisting == ""
+	ty.Locked = !ty.Renamed && ty.Conflict == nil && ty.ConflictExisting == "" && newName != ty.Name.Name()
+	t.types[ty.Name] = ty
+	if ty.Instance != nil {
+		t.instances[instanceKey(ty.Instance)] = ty
+	}
+	t.newNames[t.nameKey(pkgPath, newName)] = ty
 }
 
+// TypeByName returns the table entry for the type with the given name, or
+// nil if there is none.
+//
+// If the given name is an alias that isn't itself in the table then the
+// result is the entry for the alias's target, if any.
 func (t typeTable) TypeByName(name *types.TypeName) *takeType {
-	return t.types[name.Type()]
+	if ty, has := t.types[name]; has {
+		return ty
+	}
+	if target := aliasTarget(name); target != nil {
+		return t.TypeByName(target)
+	}
+	return nil
 }
 
-func (t typeTable) TypeByNewName(newName string) *takeType {
-	return t.newNames[newName]
+// TypeByInstance returns the table entry for the given instantiation of a
+// generic type, or nil if that instantiation is not being monomorphized.
+func (t typeTable) TypeByInstance(named *types.Named) *takeType {
+	return t.instances[instanceKey(named)]
 }
 
+// AddInstanceRef records that the instantiation expression whose generic
+// type is named by the given identifier is to be replaced by the given
+// monomorphized type.
+func (t typeTable) AddInstanceRef(ident *ast.Ident, ty *takeType) {
+	t.instanceRefs[ident] = ty
+}
+
+// TypeByInstanceRef returns the monomorphized type that replaces the
+// instantiation expression whose generic type is named by the given
+// identifier, or nil if that expression is not being replaced.
+func (t typeTable) TypeByInstanceRef(ident *ast.Ident) *takeType {
+	return t.instanceRefs[ident]
+}
+
+// TypeByNewName returns the type with the given new name key, as returned
+// by nameKey, or nil if there is none.
+func (t typeTable) TypeByNewName(key string) *takeType {
+	return t.newNames[key]
+}
+
+// NewNames returns the keys of all of the new names in the table, as
+// returned by nameKey, in sorted order.
 func (t typeTable) NewNames() []string {
 	if len(t.newNames) == 0 {
 		return nil
@@ -87,3 +325,35 @@ func (t typeTable) NewNames() []string {
 	sort.Strings(names)
 	return names
 }
+
+// AddEdge records that type "from" refers to type "to" at the given field
+// path within its definition.
+func (t typeTable) AddEdge(from, to *takeType, path string) *typeEdge {
+	edge := &typeEdge{
+		From: from,
+		To:   to,
+		Path: path,
+	}
+	t.edges[from.Name] = append(t.edges[from.Name], edge)
+	return edge
+}
+
+// Edges returns the references from the given type to other types in the
+// table, in the order they appear in its definition.
+func (t typeTable) Edges(from *takeType) []*typeEdge {
+	return t.edges[from.Name]
+}
+
+// aliasTarget returns the name of the named type that the given alias
+// ultimately refers to, or nil if the given name is not an alias or if its
+// target is not a named type declared in a package.
+func aliasTarget(name *types.TypeName) *types.TypeName {
+	if !name.IsAlias() {
+		return nil
+	}
+	named, isNamed := types.Unalias(name.Type()).(*types.Named)
+	if !isNamed || named.Obj().Pkg() == nil {
+		return nil
+	}
+	return named.Obj()
+}
@@ -0,0 +1,289 @@
+package pilfer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/constant"
+	"go/types"
+	"io"
+	"sort"
+	"strings"
+)
+
+// TypeScript writes to the given writer TypeScript declarations, suitable
+// for a .d.ts file, describing the JSON encoding of the given root types as
+// produced by encoding/json.
+//
+// Each struct type that Pilfer would copy becomes an interface, under the
+// name its copy would have, whose properties are the fields that
+// encoding/json would include, with the fields of embedded structs
+// promoted. Fields with the omitempty option, or promoted from embedded
+// pointers, become optional properties. Pointers, slices and maps may be
+// null, since encoding/json encodes nil ones as null, except that slices
+// and maps in fields with the omitempty option are left out instead. Each
+// type with copied constants becomes a union of their values, unless they
+// are bit flags, as for Options.Enums, which can be combined and so become
+// number. Other named types become type aliases. Generic types stay
+// generic unless they are monomorphized.
+//
+// Types that encode themselves, by implementing json.Marshaler or
+// encoding.TextMarshaler, can't be described and so become unknown, or
+// string, respectively, except that time.Time becomes string.
+func (p *Program) TypeScript(roots []Root, w io.Writer, opts Options) error {
+	// Declarations all share one namespace.
+	opts.Layout = SingleFile
+	rootTypes, table, err := p.collect(roots, opts)
+	if err != nil {
+		return err
+	}
+	consts := findInterestingConsts(p.prog, table)
+	b := &tsBuilder{
+		table:      table,
+		consts:     consts,
+		constNames: consts.NewNamesByTypeName(),
+		decls:      make(map[string]string),
+		declTypes:  make(map[string]*takeType),
+	}
+	for _, root := range rootTypes {
+		b.ref(schemaRootType(root, table))
+	}
+	for len(b.pending) > 0 {
+		ty := b.pending[0]
+		b.pending = b.pending[1:]
+		b.decls[ty.NewName] = b.decl(ty)
+	}
+
+	names := make([]string, 0, len(b.decls))
+	pkgPaths := []string{}
+	seenPkgs := map[string]bool{}
+	for name, ty := range b.declTypes {
+		names = append(names, name)
+		if pkgPath := ty.Name.Pkg().Path(); !seenPkgs[pkgPath] {
+			seenPkgs[pkgPath] = true
+			pkgPaths = append(pkgPaths, pkgPath)
+		}
+	}
+	sort.Strings(names)
+	sort.Strings(pkgPaths)
+
+	writeHeader(w, p.prog, rootTypes, pkgPaths, opts)
+	for i, name := range names {
+		if i > 0 {
+			io.WriteString(w, "\n")
+		}
+		io.WriteString(w, b.decls[name])
+	}
+	return nil
+}
+
+// tsBuilder builds TypeScript declarations, adding one for each named type
+// as it is first referred to.
+type tsBuilder struct {
+	table      typeTable
+	consts     constantTable
+	constNames map[string][]string
+
+	decls     map[string]string
+	declTypes map[string]*takeType
+	pending   []*takeType
+}
+
+// ref returns the name of the declaration of the given type, arranging for
+// the declaration to be built if it hasn't been already.
+func (b *tsBuilder) ref(ty *takeType) string {
+	if _, has := b.declTypes[ty.NewName]; !has {
+		b.declTypes[ty.NewName] = ty
+		b.pending = append(b.pending, ty)
+	}
+	return ty.NewName
+}
+
+// decl returns the declaration of the given named type.
+func (b *tsBuilder) decl(ty *takeType) string {
+	t := ty.Type
+	if ty.Instance != nil {
+		t = ty.Instance
+	}
+
+	params := ""
+	if named, isNamed := t.(*types.Named); isNamed && named.TypeArgs().Len() == 0 && named.TypeParams().Len() > 0 {
+		names := make([]string, named.TypeParams().Len())
+		for i := range names {
+			names[i] = named.TypeParams().At(i).Obj().Name()
+		}
+		params = "<" + strings.Join(names, ", ") + ">"
+	}
+
+	if special, isSpecial := tsSpecialType(t); isSpecial {
+		return fmt.Sprintf("export type %s%s = %s;\n", ty.NewName, params, special)
+	}
+	if st, isStruct := t.Underlying().(*types.Struct); isStruct {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "export interface %s%s {\n", ty.NewName, params)
+		b.writeProperties(&buf, st, "  ")
+		buf.WriteString("}\n")
+		return buf.String()
+	}
+
+	key := b.table.nameKey(ty.OutputPkgPath(), ty.NewName)
+	if constNames := b.constNames[key]; len(constNames) > 0 {
+		consts := make([]*takeConstant, len(constNames))
+		for i, constName := range constNames {
+			consts[i] = b.consts.ConstantByNewName(constName)
+		}
+		values := enumValues(consts)
+		if basic, isBasic := t.Underlying().(*types.Basic); isBasic {
+			if flags := flagValues(basic, values); flags != nil {
+				return fmt.Sprintf("/** %s */\nexport type %s%s = %s;\n", flagsDescription(flags), ty.NewName, params, b.tsType(basic))
+			}
+		}
+		literals := make([]string, len(values))
+		for i, v := range values {
+			literals[i] = tsLiteral(v.first().Value)
+		}
+		return fmt.Sprintf("export type %s%s = %s;\n", ty.NewName, params, strings.Join(literals, " | "))
+	}
+	return fmt.Sprintf("export type %s%s = %s;\n", ty.NewName, params, b.tsType(t.Underlying()))
+}
+
+// writeProperties writes a property for each field of the given struct
+// type that encoding/json would include, each on its own line with the
+// given indent.
+func (b *tsBuilder) writeProperties(buf *bytes.Buffer, st *types.Struct, indent string) {
+	for _, jf := range orderedJSONFields(st) {
+		field, tag, viaPointer := fieldByIndex(st, jf.index)
+		_, opts := splitJSONTag(tag)
+
+		typ := b.tsType(field.Type())
+		if strings.Contains(opts, ",string") && isStringable(field.Type()) {
+			typ = "string"
+		}
+		optional := ""
+		if strings.Contains(opts, ",omitempty") || viaPointer {
+			optional = "?"
+		}
+		if strings.Contains(opts, ",omitempty") {
+			switch types.Unalias(field.Type()).(type) {
+			case *types.Slice, *types.Map:
+				// An empty slice or map is left out rather than encoded
+				// as null.
+				typ = strings.TrimSuffix(typ, " | null")
+			}
+		}
+		fmt.Fprintf(buf, "%s%s%s: %s;\n", indent, tsPropertyName(jf.name), optional, typ)
+	}
+}
+
+// tsType returns the TypeScript type of the JSON encoding of the given
+// type, referring to the declarations of the named types it uses.
+func (b *tsBuilder) tsType(t types.Type) string {
+	if special, isSpecial := tsSpecialType(t); isSpecial {
+		return special
+	}
+
+	switch tt := types.Unalias(t).(type) {
+	case *types.TypeParam:
+		return tt.Obj().Name()
+	case *types.Named:
+		if ty := b.table.TypeByInstance(tt); ty != nil {
+			return b.ref(ty)
+		}
+		if args := tt.TypeArgs(); args.Len() > 0 {
+			origin := b.table.TypeByName(tt.Origin().Obj())
+			if origin == nil {
+				return b.tsType(tt.Underlying())
+			}
+			argTypes := make([]string, args.Len())
+			for i := range argTypes {
+				argTypes[i] = b.tsType(args.At(i))
+			}
+			return fmt.Sprintf("%s<%s>", b.ref(origin), strings.Join(argTypes, ", "))
+		}
+		if ty := b.table.TypeByName(tt.Obj()); ty != nil {
+			return b.ref(ty)
+		}
+		return b.tsType(tt.Underlying())
+	case *types.Basic:
+		info := tt.Info()
+		switch {
+		case info&types.IsBoolean != 0:
+			return "boolean"
+		case info&types.IsNumeric != 0 && info&types.IsComplex == 0:
+			return "number"
+		case info&types.IsString != 0:
+			return "string"
+		default:
+			return "unknown"
+		}
+	case *types.Pointer:
+		return b.tsType(tt.Elem()) + " | null"
+	case *types.Slice:
+		if isByte(tt.Elem()) {
+			return "string | null"
+		}
+		return tsArray(b.tsType(tt.Elem())) + " | null"
+	case *types.Array:
+		return tsArray(b.tsType(tt.Elem()))
+	case *types.Map:
+		return fmt.Sprintf("{ [key: string]: %s } | null", b.tsType(tt.Elem()))
+	case *types.Struct:
+		var buf bytes.Buffer
+		buf.WriteString("{\n")
+		b.writeProperties(&buf, tt, "    ")
+		buf.WriteString("  }")
+		return buf.String()
+	default:
+		// Interfaces can hold anything, and other types can't be encoded.
+		return "unknown"
+	}
+}
+
+// tsSpecialType returns the TypeScript type for a type that encodes
+// itself, and false if the given type doesn't.
+func tsSpecialType(t types.Type) (string, bool) {
+	schema := specialSchema(t)
+	switch {
+	case schema == nil:
+		return "", false
+	case schema.Type == "string":
+		return "string", true
+	default:
+		return "unknown", true
+	}
+}
+
+// tsArray returns an array type with the given element type, which is
+// parenthesized if it is a union.
+func tsArray(elem string) string {
+	if strings.Contains(elem, " | ") {
+		elem = "(" + elem + ")"
+	}
+	return elem + "[]"
+}
+
+// tsLiteral returns the given constant value as a TypeScript literal type
+// for its JSON encoding.
+func tsLiteral(v constant.Value) string {
+	src, err := json.Marshal(constantJSON(v))
+	if err != nil {
+		return "unknown"
+	}
+	return string(src)
+}
+
+// tsPropertyName returns the given JSON property name as it must be written
+// in an interface, which is quoted unless it is a valid identifier.
+func tsPropertyName(name string) string {
+	for i, r := range name {
+		isIdent := r == '_' || r == '$' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9'
+		if !isIdent {
+			src, _ := json.Marshal(name)
+			return string(src)
+		}
+	}
+	if name == "" {
+		return `""`
+	}
+	return name
+}
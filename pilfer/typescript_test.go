@@ -0,0 +1,37 @@
+package pilfer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTypeScript(t *testing.T) {
+	prog := loadTestdata(t, "example.com/enums")
+	roots := []Root{{Package: "example.com/enums", Type: "Config"}}
+
+	var buf bytes.Buffer
+	if err := prog.TypeScript(roots, &buf, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.Bytes()
+	checkGolden(t, "typescript", got)
+
+	checkContains(t, "typescript", got,
+		// Crimson has the same value as Red, which is listed once.
+		`export type Color = "red" | "green" | "blue";`,
+		// Bit flags can be combined, so they are numbers, but two single
+		// bits are an ordinary enumeration.
+		"/** Bit flags: any combination of PermRead (1), PermWrite (2), PermExec (4). */\nexport type Perm = number;",
+		"export type Mode = 1 | 2;",
+		// Nil slices and maps are encoded as null.
+		"palette: Color[] | null;",
+		"Labels: { [key: string]: string } | null;",
+		// Fields that omitempty can leave out are optional, but arrays
+		// are never left out.
+		"tags?: string[];",
+		"named?: { [key: string]: Color };",
+		"overrides: Color[];",
+		"fallback?: Color;",
+		"child?: Config | null;",
+	)
+}
@@ -0,0 +1,241 @@
+package pilfer
+
+import (
+	"fmt"
+	"go/types"
+	"reflect"
+	"strings"
+)
+
+// writeValidate writes the Validate method of the given copied type, if it
+// is a struct or contains values of types with constants, along with the
+// validate method that walks its values.
+//
+// Validate returns an error listing the path of every value that isn't one
+// of the constants of its type. It follows pointers, slices, arrays and
+// maps, checking map keys too, and relies on the IsValid methods of the
+// types with constants, which are written even if Options.Enums isn't set.
+// The zero values of fields with the omitempty option are left out of the
+// JSON encoding, and so aren't checked if their type has constants.
+func (w *methodWriter) writeValidate(ty *takeType) {
+	u := w.table.methodUnderlying(ty)
+	_, isStruct := u.(*types.Struct)
+	needs := w.needsValidate(u, map[*takeType]bool{ty: true})
+	if !isStruct && !needs {
+		return
+	}
+	recv := w.recvType(ty)
+	if isStruct {
+		recv = "*" + recv
+	}
+	recursive := w.recursive[ty]
+
+	fmt.Fprintf(w.buf, "\n// Validate returns an error listing the path of every value within in\n")
+	fmt.Fprintf(w.buf, "// whose type has constants but which isn't one of them, or nil if there\n// are none.\n")
+	fmt.Fprintf(w.buf, "func (in %s) Validate() error {\n", recv)
+	if !needs {
+		fmt.Fprintf(w.buf, "return nil\n}\n")
+		return
+	}
+	seen := ""
+	if recursive {
+		seen = ", make(map[interface{}]bool)"
+	}
+	fmt.Fprintf(w.buf, "var problems []string\nin.validate(\"\", &problems%s)\n", seen)
+	fmt.Fprintf(w.buf, "if len(problems) == 0 {\nreturn nil\n}\n")
+	fmt.Fprintf(w.buf, "return %s.New(%s.Join(problems, \"; \"))\n}\n", w.table.gen.stdImport("errors"), w.table.gen.stdImport("strings"))
+
+	if recursive {
+		fmt.Fprintf(w.buf, "\n// validate adds the problems found by Validate to problems, describing\n// them with paths starting with the given one. Values in seen have\n// already been checked further up a cyclic pointer graph.\n")
+		fmt.Fprintf(w.buf, "func (in %s) validate(path string, problems *[]string, seen map[interface{}]bool) {\n", recv)
+	} else {
+		fmt.Fprintf(w.buf, "\n// validate adds the problems found by Validate to problems, describing\n// them with paths starting with the given one.\n")
+		fmt.Fprintf(w.buf, "func (in %s) validate(path string, problems *[]string) {\n", recv)
+	}
+	w.guarded = recursive
+	if isStruct {
+		fmt.Fprintf(w.buf, "if in == nil {\nreturn\n}\n")
+		if recursive {
+			fmt.Fprintf(w.buf, "if seen[in] {\nreturn\n}\nseen[in] = true\n")
+		}
+		fmt.Fprintf(w.buf, "if path != \"\" {\npath += \".\"\n}\n")
+		for _, f := range w.table.methodFields(ty) {
+			w.validateField(joinPath("path", f.name), "in."+f.name, f.typ, f.tag, 0)
+		}
+	} else {
+		w.validateValue("path", "in", u, 0)
+	}
+	w.guarded = false
+	fmt.Fprintf(w.buf, "}\n")
+}
+
+// validateValue writes statements that add a problem for each value within
+// the value of the given expression, of the given type, whose type has
+// constants but which isn't one of them. The path of the value is the value
+// of the given path expression.
+func (w *methodWriter) validateValue(path, expr string, t types.Type, depth int) {
+	if !w.needsValidate(t, nil) {
+		return
+	}
+	if ty := w.table.copiedType(t); ty != nil && w.enums[ty] {
+		w.validateEnum(path, expr, ty, "")
+		return
+	}
+	if key, inline := w.inlineInstance(t, func(arg types.Type) bool { return w.needsValidate(arg, nil) }); inline {
+		w.inlining[key] = true
+		defer delete(w.inlining, key)
+	} else if ty := w.table.copiedType(t); ty != nil {
+		w.callValidate(path, expr, ty)
+		return
+	}
+
+	switch tt := t.Underlying().(type) {
+	case *types.Pointer:
+		if ty := w.table.copiedType(tt.Elem()); ty != nil && isStructType(tt.Elem()) && !w.enums[ty] {
+			if _, inline := w.inlineInstance(tt.Elem(), func(arg types.Type) bool { return w.needsValidate(arg, nil) }); !inline {
+				w.callValidate(path, expr, ty)
+				return
+			}
+		}
+		fmt.Fprintf(w.buf, "if %s != nil {\n", expr)
+		w.validateValue(path, "*"+expr, tt.Elem(), depth)
+		fmt.Fprintf(w.buf, "}\n")
+	case *types.Slice, *types.Array:
+		elem := tt.(interface{ Elem() types.Type }).Elem()
+		i := depthName("i", depth)
+		fmt.Fprintf(w.buf, "for %s := range %s {\n", i, expr)
+		elemPath := joinPath(path, "[") + "+" + w.table.gen.stdImport("strconv") + ".Itoa(" + i + ")+" + `"]"`
+		w.validateValue(elemPath, selector(expr)+"["+i+"]", elem, depth+1)
+		fmt.Fprintf(w.buf, "}\n")
+	case *types.Map:
+		k, v := depthName("k", depth), depthName("v", depth)
+		elemNeeds := w.needsValidate(tt.Elem(), nil)
+		if elemNeeds {
+			fmt.Fprintf(w.buf, "for %s, %s := range %s {\n", k, v, expr)
+		} else {
+			fmt.Fprintf(w.buf, "for %s := range %s {\n", k, expr)
+		}
+		if ty := w.table.copiedType(tt.Key()); ty != nil && w.enums[ty] {
+			w.validateEnum(path, k, ty, " key")
+		} else {
+			w.validateValue(path, k, tt.Key(), depth+1)
+		}
+		if elemNeeds {
+			elemPath := joinPath(path, "[") + "+" + w.formatKey(tt.Key(), k) + "+" + `"]"`
+			w.validateValue(elemPath, v, tt.Elem(), depth+1)
+		}
+		fmt.Fprintf(w.buf, "}\n")
+	case *types.Struct:
+		for i := 0; i < tt.NumFields(); i++ {
+			f := tt.Field(i)
+			name := f.Name()
+			if f.Embedded() {
+				name = w.table.embeddedFieldName(f.Type())
+			}
+			if name != "_" {
+				w.validateField(joinPath(path, "."+name), selector(expr)+"."+name, f.Type(), tt.Tag(i), depth)
+			}
+		}
+	}
+}
+
+// validateField writes statements like validateValue for a struct field
+// with the given tag, except that the zero value of a field of a type with
+// constants isn't a problem if the field has the omitempty option.
+func (w *methodWriter) validateField(path, expr string, t types.Type, tag string, depth int) {
+	_, opts := splitJSONTag(reflect.StructTag(tag).Get("json"))
+	if ty := w.table.copiedType(t); ty != nil && w.enums[ty] && strings.Contains(opts, ",omitempty") {
+		basic := w.table.methodUnderlying(ty).(*types.Basic)
+		fmt.Fprintf(w.buf, "if %s != %s {\n", expr, zeroLiteral(basic))
+		w.validateEnum(path, expr, ty, "")
+		fmt.Fprintf(w.buf, "}\n")
+		return
+	}
+	w.validateValue(path, expr, t, depth)
+}
+
+// validateEnum writes a statement that adds a problem if the value of the
+// given expression, of the given copied type with constants, isn't one of
+// them. The kind of value is added to the description of the problem.
+func (w *methodWriter) validateEnum(path, expr string, ty *takeType, kind string) {
+	basic := w.table.methodUnderlying(ty).(*types.Basic)
+	fmt.Fprintf(w.buf, "if !%s.IsValid() {\n", receiver(expr))
+	fmt.Fprintf(w.buf, "*problems = append(*problems, %s+%s)\n}\n", joinPath(path, ": invalid "+ty.NewName+kind+" "), w.formatValue(basic, expr))
+}
+
+// callValidate writes statements that add the problems found in the value
+// of the given expression, of the given copied type, using its methods.
+func (w *methodWriter) callValidate(path, expr string, ty *takeType) {
+	sameOutput := !w.table.scoped || ty.OutputPkgPath() == w.table.gen.pkgPath
+	if ty.Reused != "" || !sameOutput {
+		// Only the exported method is available, so its problems are
+		// described relative to this value.
+		fmt.Fprintf(w.buf, "if err := %s.Validate(); err != nil {\n", receiver(expr))
+		fmt.Fprintf(w.buf, "*problems = append(*problems, %s+err.Error())\n}\n", joinPath(path, ": "))
+		return
+	}
+	seen := ""
+	if w.recursive[ty] {
+		seen = ", make(map[interface{}]bool)"
+		if w.guarded {
+			seen = ", seen"
+		}
+	}
+	fmt.Fprintf(w.buf, "%s.validate(%s, problems%s)\n", receiver(expr), path, seen)
+}
+
+// formatKey returns an expression that formats the map key in the given
+// variable, of the given type, for use in a path.
+func (w *methodWriter) formatKey(t types.Type, key string) string {
+	if basic, isBasic := t.Underlying().(*types.Basic); isBasic {
+		return w.formatValue(basic, key)
+	}
+	return w.table.gen.stdImport("fmt") + ".Sprint(" + key + ")"
+}
+
+// needsValidate returns true if a value of the given type can contain
+// values of types with constants, or of reused types with Validate methods.
+func (w *methodWriter) needsValidate(t types.Type, visiting map[*takeType]bool) bool {
+	if ty := w.table.copiedType(t); ty != nil {
+		switch {
+		case w.enums[ty]:
+			return true
+		case ty.Reused != "":
+			return w.existingMethod(ty, "Validate") != nil
+		case visiting[ty]:
+			return false
+		}
+		if visiting == nil {
+			visiting = map[*takeType]bool{}
+		}
+		visiting[ty] = true
+		defer delete(visiting, ty)
+	}
+
+	switch tt := t.Underlying().(type) {
+	case *types.Pointer:
+		return w.needsValidate(tt.Elem(), visiting)
+	case *types.Slice:
+		return w.needsValidate(tt.Elem(), visiting)
+	case *types.Array:
+		return w.needsValidate(tt.Elem(), visiting)
+	case *types.Map:
+		return w.needsValidate(tt.Key(), visiting) || w.needsValidate(tt.Elem(), visiting)
+	case *types.Struct:
+		for i := 0; i < tt.NumFields(); i++ {
+			if w.needsValidate(tt.Field(i).Type(), visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// joinPath returns an expression for the given path expression followed by
+// the given literal text, merging it into a literal that ends the path.
+func joinPath(path, text string) string {
+	if strings.HasSuffix(path, `"`) {
+		return path[:len(path)-1] + text + `"`
+	}
+	return fmt.Sprintf("%s+%q", path, text)
+}
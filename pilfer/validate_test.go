@@ -0,0 +1,30 @@
+package pilfer
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	prog := loadTestdata(t, "example.com/enums")
+	roots := []Root{{Package: "example.com/enums", Type: "Config"}}
+
+	got := pilferTestdata(t, prog, roots, Options{Validate: true})
+	checkGolden(t, "validate", got)
+
+	checkContains(t, "validate", got,
+		// IsValid is written for Validate even without Enums.
+		"func (v Color) IsValid() bool {",
+		"if !in.Color.IsValid() {",
+		// The zero value of an omitempty field is left out of the JSON
+		// encoding, so it isn't checked.
+		"if in.Fallback != \"\" {\n\t\tif !in.Fallback.IsValid() {",
+		// Map keys are checked too.
+		"path+\"ByColor: invalid Color key \"",
+		// Config can refer to itself, so it keeps track of what it has seen.
+		"in.Child.validate(path+\"Child\", problems, seen)",
+	)
+	checkNotContains(t, "validate", got,
+		"func (v Color) String() string {",
+		"if in.Color != \"\" {",
+	)
+}
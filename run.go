@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/apparentlymart/go-pilfer/pilfer"
+	flag "github.com/ogier/pflag"
+)
+
+var configPath = flag.String("config", "pilfer.json", "JSON batch configuration file for the run subcommand")
+var check = flag.Bool("check", false, "with run, verify that all outputs are up to date instead of writing them")
+
+// run implements the "run" subcommand, which executes all of the jobs
+// described in a batch configuration file.
+func run(args []string) {
+	if len(args) != 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	// All of the jobs with the same build settings share a single program,
+	// so that source packages used by more than one job are loaded only
+	// once.
+	rootsByKey := map[string][]pilfer.Root{}
+	for _, j := range cfg.jobs {
+		key := j.buildSettings.Key()
+		rootsByKey[key] = append(rootsByKey[key], j.roots...)
+	}
+	progs := map[string]*pilfer.Program{}
+
+	failed := false
+	for _, j := range cfg.jobs {
+		rel := relPath(j.Output)
+
+		key := j.buildSettings.Key()
+		prog := progs[key]
+		if prog == nil {
+			prog = loadProgram(rootsByKey[key], j.buildSettings)
+			progs[key] = prog
+		}
+
+		err = warnShapeDifferences(j.roots, prog, j.buildSettings, j.options)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", rel, err)
+			failed = true
+			continue
+		}
+
+		outDir := filepath.Dir(j.Output)
+		multiFile := j.options.Layout != pilfer.SingleFile
+		if multiFile {
+			outDir = j.Output
+		}
+
+		pkgName := j.Package
+		if pkgName == "" && j.options.Layout != pilfer.PackagePerPackage && j.format == nil {
+			pkgName, err = inferPackageName(outDir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: error inferring package name: %s\n", rel, err)
+				failed = true
+				continue
+			}
+		}
+
+		opts := j.options
+		opts.Lock, err = readNameLock(j.Lock)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed to read name lock: %s\n", rel, err)
+			failed = true
+			continue
+		}
+		opts.PackageName = pkgName
+		opts.Command = commandLine()
+		opts.Directive = relPath(*configPath)
+
+		if multiFile {
+			if !runFiles(prog, j, outDir, opts) {
+				failed = true
+			}
+			continue
+		}
+		if j.format != nil {
+			if !runFormat(prog, j, opts) {
+				failed = true
+			}
+			continue
+		}
+
+		opts.Destination, err = pilfer.LoadDestination(j.buildSettings.Context(), outDir, j.Output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", rel, err)
+			failed = true
+			continue
+		}
+
+		var buf bytes.Buffer
+		err := prog.Pilfer(j.roots, &buf, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", rel, err)
+			failed = true
+			continue
+		}
+
+		if !runUpdateConsumers(prog, j, outDir, opts) {
+			failed = true
+		}
+
+		src := buf.Bytes()
+		var conflicts []string
+		if j.Merge {
+			src, conflicts, err = mergeOutput(j.Output, src, opts.Destination)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", rel, err)
+				failed = true
+				continue
+			}
+		}
+		for _, conflict := range conflicts {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", rel, conflict)
+			failed = true
+		}
+
+		if *check {
+			existing, err := ioutil.ReadFile(j.Output)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", rel, err)
+				failed = true
+				continue
+			}
+			if !pilfer.SameGenerated(existing, src) {
+				fmt.Fprintf(os.Stderr, "%s: out of date\n", rel)
+				failed = true
+			}
+			if !runNameLock(prog, j, opts) {
+				failed = true
+			}
+			continue
+		}
+
+		err = writeOutputFile(j.Output, src, *force || j.Merge)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed to write output file: %s\n", rel, err)
+			failed = true
+			continue
+		}
+		// The lock only records names that are in the written output, and
+		// the output isn't finished while it has merge conflicts.
+		if len(conflicts) == 0 && !runNameLock(prog, j, opts) {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// runFiles generates the files for a job with a layout other than
+// "single", and either writes them or checks that they are up to date. It
+// returns false if there were any problems, which it has reported.
+func runFiles(prog *pilfer.Program, j *job, outDir string, opts pilfer.Options) bool {
+	ctxt := j.buildSettings.Context()
+	var err error
+	opts.Destination, err = pilfer.LoadDestination(ctxt, outDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", relPath(outDir), err)
+		return false
+	}
+	files, err := generateFiles(prog, j.roots, ctxt, outDir, &opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", relPath(outDir), err)
+		return false
+	}
+	ok := runUpdateConsumers(prog, j, outDir, opts)
+	if j.Merge {
+		err = mergeFiles(files, ctxt, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", relPath(outDir), err)
+			return false
+		}
+	}
+
+	finished := true
+	for _, file := range files {
+		rel := relPath(file.path)
+		for _, conflict := range file.conflicts {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", rel, conflict)
+			ok = false
+			finished = false
+		}
+
+		if *check {
+			existing, err := ioutil.ReadFile(file.path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", rel, err)
+				ok = false
+				continue
+			}
+			if !pilfer.SameGenerated(existing, file.src) {
+				fmt.Fprintf(os.Stderr, "%s: out of date\n", rel)
+				ok = false
+			}
+			continue
+		}
+
+		err := os.MkdirAll(filepath.Dir(file.path), 0755)
+		if err == nil {
+			err = writeOutputFile(file.path, file.src, *force || j.Merge)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed to write output file: %s\n", rel, err)
+			ok = false
+			finished = false
+		}
+	}
+	// As for a single file, the lock waits until all of the output is
+	// written and free of merge conflicts.
+	if (finished || *check) && !runNameLock(prog, j, opts) {
+		ok = false
+	}
+	return ok
+}
+
+// runFormat generates the output for a job in a format other than Go
+// source code, and either writes it or checks that it is up to date. It
+// returns false if there were any problems, which it has reported.
+func runFormat(prog *pilfer.Program, j *job, opts pilfer.Options) bool {
+	rel := relPath(j.Output)
+	var err error
+	opts.FieldNumbers, err = readFieldNumberLock(j.NumberLock)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed to read field number lock: %s\n", rel, err)
+		return false
+	}
+	src, warnings, err := generateFormat(j.format, prog, j.roots, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", rel, err)
+		return false
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", rel, warning)
+	}
+
+	if *check {
+		existing, err := ioutil.ReadFile(j.Output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", rel, err)
+			return false
+		}
+		if !pilfer.SameGenerated(existing, src) {
+			fmt.Fprintf(os.Stderr, "%s: out of date\n", rel)
+			return false
+		}
+	} else {
+		err = writeOutputFile(j.Output, src, *force)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed to write output file: %s\n", rel, err)
+			return false
+		}
+	}
+
+	// The locks only record names and numbers once they are in the
+	// written output.
+	ok := runNameLock(prog, j, opts)
+	if j.NumberLock != "" {
+		src, err := fieldNumberLockSource(prog, j.roots, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", relPath(j.NumberLock), err)
+			return false
+		}
+		if !runLockFile(j.NumberLock, src) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// runUpdateConsumers updates the uses of renamed copies in a job's consumer
+// packages, unless only checking. It returns false if there were any
+// problems, which it has reported.
+func runUpdateConsumers(prog *pilfer.Program, j *job, outDir string, opts pilfer.Options) bool {
+	if *check {
+		return true
+	}
+	problems, err := updateConsumers(prog, j.roots, j.buildSettings.Context(), outDir, j.UpdateConsumers, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed to update consumers: %s\n", relPath(outDir), err)
+		return false
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	return len(problems) == 0
+}
+
+// runNameLock updates the name lock file for a job, if it has one, or
+// with --check verifies that it is up to date. It returns false if there
+// were any problems, which it has reported.
+func runNameLock(prog *pilfer.Program, j *job, opts pilfer.Options) bool {
+	if j.Lock == "" {
+		return true
+	}
+	src, err := nameLockSource(prog, j.roots, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", relPath(j.Lock), err)
+		return false
+	}
+	return runLockFile(j.Lock, src)
+}
+
+// runLockFile replaces the lock file at the given path with the given
+// content, or with --check verifies that it already has that content. It
+// returns false if there were any problems, which it has reported.
+func runLockFile(path string, src []byte) bool {
+	rel := relPath(path)
+	if *check {
+		existing, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", rel, err)
+			return false
+		}
+		if !bytes.Equal(existing, src) {
+			fmt.Fprintf(os.Stderr, "%s: out of date\n", rel)
+			return false
+		}
+		return true
+	}
+
+	err := writeOutputFile(path, src, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed to write lock: %s\n", rel, err)
+		return false
+	}
+	return true
+}
+
+// relPath returns the given path relative to the working directory if
+// possible, for more readable messages.
+func relPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil {
+		return path
+	}
+	return rel
+}